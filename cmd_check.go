@@ -0,0 +1,365 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/jrmycanady/slurp-rtl_433/config"
+	"github.com/jrmycanady/slurp-rtl_433/derive"
+	"github.com/jrmycanady/slurp-rtl_433/device"
+	"github.com/jrmycanady/slurp-rtl_433/dump"
+	"github.com/jrmycanady/slurp-rtl_433/energy"
+	"github.com/jrmycanady/slurp-rtl_433/filter"
+	"github.com/jrmycanady/slurp-rtl_433/rain"
+	"github.com/jrmycanady/slurp-rtl_433/units"
+)
+
+// runCheckConfig validates the configuration file and exits non-zero if any
+// problems are found.
+func runCheckConfig(cmd command, args []string) int {
+	fs := newFlagSet(cmd)
+	cf := newConfigFlags(fs)
+	ping := fs.Bool("ping", false, "Ping the configured sinks.")
+	fs.Parse(args)
+
+	if *cf.path == "" {
+		fmt.Fprintln(os.Stderr, "check-config requires the --config flag")
+		return 2
+	}
+	if n := checkConfig(cf, *ping, os.Stdout); n > 0 {
+		fmt.Printf("found %d problem(s)\n", n)
+		return 1
+	}
+	fmt.Println("no problems found")
+
+	return 0
+}
+
+// checker records the results of each configuration check.
+type checker struct {
+	out      io.Writer
+	problems int
+}
+
+// ok reports a passing check.
+func (c *checker) ok(format string, v ...interface{}) {
+	fmt.Fprintf(c.out, "     [OK] %s\n", fmt.Sprintf(format, v...))
+}
+
+// warn reports something that may be a problem but does not fail the check.
+func (c *checker) warn(format string, v ...interface{}) {
+	fmt.Fprintf(c.out, "   [WARN] %s\n", fmt.Sprintf(format, v...))
+}
+
+// problem reports a failing check.
+func (c *checker) problem(format string, v ...interface{}) {
+	c.problems++
+	fmt.Fprintf(c.out, "[PROBLEM] %s\n", fmt.Sprintf(format, v...))
+}
+
+// checkConfig validates the configuration built from the config file,
+// environment variables and flags of cf and reports each check to out. If
+// ping is true the configured sinks are contacted. The number of problems
+// found is returned.
+func checkConfig(cf *configFlags, ping bool, out io.Writer) int {
+	c := &checker{out: out}
+
+	path := *cf.path
+	cfg, err := cf.build()
+	if err != nil {
+		c.problem("failed to load %s: %s", path, err)
+		return c.problems
+	}
+	c.ok("loaded %s", path)
+	for _, err := range validateConfig(cfg) {
+		c.problem("%s", err)
+	}
+
+	// Any key not decoded is either a typo or an unsupported setting.
+	keys, err := config.UnknownKeys(path)
+	if err != nil {
+		c.problem("failed to look for unknown keys: %s", err)
+	}
+	for _, k := range keys {
+		c.problem("unknown key %s", k)
+	}
+
+	// Meta sections for models that are not supported never match. Meta
+	// sections may also be named after a Derived value.
+	models := make([]string, 0, len(cfg.Meta))
+	for model := range cfg.Meta {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for _, model := range models {
+		_, derived := cfg.Derived[model]
+		if _, ok := device.LookupModel(model); !ok && !derived {
+			c.problem("Meta model %q is not a supported device, see the devices list", model)
+			continue
+		}
+		c.ok("Meta model %q is supported", model)
+
+		for _, name := range device.MetaSetNames(cfg.Meta[model]) {
+			if err := device.ValidateMetaSet(cfg.Meta[model][name]); err != nil {
+				c.problem("Meta set %q of %q is invalid: %s", name, model, err)
+			}
+		}
+	}
+
+	checkCalibration(c, cfg)
+	checkPsychrometrics(c, cfg)
+	checkRain(c, cfg)
+	checkEnergy(c, cfg)
+	checkDerived(c, cfg)
+	checkUnits(c, cfg)
+	checkFilter(c, cfg)
+	checkDataLocation(c, cfg)
+	checkMetaDataPath(c, cfg)
+
+	if ping {
+		if err := dump.PingInfluxDB(cfg); err != nil {
+			c.problem("failed to reach InfluxDB at %s:%d: %s", cfg.InfluxDB.FQDN, cfg.InfluxDB.Port, err)
+		} else {
+			c.ok("reached InfluxDB at %s:%d", cfg.InfluxDB.FQDN, cfg.InfluxDB.Port)
+		}
+	}
+
+	return c.problems
+}
+
+// checkCalibration verifies the Calibration sets are for supported models
+// and valid.
+func checkCalibration(c *checker, cfg config.Config) {
+	models := make([]string, 0, len(cfg.Calibration))
+	for model := range cfg.Calibration {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for _, model := range models {
+		if _, ok := device.LookupModel(model); !ok {
+			c.problem("Calibration model %q is not a supported device, see the devices list", model)
+			continue
+		}
+		names := make([]string, 0, len(cfg.Calibration[model]))
+		for name := range cfg.Calibration[model] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := device.ValidateCalibrationSet(cfg.Calibration[model][name]); err != nil {
+				c.problem("Calibration set %q of %q is invalid: %s", name, model, err)
+				continue
+			}
+			c.ok("Calibration set %q of %q is valid", name, model)
+		}
+	}
+}
+
+// checkPsychrometrics verifies the Psychrometrics are for supported models
+// and valid.
+func checkPsychrometrics(c *checker, cfg config.Config) {
+	models := make([]string, 0, len(cfg.Psychrometrics))
+	for model := range cfg.Psychrometrics {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for _, model := range models {
+		if _, ok := device.LookupModel(model); !ok {
+			c.problem("Psychrometrics model %q is not a supported device, see the devices list", model)
+			continue
+		}
+		if err := device.ValidatePsychrometrics(cfg.Psychrometrics[model]); err != nil {
+			c.problem("Psychrometrics of %q are invalid: %s", model, err)
+			continue
+		}
+		c.ok("Psychrometrics of %q are valid", model)
+	}
+}
+
+// checkRain verifies the Rain sensors are supported models and valid.
+func checkRain(c *checker, cfg config.Config) {
+	models := make([]string, 0, len(cfg.Rain))
+	for model := range cfg.Rain {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for _, model := range models {
+		if _, ok := device.LookupModel(model); !ok {
+			c.problem("Rain model %q is not a supported device, see the devices list", model)
+			continue
+		}
+		if err := rain.Validate(cfg.Rain[model]); err != nil {
+			c.problem("Rain of %q is invalid: %s", model, err)
+			continue
+		}
+		c.ok("Rain of %q is valid", model)
+	}
+}
+
+// checkEnergy verifies the Energy sensors are supported models and valid.
+func checkEnergy(c *checker, cfg config.Config) {
+	models := make([]string, 0, len(cfg.Energy))
+	for model := range cfg.Energy {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for _, model := range models {
+		if _, ok := device.LookupModel(model); !ok {
+			c.problem("Energy model %q is not a supported device, see the devices list", model)
+			continue
+		}
+		if err := energy.Validate(cfg.Energy[model]); err != nil {
+			c.problem("Energy of %q is invalid: %s", model, err)
+			continue
+		}
+		c.ok("Energy of %q is valid", model)
+	}
+}
+
+// checkDerived verifies the Derived values can be computed.
+func checkDerived(c *checker, cfg config.Config) {
+	names := make([]string, 0, len(cfg.Derived))
+	for name := range cfg.Derived {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := derive.Validate(cfg.Derived[name]); err != nil {
+			c.problem("Derived value %q is invalid: %s", name, err)
+			continue
+		}
+		c.ok("Derived value %q is valid", name)
+	}
+}
+
+// checkUnits warns of the fields named in the configuration that the unit
+// system renames before they are read, as those settings never match.
+func checkUnits(c *checker, cfg config.Config) {
+	if cfg.Units == "" {
+		return
+	}
+	renamed := func(setting string, field string) {
+		if to, ok := units.Rename(cfg.Units, field); ok {
+			c.warn("%s names %s, which the %s unit system renames to %s", setting, field, cfg.Units, to)
+		}
+	}
+
+	models := make([]string, 0, len(cfg.Meta))
+	for model := range cfg.Meta {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for _, model := range models {
+		for _, name := range device.MetaSetNames(cfg.Meta[model]) {
+			renamed(fmt.Sprintf("Meta set %q of %q", name, model), cfg.Meta[model][name].Field)
+		}
+	}
+
+	models = models[:0]
+	for model := range cfg.Rain {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for _, model := range models {
+		renamed(fmt.Sprintf("Rain of %q", model), cfg.Rain[model].Field)
+	}
+
+	names := make([]string, 0, len(cfg.Derived))
+	for name := range cfg.Derived {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		inputs := make([]string, 0, len(cfg.Derived[name].Inputs))
+		for input := range cfg.Derived[name].Inputs {
+			inputs = append(inputs, input)
+		}
+		sort.Strings(inputs)
+		for _, input := range inputs {
+			renamed(fmt.Sprintf("Derived input %q of %q", input, name), cfg.Derived[name].Inputs[input].Field)
+		}
+	}
+}
+
+// checkFilter verifies the filter rules compile and warns if allow mode
+// would drop every reading.
+func checkFilter(c *checker, cfg config.Config) {
+	f, err := filter.New(cfg.Filter)
+	if err != nil {
+		c.problem("invalid filter: %s", err)
+		return
+	}
+	for _, d := range f.Describe() {
+		c.ok("filter rule %s", d)
+	}
+	if cfg.Filter.Mode == filter.ModeAllow && len(cfg.Filter.Rules) == 0 {
+		c.warn("filter mode is allow without any rules so every reading is dropped")
+	}
+}
+
+// checkDataLocation verifies the data directory of each enabled source can
+// be read and warns if no log file matching the configured name exists yet.
+func checkDataLocation(c *checker, cfg config.Config) {
+	for _, source := range cfg.DataSources() {
+		if !source.IsEnabled() {
+			c.ok("source %s is disabled", source.Name)
+			continue
+		}
+
+		dir, name := filepath.Split(source.Location)
+		if dir == "" {
+			dir = "."
+		}
+		if name == "" {
+			c.problem("source %s has no file name in location %s", source.Name, source.Location)
+			continue
+		}
+		dirs, err := filepath.Glob(filepath.Clean(dir))
+		if err != nil || len(dirs) == 0 {
+			c.problem("data directory %s of source %s does not exist", dir, source.Name)
+			continue
+		}
+
+		for _, d := range dirs {
+			files, err := ioutil.ReadDir(d)
+			if err != nil {
+				c.problem("data directory %s is not readable: %s", d, err)
+				continue
+			}
+			c.ok("data directory %s is readable", d)
+
+			matches, err := filepath.Glob(filepath.Join(d, name+"*"))
+			if err != nil || len(matches) == 0 {
+				c.warn("no file named %s found in %s out of %d entries, it may not have been created yet", name, d, len(files))
+				continue
+			}
+			c.ok("found %d file(s) matching %s", len(matches), name)
+		}
+	}
+}
+
+// checkMetaDataPath verifies the meta data directory exists and is writable.
+func checkMetaDataPath(c *checker, cfg config.Config) {
+	info, err := os.Stat(cfg.FileMetaDataPath)
+	if err != nil {
+		c.problem("meta data directory %s does not exist: %s", cfg.FileMetaDataPath, err)
+		return
+	}
+	if !info.IsDir() {
+		c.problem("meta data path %s is not a directory", cfg.FileMetaDataPath)
+		return
+	}
+
+	f, err := ioutil.TempFile(cfg.FileMetaDataPath, ".check-config-")
+	if err != nil {
+		c.problem("meta data directory %s is not writable: %s", cfg.FileMetaDataPath, err)
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+	c.ok("meta data directory %s is writable", cfg.FileMetaDataPath)
+}
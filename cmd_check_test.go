@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ogier/pflag"
+)
+
+func TestCheckConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	cfg := `
+dataLocation = "` + dir + `/rtl_433_data.log"
+fileMetaDataPath = "` + dir + `"
+flushDataPointcount = 10
+stateSaveSeconds = 0
+units = "si"
+
+[InfluxDB]
+schema = "wide"
+
+[Meta."Ambient Weather F007TH Thermo-Hygrometer"."attic"]
+Field = "temperature_F"
+Tag = "comfort"
+Buckets = [{Value = "cold"}]
+[Meta."Ambient Weather F007TH Thermo-Hygrometer"."attic".CompEqualTags]
+channel = "2"
+[Meta."Ambient Weather F007TH".attic.Tags]
+room = "attic"
+`
+	if err := ioutil.WriteFile(path, []byte(cfg), 0644); err != nil {
+		t.Fatalf("failed to write config: %s", err)
+	}
+
+	// Environment variables apply as they do to the other commands.
+	metaDir := t.TempDir()
+	t.Setenv("SLURP_FILEMETADATAPATH", metaDir)
+	fs := pflag.NewFlagSet("check-config", pflag.ContinueOnError)
+	cf := newConfigFlags(fs)
+	if err := fs.Parse([]string{"--config=" + path}); err != nil {
+		t.Fatalf("failed to parse flags: %s", err)
+	}
+
+	// Only the problems count toward the total, the warning and passing
+	// check are reported without failing it.
+	problems := []string{
+		"unknown key flushDataPointcount",
+		"stateSaveSeconds must be at least 1",
+		"unknown schema wide",
+		`Meta model "Ambient Weather F007TH" is not a supported device`,
+	}
+	out := &bytes.Buffer{}
+	if n := checkConfig(cf, false, out); n != len(problems) {
+		t.Fatalf("expected %d problems, got %d:\n%s", len(problems), n, out)
+	}
+	for _, want := range problems {
+		if !strings.Contains(out.String(), "[PROBLEM] "+want) {
+			t.Fatalf("missing problem %q in:\n%s", want, out)
+		}
+	}
+	for _, want := range []string{
+		`[WARN] Meta set "attic" of "Ambient Weather F007TH Thermo-Hygrometer" names temperature_F, which the si unit system renames to temperature_C`,
+		"[OK] meta data directory " + metaDir + " is writable",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("missing %q in:\n%s", want, out)
+		}
+	}
+}
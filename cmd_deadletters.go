@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jrmycanady/slurp-rtl_433/deadletter"
+)
+
+// maxSampleLength is the longest sample printed in the dead letter summary.
+const maxSampleLength = 120
+
+// runDeadLetters summarizes the lines that failed to parse by reason and
+// model. The dead letter file from the configuration and its rotations are
+// read unless files are provided.
+func runDeadLetters(cmd command, args []string) int {
+	fs := newFlagSet(cmd)
+	cf := newConfigFlags(fs).addDataFlags()
+	asJSON := fs.Bool("json", false, "Print the summary as JSON.")
+	fs.Parse(args)
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		cfg, err := cf.load()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load configuration: %s\n", err)
+			return 1
+		}
+		if paths, err = deadletter.Files(cfg.DeadLetterPath()); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to find dead letter files: %s\n", err)
+			return 1
+		}
+		if len(paths) == 0 {
+			fmt.Fprintf(os.Stderr, "no dead letter file found at %s\n", cfg.DeadLetterPath())
+			return 0
+		}
+	}
+
+	s, err := deadletter.Summarize(paths...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to summarize dead letters: %s\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(s); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to encode summary: %s\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REASON\tMODEL\tCOUNT\tFIRST SEEN\tLAST SEEN")
+	for _, g := range s.Groups {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", g.Reason, g.Model, g.Count,
+			g.FirstSeen.Local().Format(time.RFC3339), g.LastSeen.Local().Format(time.RFC3339))
+	}
+	w.Flush()
+
+	fmt.Println("\nSamples:")
+	for _, g := range s.Groups {
+		fmt.Printf("  %s %s\n    %s\n", g.Reason, g.Model, truncateSample(g.Sample))
+	}
+	fmt.Printf("\n%d rejected lines in %d groups", s.Total, len(s.Groups))
+	if s.Invalid > 0 {
+		fmt.Printf(", %d unreadable entries skipped", s.Invalid)
+	}
+	fmt.Println()
+
+	return 0
+}
+
+// truncateSample shortens the sample to maxSampleLength.
+func truncateSample(sample string) string {
+	if len(sample) <= maxSampleLength {
+		return sample
+	}
+	return sample[:maxSampleLength] + "..."
+}
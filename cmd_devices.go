@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jrmycanady/slurp-rtl_433/device"
+)
+
+// runDevices prints every supported device model along with the fields
+// read from the rtl_433 output.
+func runDevices(cmd command, args []string) int {
+	fs := newFlagSet(cmd)
+	fs.Parse(args)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, model := range device.ModelNames() {
+		dev, _ := device.LookupModel(model)
+		fmt.Fprintf(w, "%s\t(measurement %s)\n", dev.ModelName, dev.Name)
+		for _, f := range deviceFields(dev) {
+			fmt.Fprintf(w, "  %s\t%s\n", f[0], f[1])
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+
+	return 0
+}
+
+// deviceFields returns the json name and type of every field read from the
+// rtl_433 output for the device. The model and time are not included.
+func deviceFields(dev device.Device) [][2]string {
+	t := reflect.TypeOf(dev.New()).Elem()
+
+	fields := make([][2]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || name == "model" || name == "time" {
+			continue
+		}
+		fields = append(fields, [2]string{name, t.Field(i).Type.String()})
+	}
+
+	return fields
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jrmycanady/slurp-rtl_433/file"
+)
+
+// runDiscovered lists every device heard by a running instance as saved in
+// the meta data directory.
+func runDiscovered(cmd command, args []string) int {
+	fs := newFlagSet(cmd)
+	cf := newConfigFlags(fs).addDataFlags()
+	unsupported := fs.BoolP("unsupported", "u", false, "Only list models that are not supported.")
+	asJSON := fs.Bool("json", false, "Print the devices as JSON including a sample line.")
+	fs.Parse(args)
+
+	cfg, err := cf.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load configuration: %s\n", err)
+		return 1
+	}
+
+	d := file.NewDiscovered(cfg.FileMetaDataPath, true, 0)
+	if err = d.Load(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	devices := d.Devices(*unsupported)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(devices); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to encode devices: %s\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	if len(devices) == 0 {
+		fmt.Fprintf(os.Stderr, "no devices found in %s\n", d.Path())
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MODEL\tID\tCHANNEL\tSUPPORTED\tCOUNT\tRSSI\tFIRST SEEN\tLAST SEEN")
+	for _, dev := range devices {
+		rssi := "-"
+		if dev.RSSI != nil {
+			rssi = fmt.Sprintf("%.1f", *dev.RSSI)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%v\t%d\t%s\t%s\t%s\n", dev.Model, dev.ID, dev.Channel, dev.Supported, dev.Count, rssi,
+			dev.FirstSeen.Local().Format(time.RFC3339), dev.LastSeen.Local().Format(time.RFC3339))
+	}
+	w.Flush()
+
+	return 0
+}
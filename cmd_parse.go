@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jrmycanady/slurp-rtl_433/derive"
+	"github.com/jrmycanady/slurp-rtl_433/device"
+	"github.com/jrmycanady/slurp-rtl_433/dump"
+	"github.com/jrmycanady/slurp-rtl_433/energy"
+	"github.com/jrmycanady/slurp-rtl_433/filter"
+	"github.com/jrmycanady/slurp-rtl_433/logger"
+	"github.com/jrmycanady/slurp-rtl_433/rain"
+)
+
+// maxLineLength is the longest line that will be read from a data file.
+const maxLineLength = 1024 * 1024
+
+// runParse parses rtl_433 json lines from stdin and prints the resulting
+// points in InfluxDB line protocol or JSON. The filter and Meta rules from
+// the configuration are applied, the rain and energy fields of the Rain and
+// Energy sensors are added from the readings given, the points are laid out in the configured
+// InfluxDB schema and the points of any Derived values the readings complete
+// are printed as well.
+func runParse(cmd command, args []string) int {
+	fs := newFlagSet(cmd)
+	cf := newConfigFlags(fs)
+	format := fs.String("format", dump.FormatLine, "The format of the printed points, line or json.")
+	fs.Parse(args)
+
+	if err := dump.ValidFormat(*format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	cfg, err := cf.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load configuration: %s\n", err)
+		return 1
+	}
+	logger.UpdateWithLevelList(os.Stderr, cfg.LogLevels)
+	rules, err := filter.New(cfg.Filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid filter: %s\n", err)
+		return 1
+	}
+	derived := derive.New(cfg)
+	rainfall := rain.NewTracker("", true)
+	usage := energy.NewTracker("", true)
+
+	failures := 0
+	err = scanLines(os.Stdin, func(n int, line []byte) {
+		dp, err := device.ParseDataPoint(line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "line %d: failed to parse: %s\n", n, err)
+			failures++
+			return
+		}
+		if keep, rule := rules.Keep(dp.GetModel(), line); !keep {
+			if rule == "" {
+				rule = "allow mode"
+			}
+			fmt.Fprintf(os.Stderr, "line %d: dropped by filter %s\n", n, rule)
+			return
+		}
+
+		p, err := device.BuildPoint(dp, cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "line %d: failed to build point: %s\n", n, err)
+			failures++
+			return
+		}
+		if p, err = rainfall.Observe(cfg.Rain, dp.GetModel(), p); err != nil {
+			fmt.Fprintf(os.Stderr, "line %d: failed to add rain: %s\n", n, err)
+			failures++
+		}
+		if p, err = usage.Observe(cfg.Energy, dp.GetModel(), p); err != nil {
+			fmt.Fprintf(os.Stderr, "line %d: failed to add energy: %s\n", n, err)
+			failures++
+		}
+		points, err := dump.ApplySchema(cfg.InfluxDB.Schema, p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "line %d: failed to apply %s schema: %s\n", n, cfg.InfluxDB.Schema, err)
+			failures++
+		}
+		points = append(points, derived.Observe(dp.GetModel(), p)...)
+		for _, p := range points {
+			if err := dump.RenderPoint(os.Stdout, p, *format); err != nil {
+				fmt.Fprintf(os.Stderr, "line %d: failed to print point: %s\n", n, err)
+				failures++
+			}
+		}
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read stdin: %s\n", err)
+		return 1
+	}
+
+	if failures > 0 {
+		return 1
+	}
+	return 0
+}
+
+// scanLines calls fn with each non empty line read from r along with the
+// line number.
+func scanLines(r io.Reader, fn func(n int, line []byte)) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 4096), maxLineLength)
+
+	n := 0
+	for s.Scan() {
+		n++
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		fn(n, line)
+	}
+
+	return s.Err()
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jrmycanady/slurp-rtl_433/device"
+	"github.com/jrmycanady/slurp-rtl_433/dump"
+	"github.com/jrmycanady/slurp-rtl_433/file"
+	"github.com/jrmycanady/slurp-rtl_433/filter"
+	"github.com/jrmycanady/slurp-rtl_433/logger"
+)
+
+// replayTimeLayouts are the accepted layouts of the --since and --until flags.
+var replayTimeLayouts = []string{time.RFC3339, device.TimeLayout, "2006-01-02"}
+
+// runReplay sends every reading in the files provided through the pipeline
+// once. Readings may be limited to a time range and set of models. The
+// offsets, rain counters and energy totals kept in the meta data directory
+// are not read or modified.
+func runReplay(cmd command, args []string) int {
+	fs := newFlagSet(cmd)
+	cf := newConfigFlags(fs).addInfluxDBFlags().addDryRunFlags()
+	since := fs.String("since", "", "Only replay readings at or after this time.")
+	until := fs.String("until", "", "Only replay readings before this time.")
+	models := fs.String("model", "", "A comma separated list of models to replay. All models are replayed if empty.")
+	progressSeconds := fs.Int("progress-seconds", 10, "The seconds between progress reports.")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	replay, err := newReplayFilter(*since, *until, *models)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	cfg, err := cf.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load configuration: %s\n", err)
+		return 1
+	}
+	rules, err := filter.New(cfg.Filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid filter: %s\n", err)
+		return 1
+	}
+	output, err := buildLogger(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to start logging: %s\n", err)
+		return 1
+	}
+	defer output.Close()
+
+	dumpChan := make(chan device.DataPoint)
+	dumper := dump.NewDumper(cfg, dumpChan)
+	dumper.KeepStateInMemory()
+	if err := dumper.StartDump(); err != nil {
+		logger.Error.Printf("failed to start dumper: %s", err)
+		return 1
+	}
+	if !cfg.DryRun {
+		logger.Info.Printf("replaying into InfluxDB database %s", cfg.InfluxDB.Database)
+	}
+
+	progress := newReplayProgress(fs.Args())
+	progressCancel := make(chan struct{})
+	go progress.run(progressCancel, time.Duration(*progressSeconds)*time.Second, dumper)
+
+	exitCode := 0
+	for _, path := range fs.Args() {
+		if err := replayFile(path, replay, rules, progress, dumpChan); err != nil {
+			logger.Error.Printf("failed to replay %s: %s", path, err)
+			exitCode = 1
+		}
+	}
+
+	if err := dumper.StopDump(); err != nil {
+		logger.Error.Printf("failed to write the last points: %s", err)
+		exitCode = 1
+	}
+	close(progressCancel)
+	logger.Info.Printf("replay complete, %s", progress.report(dumper.Status()))
+
+	return exitCode
+}
+
+// replayFilter selects the readings to replay.
+type replayFilter struct {
+	// since is the earliest time replayed. It is ignored if zero.
+	since time.Time
+
+	// until is the time all readings must be before. It is ignored if zero.
+	until time.Time
+
+	// models are the models replayed. All models are replayed if empty.
+	models map[string]bool
+}
+
+// newReplayFilter builds a replayFilter from the flag values provided.
+func newReplayFilter(since string, until string, models string) (replayFilter, error) {
+	var err error
+	f := replayFilter{models: make(map[string]bool)}
+
+	if f.since, err = parseReplayTime(since); err != nil {
+		return f, fmt.Errorf("invalid --since: %s", err)
+	}
+	if f.until, err = parseReplayTime(until); err != nil {
+		return f, fmt.Errorf("invalid --until: %s", err)
+	}
+	if !f.since.IsZero() && !f.until.IsZero() && !f.until.After(f.since) {
+		return f, fmt.Errorf("--until must be after --since")
+	}
+
+	for _, m := range strings.Split(models, ",") {
+		if m = strings.TrimSpace(m); m == "" {
+			continue
+		}
+		if _, ok := device.LookupModel(m); !ok {
+			return f, fmt.Errorf("unsupported model %s, see the devices command", m)
+		}
+		f.models[m] = true
+	}
+
+	return f, nil
+}
+
+// parseReplayTime parses s using any of the replayTimeLayouts. The zero time
+// is returned if s is empty.
+func parseReplayTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range replayTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%s does not match any of %s", s, strings.Join(replayTimeLayouts, ", "))
+}
+
+// match returns true if the datapoint should be replayed.
+func (f replayFilter) match(dp device.DataPoint) (bool, error) {
+	if len(f.models) > 0 && !f.models[dp.GetModel()] {
+		return false, nil
+	}
+	if f.since.IsZero() && f.until.IsZero() {
+		return true, nil
+	}
+
+	t, err := device.DataPointTime(dp)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse time: %s", err)
+	}
+	if !f.since.IsZero() && t.Before(f.since) {
+		return false, nil
+	}
+	if !f.until.IsZero() && !t.Before(f.until) {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// replayProgress tracks the progress of a replay. The counters are updated
+// atomically.
+type replayProgress struct {
+	start      time.Time
+	totalBytes int64
+	bytesRead  int64
+	sent       int64
+	skipped    int64
+	filtered   int64
+	failed     int64
+}
+
+// newReplayProgress creates a replayProgress for the files at paths. Files
+// that can not be read are left out of the total size.
+func newReplayProgress(paths []string) *replayProgress {
+	p := &replayProgress{start: time.Now()}
+	for _, path := range paths {
+		if stat, err := os.Stat(path); err == nil {
+			p.totalBytes += stat.Size()
+		}
+	}
+
+	return p
+}
+
+// report returns a description of the progress and throughput so far.
+func (p *replayProgress) report(s dump.DumperStatus) string {
+	elapsed := time.Since(p.start).Seconds()
+	sent := atomic.LoadInt64(&p.sent)
+
+	percent := float64(100)
+	if p.totalBytes > 0 {
+		percent = float64(atomic.LoadInt64(&p.bytesRead)) / float64(p.totalBytes) * 100
+	}
+
+	return fmt.Sprintf("%.1f%% of %d bytes read in %.0fs, sent %d readings (%.2f/s), skipped %d, filtered %d, %d failed to parse, wrote %d points",
+		percent, p.totalBytes, elapsed, sent, float64(sent)/elapsed,
+		atomic.LoadInt64(&p.skipped), atomic.LoadInt64(&p.filtered), atomic.LoadInt64(&p.failed), s.PointsWritten)
+}
+
+// run logs the progress every interval until cancelChan is closed.
+func (p *replayProgress) run(cancelChan <-chan struct{}, interval time.Duration, d *dump.Dumper) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			logger.Info.Printf("replay progress, %s", p.report(d.Status()))
+		case <-cancelChan:
+			return
+		}
+	}
+}
+
+// countingReader counts the bytes read from r into n.
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+// Read reads from the underlying reader and counts the bytes.
+func (c countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+// replayFile parses every line in the file at path and sends the readings
+// matching replay and kept by rules to dataPointChan. The results are counted
+// in progress.
+func replayFile(path string, replay replayFilter, rules *filter.Filter, progress *replayProgress, dataPointChan chan<- device.DataPoint) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Compressed rotations are decompressed while reading. Progress counts
+	// the compressed bytes to match the total size.
+	r, err := file.Decompress(path, countingReader{f, &progress.bytesRead})
+	if err != nil {
+		return fmt.Errorf("failed to decompress: %s", err)
+	}
+	defer r.Close()
+
+	logger.Info.Printf("replaying %s", path)
+	return scanLines(r, func(n int, line []byte) {
+		dp, err := device.ParseDataPoint(line)
+		if err != nil {
+			logger.Verbose.Printf("%s line %d: failed to parse: %s", path, n, err)
+			atomic.AddInt64(&progress.failed, 1)
+			return
+		}
+
+		ok, err := replay.match(dp)
+		if err != nil {
+			logger.Verbose.Printf("%s line %d: %s", path, n, err)
+			atomic.AddInt64(&progress.failed, 1)
+			return
+		}
+		if !ok {
+			atomic.AddInt64(&progress.skipped, 1)
+			return
+		}
+		if keep, _ := rules.Keep(dp.GetModel(), line); !keep {
+			atomic.AddInt64(&progress.filtered, 1)
+			return
+		}
+
+		dataPointChan <- dp
+		atomic.AddInt64(&progress.sent, 1)
+	})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jrmycanady/slurp-rtl_433/device"
+)
+
+func TestReplayFilter(t *testing.T) {
+	f, err := newReplayFilter("2018-07-05 01:07:45", "2018-07-05T01:08:00Z", device.AmbientWeatherModelName)
+	if err != nil {
+		t.Fatalf("failed to build filter: %s", err)
+	}
+
+	for _, c := range []struct {
+		line string
+		want bool
+	}{
+		{`{"time" : "2018-07-05 01:07:43", "model" : "Ambient Weather F007TH Thermo-Hygrometer", "channel" : 1}`, false},
+		{`{"time" : "2018-07-05 01:07:47", "model" : "Ambient Weather F007TH Thermo-Hygrometer", "channel" : 2}`, true},
+		{`{"time" : "2018-07-05 01:08:00", "model" : "Ambient Weather F007TH Thermo-Hygrometer", "channel" : 2}`, false},
+		{`{"time" : "2018-07-05 01:07:50", "model" : "Acurite 986 Sensor", "id" : 1}`, false},
+	} {
+		dp, err := device.ParseDataPoint([]byte(c.line))
+		if err != nil {
+			t.Fatalf("failed to parse %s: %s", c.line, err)
+		}
+		got, err := f.match(dp)
+		if err != nil {
+			t.Fatalf("failed to match %s: %s", c.line, err)
+		}
+		if got != c.want {
+			t.Errorf("expected %v for %s", c.want, c.line)
+		}
+	}
+
+	for _, args := range [][3]string{
+		{"yesterday", "", ""},
+		{"2018-07-05", "2018-07-04", ""},
+		{"", "", "Not A Sensor"},
+	} {
+		if _, err := newReplayFilter(args[0], args[1], args[2]); err == nil {
+			t.Errorf("expected error for %v", args)
+		}
+	}
+}
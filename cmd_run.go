@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jrmycanady/slurp-rtl_433/config"
+	"github.com/jrmycanady/slurp-rtl_433/device"
+	"github.com/jrmycanady/slurp-rtl_433/dump"
+	"github.com/jrmycanady/slurp-rtl_433/file"
+	"github.com/jrmycanady/slurp-rtl_433/logger"
+	"github.com/jrmycanady/slurp-rtl_433/status"
+	"github.com/jrmycanady/slurp-rtl_433/systemd"
+)
+
+// runRun monitors the data location and sends all readings to the sinks
+// until a term signal is received.
+func runRun(cmd command, args []string) int {
+	fs := newFlagSet(cmd)
+	cf := newConfigFlags(fs).addDataFlags().addInfluxDBFlags().addDryRunFlags()
+	fs.Parse(args)
+
+	// Loading configuration from file and args.
+	globalConfig, err := cf.load()
+	if err != nil {
+		fmt.Printf("failed to load configuration: %s\n", err)
+		return 1
+	}
+
+	// Configuring the logger to output file or stdout.
+	output, err := buildLogger(globalConfig)
+	if err != nil {
+		fmt.Printf("failed to start logging: %s\n", err)
+		return 1
+	}
+	defer func() { output.Close() }()
+
+	// Build signal channel to catch term signal.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	logger.Info.Println("starting dumper")
+	dumpChan := make(chan device.DataPoint)
+	dumper := dump.NewDumper(globalConfig, dumpChan)
+	if err := dumper.StartDump(); err != nil {
+		logger.Error.Printf("failed to start dumper: %s", err)
+		return 1
+	}
+
+	f := file.NewFiler(globalConfig, dumpChan)
+	if err := f.Start(); err != nil {
+		logger.Error.Printf("failed to start filer: %s", err)
+		return 1
+	}
+
+	// Starting the status server if enabled.
+	var statusServer *status.Server
+	if globalConfig.Status.Enabled {
+		statusServer = status.NewServer(globalConfig.Status, f, dumper)
+		if err := statusServer.Start(); err != nil {
+			logger.Error.Printf("failed to start status server: %s", err)
+			return 1
+		}
+	}
+
+	// Notifying systemd that startup is complete and starting the watchdog.
+	notifier := systemd.NewNotifier()
+	if err := notifier.Notify(systemd.Ready); err != nil {
+		logger.Error.Printf("failed to notify systemd: %s", err)
+	}
+	notifierCancel := make(chan struct{})
+	go notifier.Run(notifierCancel, time.Duration(30)*time.Second, pipelineStatus(dumper), pipelineProgress(f, dumper, notifier.WatchdogInterval()))
+
+	// Waiting for term signal to gracefully shutdown. A hangup signal reopens
+	// the log file and reloads the configuration.
+	startupConfig := globalConfig
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		globalConfig, output = reload(cf, startupConfig, globalConfig, output, f, dumper, notifier)
+	}
+	logger.Info.Println("received term signal, shutting down now")
+	close(notifierCancel)
+	if err := notifier.Notify(systemd.Stopping); err != nil {
+		logger.Error.Printf("failed to notify systemd: %s", err)
+	}
+
+	// Stop status server.
+	if statusServer != nil {
+		logger.Info.Println("stopping status server")
+		statusServer.Stop()
+	}
+
+	// Stop filer.
+	logger.Info.Println("stopping filer")
+	f.Stop()
+
+	// Stop dumper.
+	logger.Info.Println("stopping dumper")
+	if err := dumper.StopDump(); err != nil {
+		logger.Error.Printf("failed to stop dumper cleanly: %s", err)
+	}
+
+	logger.Info.Println("slurp-rtl_433 going to bed, good night")
+
+	return 0
+}
+
+// reload reopens the log output and reloads the configuration using the
+// same file and flags as startup. Settings that can change while running are
+// applied. Any changes to settings that differ from startupCfg and require a
+// restart are reported. The configuration now in use and the new log output
+// are returned. If the configuration fails to load the current configuration
+// is kept.
+func reload(cf *configFlags, startupCfg config.Config, cfg config.Config, output *os.File, f *file.Filer, d *dump.Dumper, n *systemd.Notifier) (config.Config, *os.File) {
+	logger.Info.Println("received hangup signal, reopening log and reloading configuration")
+	if err := n.Notify(systemd.Reloading); err != nil {
+		logger.Error.Printf("failed to notify systemd: %s", err)
+	}
+	defer func() {
+		if err := n.Notify(systemd.Ready); err != nil {
+			logger.Error.Printf("failed to notify systemd: %s", err)
+		}
+	}()
+
+	newCfg, err := cf.load()
+	if err != nil {
+		logger.Error.Printf("failed to reload configuration, keeping current configuration: %s", err)
+		newCfg = cfg
+	}
+
+	newOutput, err := buildLogger(newCfg)
+	if err != nil {
+		logger.Error.Printf("failed to reopen log output: %s", err)
+		return cfg, output
+	}
+	output.Close()
+
+	for _, name := range config.RestartRequired(startupCfg, newCfg) {
+		logger.Error.Printf("setting %s has changed but requires a restart to apply", name)
+	}
+	if err := f.Reload(newCfg); err != nil {
+		logger.Error.Printf("%s, keeping current filter", err)
+	}
+	d.Reload(newCfg)
+	logger.Info.Println("configuration reloaded")
+
+	return newCfg, newOutput
+}
+
+// pipelineStatus returns a function that builds a status line describing the
+// throughput of the dumper since the last call.
+func pipelineStatus(d *dump.Dumper) func() string {
+	last := d.Status()
+	lastTime := time.Now()
+
+	return func() string {
+		cur := d.Status()
+		now := time.Now()
+		elapsed := now.Sub(lastTime).Seconds()
+
+		s := fmt.Sprintf("received %d readings (%.2f/s), wrote %d points (%.2f/s), %d pending",
+			cur.DataPointsReceived, float64(cur.DataPointsReceived-last.DataPointsReceived)/elapsed,
+			cur.PointsWritten, float64(cur.PointsWritten-last.PointsWritten)/elapsed,
+			cur.PendingPoints)
+		if cur.Retrying {
+			s += ", retrying InfluxDB write"
+		}
+
+		last = cur
+		lastTime = now
+		return s
+	}
+}
+
+// pipelineProgress returns a function that reports if the pipeline is making
+// progress. The filer and dumper must be running and the dumper must have
+// been active within the watchdog interval. A dumper retrying a failed flush
+// is still active so an InfluxDB outage does not cause restarts.
+func pipelineProgress(f *file.Filer, d *dump.Dumper, interval time.Duration) func() bool {
+	return func() bool {
+		s := d.Status()
+		return f.Running() && s.Running && time.Since(s.LastActivity) < interval
+	}
+}
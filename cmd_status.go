@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jrmycanady/slurp-rtl_433/status"
+)
+
+// runStatus queries the status server of a running instance and prints the
+// result. It exits non-zero if the instance is not ready or can not be
+// reached.
+func runStatus(cmd command, args []string) int {
+	fs := newFlagSet(cmd)
+	cf := newConfigFlags(fs)
+	address := fs.StringP("address", "a", "", "The address of the status server. Defaults to the listenAddress in the configuration.")
+	health := fs.Bool("health", false, "Query the liveness endpoint instead of readiness.")
+	fs.Parse(args)
+
+	cfg, err := cf.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load configuration: %s\n", err)
+		return 1
+	}
+	if *address == "" {
+		*address = cfg.Status.ListenAddress
+	}
+	endpoint := "/readyz"
+	if *health {
+		endpoint = "/healthz"
+	}
+
+	client := http.Client{Timeout: time.Duration(10) * time.Second}
+	resp, err := client.Get("http://" + *address + endpoint)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to reach status server at %s: %s\n", *address, err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	r := status.Report{}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to decode status from %s: %s\n", *address, err)
+		return 1
+	}
+	printReport(r)
+
+	if !r.OK {
+		return 1
+	}
+	return 0
+}
+
+// printReport prints a human readable version of the report.
+func printReport(r status.Report) {
+	if r.OK {
+		fmt.Println("status: ok")
+	} else {
+		fmt.Println("status: failing")
+	}
+
+	names := make([]string, 0, len(r.Checks))
+	for name := range r.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Println("checks:")
+	for _, name := range names {
+		fmt.Printf("  %-20s %v\n", name, r.Checks[name])
+	}
+
+	fmt.Printf("filer: running=%v\n", r.Filer.Running)
+	fl := r.Filer.Filter
+	fmt.Printf("  filter: mode=%s rules=%d kept=%d dropped=%d\n", fl.Mode, fl.Rules, fl.Kept, fl.Dropped)
+	for _, model := range sortedKeys(fl.DroppedByModel) {
+		fmt.Printf("    dropped %s: %d\n", model, fl.DroppedByModel[model])
+	}
+	for _, f := range r.Filer.Files {
+		fmt.Printf("  %s inode=%d offset=%d slurping=%v\n", f.LogFilePath, f.Inode, f.Offset, f.Slurping)
+	}
+
+	d := r.Dumper
+	fmt.Printf("dumper: running=%v retrying=%v pending=%d received=%d written=%d\n",
+		d.Running, d.Retrying, d.PendingPoints, d.DataPointsReceived, d.PointsWritten)
+	fmt.Printf("  last reading: %s\n", formatTime(d.LastDataPointTime))
+	fmt.Printf("  last InfluxDB contact: %s\n", formatTime(d.LastInfluxDBContact))
+}
+
+// sortedKeys returns the keys of m sorted.
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatTime formats t along with how long ago it was.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return fmt.Sprintf("%s (%s ago)", t.Format(time.RFC3339), time.Since(t).Round(time.Second))
+}
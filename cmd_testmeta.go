@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jrmycanady/slurp-rtl_433/config"
+	"github.com/jrmycanady/slurp-rtl_433/device"
+	"github.com/jrmycanady/slurp-rtl_433/logger"
+	"github.com/jrmycanady/slurp-rtl_433/rule"
+)
+
+// runTestMeta parses rtl_433 json lines from stdin or the files provided and
+// reports which Meta sets match each reading and the tags that result. The
+// fields are processed first as they are when running. A single condition
+// may be tested with --when in place of the configuration.
+func runTestMeta(cmd command, args []string) int {
+	fs := newFlagSet(cmd)
+	cf := newConfigFlags(fs)
+	when := fs.String("when", "", "Test this condition against each reading in place of the configured Meta sets.")
+	fs.Parse(args)
+
+	var cfg config.Config
+	if *when != "" {
+		if _, err := rule.Parse(*when); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid condition: %s\n", err)
+			return 2
+		}
+	} else {
+		var err error
+		if cfg, err = cf.load(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load configuration: %s\n", err)
+			return 1
+		}
+		logger.UpdateWithLevelList(os.Stderr, cfg.LogLevels)
+	}
+
+	test := func(n int, line []byte) {
+		dp, err := device.ParseDataPoint(line)
+		if err != nil {
+			fmt.Printf("line %d: failed to parse: %s\n", n, err)
+			return
+		}
+		p, err := dp.InfluxData(nil)
+		if err != nil {
+			fmt.Printf("line %d: failed to build point: %s\n", n, err)
+			return
+		}
+		fields, err := p.Fields()
+		if err != nil {
+			fmt.Printf("line %d: failed to read fields: %s\n", n, err)
+			return
+		}
+		env := device.MetaEnv{Tags: p.Tags(), Fields: fields, Time: p.Time()}
+		device.ProcessFields(cfg, dp.GetModel(), env.Tags, env.Fields, env.Time)
+		fmt.Printf("line %d: %s\n", n, dp.GetModel())
+
+		if *when != "" {
+			ok, _ := device.MetaMatches(config.MetaDataFieldSet{When: *when}, env)
+			fmt.Printf("  %s\n", matchResult(ok, nil))
+			return
+		}
+		testMetaSets(cfg.Meta[dp.GetModel()], env)
+	}
+
+	failed := false
+	if fs.NArg() == 0 {
+		failed = testMetaInput(os.Stdin, "stdin", test)
+	}
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open %s: %s\n", path, err)
+			failed = true
+			continue
+		}
+		failed = testMetaInput(f, path, test) || failed
+		f.Close()
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// testMetaInput tests every line in r. True is returned if r could not be
+// read.
+func testMetaInput(r io.Reader, name string, test func(n int, line []byte)) bool {
+	if err := scanLines(r, test); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read %s: %s\n", name, err)
+		return true
+	}
+	return false
+}
+
+// testMetaSets applies the sets to env as the points are built and prints
+// the result of each set in the order they are applied followed by the final
+// tags.
+func testMetaSets(sets map[string]config.MetaDataFieldSet, env device.MetaEnv) {
+	if len(sets) == 0 {
+		fmt.Println("  no Meta sets for this model")
+	}
+	for _, r := range device.ApplyMeta(sets, env.Tags, env.Fields, env.Time) {
+		if r.Matched && len(r.Added) > 0 {
+			fmt.Printf("  %-20s match, %s\n", r.Name, joinTags(r.Added))
+			continue
+		}
+		fmt.Printf("  %-20s %s\n", r.Name, matchResult(r.Matched, r.Err))
+	}
+
+	fmt.Printf("  tags: %s\n", joinTags(env.Tags))
+}
+
+// joinTags returns the tags as sorted key=value pairs.
+func joinTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+tags[k])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// matchResult describes the result of a match.
+func matchResult(ok bool, err error) string {
+	switch {
+	case err != nil:
+		return "error: " + err.Error()
+	case ok:
+		return "match"
+	}
+	return "no match"
+}
@@ -8,6 +8,8 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
+	"reflect"
 
 	"github.com/BurntSushi/toml"
 )
@@ -24,6 +26,7 @@ var (
 // Config represents the configuration for a slurp-rtl_433 instance.
 type Config struct {
 	DataLocation                  string
+	Sources                       []SourceConfig
 	DataFileName                  string
 	DataFileDir                   string
 	LogFilePath                   string
@@ -32,16 +35,200 @@ type Config struct {
 	SlurperShutdownMaxWaitSeconds float64
 	LogFileCheckTimeSeconds       int
 	LogLevels                     []string
+	LogFormat                     string
+	ComponentLogLevels            map[string][]string
+	LogRateLimitSeconds           float64
 	InfluxDB                      InfluxDBConfig
+	Status                        StatusConfig
+	DeadLetter                    DeadLetterConfig
+	Discovery                     DiscoveryConfig
+	Filter                        FilterConfig
 	SlurpSleepTimeSeconds         int
+	StateSaveSeconds              int
+	StateRetentionSeconds         int
 	Meta                          map[string]map[string]MetaDataFieldSet
+	Calibration                   map[string]map[string]CalibrationSet
+	Derived                       map[string]DerivedConfig
+	Psychrometrics                map[string]PsychrometricsConfig
+	Rain                          map[string]RainConfig
+	Energy                        map[string]EnergyConfig
+
+	// Units converts the fields with a unit to the si or imperial system.
+	// The fields are left as reported if empty.
+	Units string
+
+	// DryRun renders points to stdout in DryRunFormat instead of writing
+	// them to the sinks. Offsets are not saved.
+	DryRun       bool
+	DryRunFormat string
+}
+
+// SourceConfig represents a location rtl_433 output is read from, such as a
+// receiver on one frequency.
+type SourceConfig struct {
+	// Name identifies the source in logs. The location is used if empty.
+	Name string
+
+	// Location is the path and file name of the rtl_433 output. Both the
+	// directory and file name may contain glob patterns.
+	Location string
+
+	// Enabled is false to ignore the source. Sources are enabled if not set.
+	Enabled *bool
+
+	// Tags are added to every point read from the source.
+	Tags map[string]string
+}
+
+// IsEnabled returns true if the source should be read.
+func (s SourceConfig) IsEnabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+// DataSources returns all configured sources with any missing names filled
+// in. If no Sources are configured DataLocation is the only source.
+func (c Config) DataSources() []SourceConfig {
+	if len(c.Sources) == 0 {
+		return []SourceConfig{{Name: c.DataLocation, Location: c.DataLocation}}
+	}
+
+	sources := make([]SourceConfig, 0, len(c.Sources))
+	for _, s := range c.Sources {
+		if s.Name == "" {
+			s.Name = s.Location
+		}
+		sources = append(sources, s)
+	}
+
+	return sources
 }
 
 // MetaDataFieldSet contains the set of comaprison values and new fields
-// for processing on a new
+// for processing on a new point. The Tags are added if every CompEqualTags
+// key is present with the value given and the When condition holds. The
+// condition language is described in the rule package.
+//
+// If Buckets are given the Tag is also set to the Value of the bucket the
+// numeric Field falls in. A device stays in its current bucket until the
+// Field passes the bucket boundary by more than Hysteresis so the tag does
+// not flap around a boundary.
 type MetaDataFieldSet struct {
 	CompEqualTags map[string]string
+	When          string
+	Tags          map[string]string
+
+	Field      string
+	Tag        string
+	Hysteresis float64
+	Buckets    []MetaBucket
+}
+
+// MetaBucket is a range of the Field of a MetaDataFieldSet. A bucket holds
+// the values below Below and at or above the Below of the bucket before it.
+// Below may only be left out of the last bucket, which then holds every
+// value above the bucket before it. An empty Value leaves the Tag off the
+// point.
+type MetaBucket struct {
+	Below *float64
+	Value string
+}
+
+// CalibrationSet corrects the numeric Fields of the sensors of a model that
+// match CompEqualTags and When, which are matched as in MetaDataFieldSet. If
+// KeepRaw is true the uncorrected value of each field is kept in a field
+// named with a _raw suffix.
+type CalibrationSet struct {
+	CompEqualTags map[string]string
+	When          string
+	KeepRaw       bool
+	Fields        map[string]FieldCalibration
+}
+
+// FieldCalibration corrects a single field. The value is multiplied by Scale
+// and then Offset is added. A Scale of 0 is taken as 1. Alternatively two
+// Points may be given to correct the value along the line through them, in
+// which case Scale and Offset must not be set.
+type FieldCalibration struct {
+	Offset float64
+	Scale  float64
+	Points []CalibrationPoint
+}
+
+// CalibrationPoint is a reading of the sensor and the actual value measured
+// by a reference at the same time.
+type CalibrationPoint struct {
+	Raw    float64
+	Actual float64
+}
+
+// DerivedConfig computes a value from the latest readings of several
+// sensors, such as the difference between indoor and outdoor temperature.
+// The Expression refers to the Inputs by name and is only computed when every
+// input has a reading within WindowSeconds of the newest. The result is
+// written to Field of Measurement with the Tags and any Meta sets configured
+// under the name of the DerivedConfig.
+type DerivedConfig struct {
+	Expression    string
+	WindowSeconds int
+	Measurement   string
+	Field         string
 	Tags          map[string]string
+	Inputs        map[string]DerivedInput
+}
+
+// DerivedInput selects the Field of the sensors of Model that match
+// CompEqualTags and When, which are matched as in MetaDataFieldSet.
+type DerivedInput struct {
+	Model         string
+	Field         string
+	CompEqualTags map[string]string
+	When          string
+}
+
+// PsychrometricsConfig adds the dew point, heat index, absolute humidity and
+// wind chill to the points of a model as extra fields. The Temperature,
+// Humidity and WindSpeed fields are found by their usual names if not set
+// and the units are taken from the field names unless TemperatureUnit (C or
+// F) or WindSpeedUnit (mph, km/h or m/s) are set. Fields limits the values
+// added, all are added if empty.
+type PsychrometricsConfig struct {
+	Temperature     string
+	TemperatureUnit string
+	Humidity        string
+	WindSpeed       string
+	WindSpeedUnit   string
+	Fields          []string
+}
+
+// RainConfig turns the cumulative rain counter of the sensors of a model into
+// the rain fallen since the previous reading, the rain rate over the last
+// RateWindowSeconds and the totals of the last hour and the day. The Field
+// holding the counter is found by its usual name if not set. Rain reported
+// after a gap in reception longer than MaxGapSeconds only counts toward the
+// daily total as when it fell is unknown.
+type RainConfig struct {
+	Field             string
+	RateWindowSeconds int
+	MaxGapSeconds     int
+}
+
+// EnergyConfig integrates the power of the sensors of a model over time into
+// the energy used in kWh. The power is the sum of the PowerFields multiplied
+// by PowerScale to give watts, or is computed from the current in amps of
+// CurrentField with Voltage and PowerFactor. The usual power or current
+// fields, or the energy field in kW of the Efergy Optical, are used if
+// neither is set, with a Voltage of 230 and a PowerFactor of 1 if not set.
+// No energy is counted across a gap in reception longer than MaxGapSeconds
+// unless FillGaps is true, in which case the average of the readings either
+// side of it is used.
+type EnergyConfig struct {
+	PowerFields   []string
+	PowerScale    float64
+	CurrentField  string
+	Voltage       float64
+	PowerFactor   float64
+	MaxGapSeconds int
+	FillGaps      bool
 }
 
 // InfluxDBConfig represents the configuration for an InfluxDB connection.
@@ -50,10 +237,68 @@ type InfluxDBConfig struct {
 	Port                int
 	Username            string
 	Password            string
+	PasswordFile        string
 	Database            string
 	HTTPS               bool
 	FlushDataPointCount int
 	FlushTimeTrigger    float64
+
+	// Schema is the layout of the points written, device for a measurement
+	// per device, quantity for a measurement per quantity such as
+	// temperature or both.
+	Schema string
+}
+
+// StatusConfig represents the configuration for the HTTP status server that
+// provides the health and readiness endpoints.
+type StatusConfig struct {
+	Enabled               bool
+	ListenAddress         string
+	ReadingWindowSeconds  float64
+	InfluxDBWindowSeconds float64
+}
+
+// DeadLetterConfig represents the configuration of the file lines that could
+// not be parsed are written to.
+type DeadLetterConfig struct {
+	Enabled bool
+
+	// Path is the dead letter file. It's deadletter.log in the meta data
+	// directory if empty.
+	Path       string
+	MaxSizeMB  float64
+	MaxBackups int
+}
+
+// DiscoveryConfig represents the configuration of the inventory of devices
+// heard.
+type DiscoveryConfig struct {
+	Enabled    bool
+	MaxDevices int
+}
+
+// FilterConfig represents the rules deciding which readings are kept.
+type FilterConfig struct {
+	// Mode is "deny" to drop readings matching any rule or "allow" to keep
+	// only the readings matching a rule.
+	Mode  string
+	Rules []FilterRule
+}
+
+// FilterRule matches readings by the value of their fields. Fields maps the
+// name of a field in the rtl_433 output, such as model, id or channel, to a
+// pattern as described by the match package. All fields must match.
+type FilterRule struct {
+	Name   string
+	Fields map[string]string
+}
+
+// DeadLetterPath returns the path of the dead letter file.
+func (c Config) DeadLetterPath() string {
+	if c.DeadLetter.Path != "" {
+		return c.DeadLetter.Path
+	}
+	return filepath.Join(c.FileMetaDataPath, "deadletter.log")
 }
 
 // NewConfig generates a new empty configuration.
@@ -62,32 +307,48 @@ func NewConfig() Config {
 		DataLocation:                  "rtl_433_data.log",
 		FileMetaDataPath:              "./meta/",
 		LogLevels:                     []string{"info", "error"},
+		LogFormat:                     "text",
+		LogRateLimitSeconds:           60,
 		SlurpSleepTimeSeconds:         5,
+		StateSaveSeconds:              5,
+		StateRetentionSeconds:         86400,
 		LogFileCheckTimeSeconds:       30,
 		FilerShutdownMaxWaitSeconds:   20,
 		SlurperShutdownMaxWaitSeconds: 10,
+		DryRunFormat:                  "line",
 		InfluxDB: InfluxDBConfig{
 			FQDN:                "localhost",
 			Port:                8086,
 			Database:            "rtl_433",
 			FlushDataPointCount: 100,
 			FlushTimeTrigger:    10,
+			Schema:              "device",
+		},
+		Status: StatusConfig{
+			ListenAddress:         "localhost:8433",
+			ReadingWindowSeconds:  600,
+			InfluxDBWindowSeconds: 300,
+		},
+		DeadLetter: DeadLetterConfig{
+			Enabled:    true,
+			MaxSizeMB:  10,
+			MaxBackups: 3,
+		},
+		Discovery: DiscoveryConfig{
+			Enabled:    true,
+			MaxDevices: 1000,
+		},
+		Filter: FilterConfig{
+			Mode: "deny",
 		},
 	}
 }
 
 // LoadConfigFromFile loads a configuration file located at path.
 func LoadConfigFromFile(path string) (Config, error) {
-	config := NewConfig()
-
-	rawConfig, err := ioutil.ReadFile(path)
-	if err != nil {
-		return config, fmt.Errorf("failed to read config file: %s", err)
-	}
-
-	_, err = toml.Decode(string(rawConfig), &config)
+	config, _, err := decodeConfigFile(path)
 	if err != nil {
-		return config, fmt.Errorf("failed to decode config file: %s", err)
+		return config, err
 	}
 
 	// Parsing the data directory
@@ -98,9 +359,47 @@ func LoadConfigFromFile(path string) (Config, error) {
 	config.DataFileDir = dir
 	config.DataFileName = name
 
+	if err = ResolveSecrets(&config); err != nil {
+		return config, err
+	}
+
 	return config, nil
 }
 
+// UnknownKeys returns all keys found in the configuration file located at
+// path that do not match any setting. These are most often typos.
+func UnknownKeys(path string) ([]string, error) {
+	_, md, err := decodeConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0)
+	for _, k := range md.Undecoded() {
+		keys = append(keys, k.String())
+	}
+
+	return keys, nil
+}
+
+// decodeConfigFile reads and decodes the configuration file located at path
+// on top of the default configuration.
+func decodeConfigFile(path string) (Config, toml.MetaData, error) {
+	config := NewConfig()
+
+	rawConfig, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, toml.MetaData{}, fmt.Errorf("failed to read config file: %s", err)
+	}
+
+	md, err := toml.Decode(string(rawConfig), &config)
+	if err != nil {
+		return config, md, fmt.Errorf("failed to decode config file: %s", err)
+	}
+
+	return config, md, nil
+}
+
 // SplitLogPath splits the path into the filename and filepath.
 // If the file name is empty an error is returned.
 func SplitLogPath(path string) (string, string, error) {
@@ -133,3 +432,44 @@ func SplitLogPath(path string) (string, string, error) {
 
 	return fileName, fileDirectory, nil
 }
+
+// restartSettings are the settings that can only be applied by restarting
+// slurp-rtl_433. Any setting not listed may be applied while running.
+var restartSettings = []struct {
+	name  string
+	value func(c Config) interface{}
+}{
+	{"dataLocation", func(c Config) interface{} { return c.DataLocation }},
+	{"Sources", func(c Config) interface{} { return c.Sources }},
+	{"fileMetaDataPath", func(c Config) interface{} { return c.FileMetaDataPath }},
+	{"filerShutdownMaxWaitSeconds", func(c Config) interface{} { return c.FilerShutdownMaxWaitSeconds }},
+	{"slurperShutdownMaxWaitSeconds", func(c Config) interface{} { return c.SlurperShutdownMaxWaitSeconds }},
+	{"logFileCheckTimeSeconds", func(c Config) interface{} { return c.LogFileCheckTimeSeconds }},
+	{"slurpSleepTimeSeconds", func(c Config) interface{} { return c.SlurpSleepTimeSeconds }},
+	{"stateSaveSeconds", func(c Config) interface{} { return c.StateSaveSeconds }},
+	{"stateRetentionSeconds", func(c Config) interface{} { return c.StateRetentionSeconds }},
+	{"InfluxDB.fqdn", func(c Config) interface{} { return c.InfluxDB.FQDN }},
+	{"InfluxDB.port", func(c Config) interface{} { return c.InfluxDB.Port }},
+	{"InfluxDB.username", func(c Config) interface{} { return c.InfluxDB.Username }},
+	{"InfluxDB.password", func(c Config) interface{} { return c.InfluxDB.Password }},
+	{"InfluxDB.database", func(c Config) interface{} { return c.InfluxDB.Database }},
+	{"InfluxDB.https", func(c Config) interface{} { return c.InfluxDB.HTTPS }},
+	{"Status", func(c Config) interface{} { return c.Status }},
+	{"DeadLetter", func(c Config) interface{} { return c.DeadLetter }},
+	{"Discovery", func(c Config) interface{} { return c.Discovery }},
+	{"dryRun", func(c Config) interface{} { return c.DryRun }},
+	{"dryRunFormat", func(c Config) interface{} { return c.DryRunFormat }},
+}
+
+// RestartRequired compares the old and new configuration and returns the
+// names of all changed settings that can not be applied without a restart.
+func RestartRequired(old Config, new Config) []string {
+	changed := make([]string, 0)
+	for _, s := range restartSettings {
+		if !reflect.DeepEqual(s.value(old), s.value(new)) {
+			changed = append(changed, s.name)
+		}
+	}
+
+	return changed
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestRestartRequired(t *testing.T) {
+	old := NewConfig()
+
+	// Live settings do not require a restart.
+	live := NewConfig()
+	live.LogLevels = []string{"error"}
+	live.InfluxDB.FlushDataPointCount = 10
+	live.Meta = map[string]map[string]MetaDataFieldSet{
+		"model": {"set": {Tags: map[string]string{"room": "attic"}}},
+	}
+	if changed := RestartRequired(old, live); len(changed) != 0 {
+		t.Fatalf("expected no restart, got %v", changed)
+	}
+
+	restart := NewConfig()
+	restart.DataLocation = "/var/log/rtl_433/data/rtl_433.log"
+	restart.InfluxDB.Password = "secret"
+	changed := RestartRequired(old, restart)
+	if !reflect.DeepEqual(changed, []string{"dataLocation", "InfluxDB.password"}) {
+		t.Fatalf("unexpected restart settings %v", changed)
+	}
+}
+
+func TestDataSources(t *testing.T) {
+	cfg := NewConfig()
+	if got := cfg.DataSources(); len(got) != 1 || got[0].Location != cfg.DataLocation || !got[0].IsEnabled() {
+		t.Fatalf("unexpected default sources %+v", got)
+	}
+
+	_, err := toml.Decode(`
+[[Sources]]
+location = "/var/log/rtl_433/433/rtl_433_data.log"
+[Sources.Tags]
+freq = "433M"
+
+[[Sources]]
+name = "915"
+location = "/var/log/rtl_433/915/*.log"
+enabled = false
+`, &cfg)
+	if err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+
+	got := cfg.DataSources()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 sources, got %+v", got)
+	}
+	if got[0].Name != got[0].Location || !got[0].IsEnabled() || got[0].Tags["freq"] != "433M" {
+		t.Fatalf("unexpected first source %+v", got[0])
+	}
+	if got[1].Name != "915" || got[1].IsEnabled() {
+		t.Fatalf("unexpected second source %+v", got[1])
+	}
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// EnvPrefix is the prefix of all environment variables that override
+// configuration settings.
+const EnvPrefix = "SLURP"
+
+// derivedSettings are settings computed from other settings that can not be
+// overridden.
+var derivedSettings = map[string]bool{
+	"DataFileName": true,
+	"DataFileDir":  true,
+}
+
+// EnvName returns the environment variable name for the setting at path. The
+// name is EnvPrefix followed by each field name upper cased and joined with
+// an underscore. i.e. InfluxDB.Password is SLURP_INFLUXDB_PASSWORD.
+func EnvName(path ...string) string {
+	return strings.ToUpper(strings.Join(append([]string{EnvPrefix}, path...), "_"))
+}
+
+// ApplyEnv overrides the settings in cfg with any set environment variables.
+// Strings, numbers and booleans are used as is. Lists of strings are comma
+// separated. Maps are TOML inline tables such as {dump = ["debug"]}.
+//
+// Secret files set through the environment are read after all variables are
+// applied.
+func ApplyEnv(cfg *Config) error {
+	if err := applyEnv(reflect.ValueOf(cfg).Elem(), nil); err != nil {
+		return err
+	}
+
+	if _, ok := os.LookupEnv(EnvName("DataLocation")); ok {
+		name, dir, err := SplitLogPath(cfg.DataLocation)
+		if err != nil {
+			return fmt.Errorf("failed to load data location: %v", err)
+		}
+		cfg.DataFileDir = dir
+		cfg.DataFileName = name
+	}
+
+	return ResolveSecrets(cfg)
+}
+
+// applyEnv walks each field of the struct v setting any that have a matching
+// environment variable.
+func applyEnv(v reflect.Value, path []string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if derivedSettings[field.Name] {
+			continue
+		}
+		fieldPath := append(append([]string{}, path...), field.Name)
+
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyEnv(v.Field(i), fieldPath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := EnvName(fieldPath...)
+		raw, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setFromString(v.Field(i), raw); err != nil {
+			return fmt.Errorf("invalid value for %s: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+// setFromString parses raw into the type of v and sets it.
+func setFromString(v reflect.Value, raw string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", v.Type())
+		}
+		list := make([]string, 0)
+		for _, s := range strings.Split(raw, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	case reflect.Map:
+		holder := reflect.New(reflect.StructOf([]reflect.StructField{{
+			Name: "Value",
+			Type: v.Type(),
+			Tag:  `toml:"value"`,
+		}}))
+		if _, err := toml.Decode("value = "+raw, holder.Interface()); err != nil {
+			return err
+		}
+		v.Set(holder.Elem().Field(0))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+// ResolveSecrets reads any secret files set in cfg and stores the contents in
+// the matching setting. A trailing newline is removed. Relative paths are
+// resolved against $CREDENTIALS_DIRECTORY if set by systemd. The file
+// setting is cleared once read so a secret set later takes precedence.
+func ResolveSecrets(cfg *Config) error {
+	if cfg.InfluxDB.PasswordFile != "" {
+		secret, err := readSecretFile(cfg.InfluxDB.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("failed to read InfluxDB password file: %s", err)
+		}
+		cfg.InfluxDB.Password = secret
+		cfg.InfluxDB.PasswordFile = ""
+	}
+
+	return nil
+}
+
+// readSecretFile reads the secret stored in the file at path.
+func readSecretFile(path string) (string, error) {
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); dir != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
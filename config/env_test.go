@@ -0,0 +1,69 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv("SLURP_INFLUXDB_PASSWORD", "secret")
+	t.Setenv("SLURP_INFLUXDB_PORT", "9999")
+	t.Setenv("SLURP_STATUS_ENABLED", "true")
+	t.Setenv("SLURP_LOGLEVELS", "error, debug")
+	t.Setenv("SLURP_COMPONENTLOGLEVELS", `{dump = ["debug"]}`)
+	t.Setenv("SLURP_DATALOCATION", "/var/log/rtl_433/data/rtl_433.log")
+
+	cfg := NewConfig()
+	if err := ApplyEnv(&cfg); err != nil {
+		t.Fatalf("failed to apply env: %s", err)
+	}
+
+	if cfg.InfluxDB.Password != "secret" || cfg.InfluxDB.Port != 9999 || !cfg.Status.Enabled {
+		t.Fatalf("settings not applied: %+v", cfg)
+	}
+	if !reflect.DeepEqual(cfg.LogLevels, []string{"error", "debug"}) {
+		t.Fatalf("unexpected log levels %v", cfg.LogLevels)
+	}
+	if !reflect.DeepEqual(cfg.ComponentLogLevels, map[string][]string{"dump": {"debug"}}) {
+		t.Fatalf("unexpected component log levels %v", cfg.ComponentLogLevels)
+	}
+	if cfg.DataFileDir != "/var/log/rtl_433/data/" || cfg.DataFileName != "rtl_433.log" {
+		t.Fatalf("data location not split: %s %s", cfg.DataFileDir, cfg.DataFileName)
+	}
+
+	t.Setenv("SLURP_INFLUXDB_PORT", "many")
+	if err := ApplyEnv(&cfg); err == nil {
+		t.Fatalf("invalid port accepted")
+	}
+}
+
+func TestPasswordFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "influx"), []byte("from-file\n"), 0600); err != nil {
+		t.Fatalf("failed to write secret: %s", err)
+	}
+	path := filepath.Join(dir, "config.toml")
+	if err := ioutil.WriteFile(path, []byte("[InfluxDB]\npassword = \"plain\"\npasswordFile = \"influx\"\n"), 0644); err != nil {
+		t.Fatalf("failed to write config: %s", err)
+	}
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+
+	cfg, err := LoadConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("failed to load config: %s", err)
+	}
+	if cfg.InfluxDB.Password != "from-file" {
+		t.Fatalf("expected password from file, got %q", cfg.InfluxDB.Password)
+	}
+
+	// The environment takes precedence over the file.
+	t.Setenv("SLURP_INFLUXDB_PASSWORD", "from-env")
+	if err := ApplyEnv(&cfg); err != nil {
+		t.Fatalf("failed to apply env: %s", err)
+	}
+	if cfg.InfluxDB.Password != "from-env" {
+		t.Fatalf("expected password from env, got %q", cfg.InfluxDB.Password)
+	}
+}
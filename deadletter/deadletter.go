@@ -0,0 +1,207 @@
+// Package deadletter records the lines read from rtl_433 output that could
+// not be turned into a reading so they can be reviewed later.
+//
+// Rejected lines are written as JSON, one per line, to a file that is rotated
+// once it grows past a maximum size. Summarize groups the recorded lines by
+// reason and model.
+package deadletter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An Entry is a single rejected line.
+type Entry struct {
+	// Time is when the line was rejected.
+	Time time.Time `json:"time"`
+
+	// Reason is the kind of failure such as "unknown model".
+	Reason string `json:"reason"`
+
+	// Model is the model of the line if it could be determined.
+	Model string `json:"model,omitempty"`
+
+	// Error is the full error the line was rejected with.
+	Error string `json:"error"`
+
+	// Source is the name of the source the line was read from.
+	Source string `json:"source,omitempty"`
+
+	// File is the path of the log file the line was read from.
+	File string `json:"file"`
+
+	// Offset is the offset of the start of the line in the log file.
+	Offset int64 `json:"offset"`
+
+	// Line is the rejected line.
+	Line string `json:"line"`
+}
+
+// A Writer appends entries to a dead letter file. Once the file grows past
+// maxBytes it is renamed with a .1 suffix, older rotations are shifted up by
+// one and any past maxBackups are removed. A Writer is safe for concurrent
+// use.
+type Writer struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	// lock protects f and size.
+	lock *sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// Open opens the dead letter file at path for appending, creating it and the
+// directory it's in if needed. A maxBytes of 0 disables rotation.
+func Open(path string, maxBytes int64, maxBackups int) (*Writer, error) {
+	w := &Writer{
+		path:       path,
+		maxBytes:   maxBytes,
+		maxBackups: maxBackups,
+		lock:       &sync.Mutex{},
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create dead letter directory: %s", err)
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Path returns the path of the current dead letter file.
+func (w *Writer) Path() string {
+	return w.path
+}
+
+// open opens the file at path for appending and records its size.
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open dead letter file: %s", err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat dead letter file: %s", err)
+	}
+	w.f = f
+	w.size = stat.Size()
+
+	return nil
+}
+
+// Write appends the entry to the file, rotating it first if the entry would
+// push it past the maximum size.
+func (w *Writer) Write(e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter: %s", err)
+	}
+	b = append(b, '\n')
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.f == nil {
+		return fmt.Errorf("dead letter file %s is closed", w.path)
+	}
+
+	if w.maxBytes > 0 && w.size > 0 && w.size+int64(len(b)) > w.maxBytes {
+		if err = w.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate dead letter file: %s", err)
+		}
+	}
+
+	n, err := w.f.Write(b)
+	w.size += int64(n)
+	return err
+}
+
+// rotate closes the current file, shifts the rotations and opens a new file.
+// The lock must be held.
+func (w *Writer) rotate() error {
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	w.f = nil
+
+	if w.maxBackups <= 0 {
+		if err := os.Remove(w.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return w.open()
+	}
+
+	os.Remove(backupPath(w.path, w.maxBackups))
+	for i := w.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(backupPath(w.path, i), backupPath(w.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(w.path, backupPath(w.path, 1)); err != nil {
+		return err
+	}
+
+	return w.open()
+}
+
+// Close closes the dead letter file.
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+// backupPath returns the path of the nth rotation of the file at path.
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// Files returns the paths of the dead letter file at path and all of its
+// rotations that exist, newest first.
+func Files(path string) ([]string, error) {
+	paths := make([]string, 0)
+	if _, err := os.Stat(path); err == nil {
+		paths = append(paths, path)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	backups := make(map[int]string)
+	max := 0
+	for _, m := range matches {
+		n, err := strconv.Atoi(strings.TrimPrefix(m, path+"."))
+		if err != nil || n < 1 {
+			continue
+		}
+		backups[n] = m
+		if n > max {
+			max = n
+		}
+	}
+	for i := 1; i <= max; i++ {
+		if p, ok := backups[i]; ok {
+			paths = append(paths, p)
+		}
+	}
+
+	return paths, nil
+}
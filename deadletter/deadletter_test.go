@@ -0,0 +1,85 @@
+package deadletter
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriterRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead", "deadletter.log")
+	w, err := Open(path, 200, 2)
+	if err != nil {
+		t.Fatalf("failed to open: %s", err)
+	}
+	defer w.Close()
+
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		e := Entry{Time: start.Add(time.Duration(i) * time.Minute), Reason: "unknown model", Model: "Foo", File: "rtl_433_data.log", Line: `{"model":"Foo"}`}
+		if err = w.Write(e); err != nil {
+			t.Fatalf("failed to write entry %d: %s", i, err)
+		}
+	}
+
+	paths, err := Files(path)
+	if err != nil {
+		t.Fatalf("failed to list files: %s", err)
+	}
+	if len(paths) != 3 || paths[0] != path || paths[1] != path+".1" || paths[2] != path+".2" {
+		t.Fatalf("unexpected files %v", paths)
+	}
+
+	s, err := Summarize(paths...)
+	if err != nil {
+		t.Fatalf("failed to summarize: %s", err)
+	}
+	if s.Total == 0 || s.Total >= 10 {
+		t.Fatalf("expected the oldest entries to be rotated away, got %d", s.Total)
+	}
+	if !s.Groups[0].LastSeen.Equal(start.Add(9 * time.Minute)) {
+		t.Fatalf("newest entry missing, last seen %s", s.Groups[0].LastSeen)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deadletter.log")
+	w, err := Open(path, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open: %s", err)
+	}
+
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := []Entry{
+		{Time: start.Add(2 * time.Minute), Reason: "unknown model", Model: "Foo", Line: "foo 2"},
+		{Time: start, Reason: "unknown model", Model: "Foo", Line: "foo 1"},
+		{Time: start.Add(time.Minute), Reason: "invalid json", Line: "garbage"},
+		{Time: start.Add(3 * time.Minute), Reason: "unknown model", Model: "Bar", Line: "bar"},
+		{Time: start.Add(4 * time.Minute), Reason: "unknown model", Model: "Foo", Line: "foo 3"},
+	}
+	for _, e := range entries {
+		if err = w.Write(e); err != nil {
+			t.Fatalf("failed to write: %s", err)
+		}
+	}
+	w.Close()
+
+	s, err := Summarize(path)
+	if err != nil {
+		t.Fatalf("failed to summarize: %s", err)
+	}
+	if s.Total != 5 || len(s.Groups) != 3 {
+		t.Fatalf("unexpected summary %+v", s)
+	}
+
+	g := s.Groups[0]
+	if g.Reason != "unknown model" || g.Model != "Foo" || g.Count != 3 {
+		t.Fatalf("unexpected first group %+v", g)
+	}
+	if !g.FirstSeen.Equal(start) || !g.LastSeen.Equal(start.Add(4*time.Minute)) || g.Sample != "foo 3" {
+		t.Fatalf("unexpected first group times or sample %+v", g)
+	}
+	if s.Groups[1].Reason != "invalid json" || s.Groups[2].Model != "Bar" {
+		t.Fatalf("unexpected group order %+v", s.Groups)
+	}
+}
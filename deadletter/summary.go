@@ -0,0 +1,120 @@
+package deadletter
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// maxEntryLength is the longest entry that will be read from a dead letter
+// file.
+const maxEntryLength = 4 * 1024 * 1024
+
+// A Group summarizes all entries sharing a reason and model.
+type Group struct {
+	Reason    string    `json:"reason"`
+	Model     string    `json:"model"`
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+
+	// Sample is the most recent line rejected for the reason and model.
+	Sample string `json:"sample"`
+}
+
+// A Summary groups the entries of one or more dead letter files.
+type Summary struct {
+	Groups []Group `json:"groups"`
+
+	// Total is the number of entries read.
+	Total int `json:"total"`
+
+	// Invalid is the number of lines in the files that were not entries.
+	Invalid int `json:"invalid"`
+}
+
+// groupKey identifies a Group.
+type groupKey struct {
+	reason string
+	model  string
+}
+
+// Summarize reads the dead letter files at paths and groups the entries by
+// reason and model. The groups are sorted by count, largest first.
+func Summarize(paths ...string) (Summary, error) {
+	s := Summary{Groups: make([]Group, 0)}
+	groups := make(map[groupKey]*Group)
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return s, err
+		}
+		err = s.add(f, groups)
+		f.Close()
+		if err != nil {
+			return s, fmt.Errorf("failed to read %s: %s", path, err)
+		}
+	}
+
+	for _, g := range groups {
+		s.Groups = append(s.Groups, *g)
+	}
+	sort.Slice(s.Groups, func(i, j int) bool {
+		if s.Groups[i].Count != s.Groups[j].Count {
+			return s.Groups[i].Count > s.Groups[j].Count
+		}
+		if s.Groups[i].Reason != s.Groups[j].Reason {
+			return s.Groups[i].Reason < s.Groups[j].Reason
+		}
+		return s.Groups[i].Model < s.Groups[j].Model
+	})
+
+	return s, nil
+}
+
+// add reads the entries in r into groups.
+func (s *Summary) add(r io.Reader, groups map[groupKey]*Group) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxEntryLength)
+
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		e := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			s.Invalid++
+			continue
+		}
+		s.Total++
+
+		k := groupKey{e.Reason, e.Model}
+		g, ok := groups[k]
+		if !ok {
+			groups[k] = &Group{
+				Reason:    e.Reason,
+				Model:     e.Model,
+				Count:     1,
+				FirstSeen: e.Time,
+				LastSeen:  e.Time,
+				Sample:    e.Line,
+			}
+			continue
+		}
+		g.Count++
+		if e.Time.Before(g.FirstSeen) {
+			g.FirstSeen = e.Time
+		}
+		if !e.Time.Before(g.LastSeen) {
+			g.LastSeen = e.Time
+			g.Sample = e.Line
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,270 @@
+// Package derive computes values from the latest readings of several
+// sensors, such as the difference between the indoor and outdoor
+// temperature.
+//
+// The latest value of every input is kept in memory. When a reading updates
+// an input the expression is computed if every input of the set has a value
+// read within the window of the newest, so a sensor that has gone quiet does
+// not produce stale results. The times of the readings are used rather than
+// the time they are processed so replaying old logs gives the same results.
+package derive
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	influx "github.com/influxdata/influxdb/client/v2"
+	"github.com/jrmycanady/slurp-rtl_433/config"
+	"github.com/jrmycanady/slurp-rtl_433/device"
+	"github.com/jrmycanady/slurp-rtl_433/logger"
+)
+
+// log is the logger for the derive package.
+var log = logger.For("derive")
+
+const (
+	// DefaultMeasurement is the measurement derived values are written to
+	// if not configured.
+	DefaultMeasurement = "Derived"
+
+	// DefaultField is the field derived values are written to if not
+	// configured.
+	DefaultField = "value"
+
+	// DefaultWindowSeconds is the window if not configured.
+	DefaultWindowSeconds = 600
+
+	// NameTag is the tag holding the name of the derived value.
+	NameTag = "derived"
+)
+
+// An Engine computes the derived values. It is safe for concurrent use and
+// the configuration may be updated while in use.
+type Engine struct {
+	// lock protects all fields.
+	lock *sync.Mutex
+
+	sets []*set
+	meta map[string]map[string]config.MetaDataFieldSet
+}
+
+// set is a compiled DerivedConfig.
+type set struct {
+	name   string
+	cfg    config.DerivedConfig
+	expr   expr
+	window time.Duration
+	latest map[string]sample
+}
+
+// sample is the latest value of an input.
+type sample struct {
+	value float64
+	time  time.Time
+}
+
+// New creates an Engine for the Derived values in the configuration. Invalid
+// values are logged and skipped.
+func New(cfg config.Config) *Engine {
+	e := &Engine{lock: &sync.Mutex{}}
+	e.Update(cfg)
+	return e
+}
+
+// Update replaces the derived values with those in the configuration. The
+// latest values of inputs that are still configured are kept.
+func (e *Engine) Update(cfg config.Config) {
+	names := make([]string, 0, len(cfg.Derived))
+	for name := range cfg.Derived {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	old := make(map[string]*set, len(e.sets))
+	for _, s := range e.sets {
+		old[s.name] = s
+	}
+
+	sets := make([]*set, 0, len(names))
+	for _, name := range names {
+		s, err := compile(name, cfg.Derived[name])
+		if err != nil {
+			log.Error.With("derived", name).Printf("skipping derived value: %s", err)
+			continue
+		}
+		if o, ok := old[name]; ok {
+			for input, v := range o.latest {
+				if o.cfg.Inputs[input].Model == s.cfg.Inputs[input].Model && o.cfg.Inputs[input].Field == s.cfg.Inputs[input].Field {
+					s.latest[input] = v
+				}
+			}
+		}
+		sets = append(sets, s)
+	}
+
+	e.sets = sets
+	e.meta = cfg.Meta
+}
+
+// Validate returns an error if the derived value can not be computed.
+func Validate(cfg config.DerivedConfig) error {
+	_, err := compile("", cfg)
+	return err
+}
+
+// compile checks the configuration and parses the expression.
+func compile(name string, cfg config.DerivedConfig) (*set, error) {
+	if len(cfg.Inputs) == 0 {
+		return nil, fmt.Errorf("no inputs")
+	}
+	inputs := make([]string, 0, len(cfg.Inputs))
+	for input := range cfg.Inputs {
+		inputs = append(inputs, input)
+	}
+	sort.Strings(inputs)
+	for _, input := range inputs {
+		in := cfg.Inputs[input]
+		if in.Model == "" || in.Field == "" {
+			return nil, fmt.Errorf("input %s requires a model and field", input)
+		}
+		if _, ok := device.LookupModel(in.Model); !ok {
+			return nil, fmt.Errorf("input %s model %q is not a supported device", input, in.Model)
+		}
+		if err := device.ValidateMetaSet(config.MetaDataFieldSet{When: in.When}); err != nil {
+			return nil, fmt.Errorf("input %s: %s", input, err)
+		}
+	}
+
+	x, err := parseExpr(cfg.Expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %s", cfg.Expression, err)
+	}
+	var unknown error
+	x.names(func(n string) {
+		if _, ok := cfg.Inputs[n]; !ok && unknown == nil {
+			unknown = fmt.Errorf("expression refers to %s which is not an input", n)
+		}
+	})
+	if unknown != nil {
+		return nil, unknown
+	}
+	if cfg.WindowSeconds < 0 {
+		return nil, fmt.Errorf("window can not be negative")
+	}
+
+	if cfg.Measurement == "" {
+		cfg.Measurement = DefaultMeasurement
+	}
+	if cfg.Field == "" {
+		cfg.Field = DefaultField
+	}
+	window := time.Duration(cfg.WindowSeconds) * time.Second
+	if window == 0 {
+		window = DefaultWindowSeconds * time.Second
+	}
+
+	return &set{name: name, cfg: cfg, expr: x, window: window, latest: make(map[string]sample)}, nil
+}
+
+// Observe records the values of the point for any input it matches and
+// returns the points of the derived values it completes. A nil Engine
+// never returns points.
+func (e *Engine) Observe(model string, p *influx.Point) []*influx.Point {
+	if e == nil {
+		return nil
+	}
+	fields, err := p.Fields()
+	if err != nil {
+		return nil
+	}
+	env := device.MetaEnv{Tags: p.Tags(), Fields: fields, Time: p.Time()}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	var points []*influx.Point
+	for _, s := range e.sets {
+		if !s.observe(model, env) {
+			continue
+		}
+		if point := s.compute(e.meta[s.name]); point != nil {
+			points = append(points, point)
+		}
+	}
+
+	return points
+}
+
+// observe records the value of every input the reading matches. True is
+// returned if an input was updated.
+func (s *set) observe(model string, env device.MetaEnv) bool {
+	updated := false
+	for name, in := range s.cfg.Inputs {
+		if in.Model != model {
+			continue
+		}
+		if ok, _ := device.MetaMatches(config.MetaDataFieldSet{CompEqualTags: in.CompEqualTags, When: in.When}, env); !ok {
+			continue
+		}
+		v, ok := env.Lookup(in.Field)
+		if !ok {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			continue
+		}
+		s.latest[name] = sample{value: f, time: env.Time}
+		updated = true
+	}
+	return updated
+}
+
+// compute returns the point of the derived value or nil if any input is
+// missing or too old.
+func (s *set) compute(meta map[string]config.MetaDataFieldSet) *influx.Point {
+	slog := log.With("derived", s.name)
+
+	var newest time.Time
+	for _, v := range s.latest {
+		if v.time.After(newest) {
+			newest = v.time
+		}
+	}
+	vars := make(map[string]float64, len(s.cfg.Inputs))
+	for name := range s.cfg.Inputs {
+		v, ok := s.latest[name]
+		if !ok || newest.Sub(v.time) > s.window {
+			slog.Debug.With("input", name).Println("input is missing or too old")
+			return nil
+		}
+		vars[name] = v.value
+	}
+
+	value := s.expr.eval(vars)
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		slog.Debug.Printf("expression is not a number: %v", value)
+		return nil
+	}
+
+	tags := map[string]string{NameTag: s.name}
+	for k, v := range s.cfg.Tags {
+		tags[k] = v
+	}
+	fields := map[string]interface{}{s.cfg.Field: value}
+	device.ApplyMeta(meta, tags, fields, newest)
+
+	p, err := influx.NewPoint(s.cfg.Measurement, tags, fields, newest)
+	if err != nil {
+		slog.Error.Printf("failed to create point: %s", err)
+		return nil
+	}
+	return p
+}
@@ -0,0 +1,115 @@
+package derive
+
+import (
+	"testing"
+	"time"
+
+	influx "github.com/influxdata/influxdb/client/v2"
+	"github.com/jrmycanady/slurp-rtl_433/config"
+)
+
+const tower = "Acurite tower sensor"
+
+func reading(t *testing.T, channel string, temp float64, at time.Time) *influx.Point {
+	p, err := influx.NewPoint("AcuRiteTowerSensor", map[string]string{"model": tower, "channel": channel}, map[string]interface{}{"temperature_C": temp}, at)
+	if err != nil {
+		t.Fatalf("failed to create point: %s", err)
+	}
+	return p
+}
+
+func TestObserve(t *testing.T) {
+	cfg := config.Config{
+		Derived: map[string]config.DerivedConfig{
+			"indoor_outdoor": {
+				Expression:    "indoor - outdoor",
+				WindowSeconds: 300,
+				Tags:          map[string]string{"unit": "C"},
+				Inputs: map[string]config.DerivedInput{
+					"indoor":  {Model: tower, Field: "temperature_C", CompEqualTags: map[string]string{"channel": "A"}},
+					"outdoor": {Model: tower, Field: "temperature_C", When: "channel == B"},
+				},
+			},
+		},
+		Meta: map[string]map[string]config.MetaDataFieldSet{
+			"indoor_outdoor": {"room": {Tags: map[string]string{"room": "house"}}},
+		},
+	}
+	e := New(cfg)
+	start := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	if points := e.Observe(tower, reading(t, "A", 21, start)); len(points) != 0 {
+		t.Fatalf("expected no points until every input has a value, got %d", len(points))
+	}
+	points := e.Observe(tower, reading(t, "B", 5.5, start.Add(time.Minute)))
+	if len(points) != 1 {
+		t.Fatalf("expected one derived point, got %d", len(points))
+	}
+	p := points[0]
+	fields, _ := p.Fields()
+	tags := p.Tags()
+	if p.Name() != DefaultMeasurement || fields[DefaultField] != 15.5 || tags[NameTag] != "indoor_outdoor" || tags["unit"] != "C" || tags["room"] != "house" {
+		t.Errorf("unexpected derived point %s", p)
+	}
+	if !p.Time().Equal(start.Add(time.Minute)) {
+		t.Errorf("expected the time of the newest input, got %s", p.Time())
+	}
+
+	// The indoor reading is now older than the window.
+	if points := e.Observe(tower, reading(t, "B", 6, start.Add(10*time.Minute))); len(points) != 0 {
+		t.Errorf("expected no point with a stale input, got %d", len(points))
+	}
+
+	// Values are kept across updates.
+	e.Update(cfg)
+	if points := e.Observe(tower, reading(t, "A", 20, start.Add(11*time.Minute))); len(points) != 1 {
+		t.Errorf("expected the outdoor value to survive an update, got %d points", len(points))
+	}
+
+	var none *Engine
+	if points := none.Observe(tower, reading(t, "A", 20, start)); points != nil {
+		t.Errorf("nil engine returned points")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	input := map[string]config.DerivedInput{"a": {Model: tower, Field: "temperature_C"}}
+	invalid := map[string]config.DerivedConfig{
+		"no inputs":     {Expression: "1"},
+		"unknown input": {Expression: "a - b", Inputs: input},
+		"bad syntax":    {Expression: "a -", Inputs: input},
+		"bad function":  {Expression: "sqrt(a)", Inputs: input},
+		"bad model":     {Expression: "a", Inputs: map[string]config.DerivedInput{"a": {Model: "nope", Field: "x"}}},
+		"no field":      {Expression: "a", Inputs: map[string]config.DerivedInput{"a": {Model: tower}}},
+	}
+	for name, cfg := range invalid {
+		if err := Validate(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if err := Validate(config.DerivedConfig{Expression: "abs(a * 1.8) + max(a, -3) / 2", Inputs: input}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestEvalExpr(t *testing.T) {
+	tests := map[string]float64{
+		"1 + 2 * 3":             7,
+		"(1 + 2) * 3":           9,
+		"-a - -2":               -2,
+		"a / 4 - 1":             0,
+		"min(a, 2) + max(a, 2)": 6,
+		"abs(1 - a)":            3,
+	}
+	for s, want := range tests {
+		x, err := parseExpr(s)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", s, err)
+			continue
+		}
+		if got := x.eval(map[string]float64{"a": 4}); got != want {
+			t.Errorf("%s: expected %v, got %v", s, want, got)
+		}
+	}
+}
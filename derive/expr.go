@@ -0,0 +1,237 @@
+package derive
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expr is a parsed arithmetic expression.
+type expr interface {
+	eval(vars map[string]float64) float64
+	names(fn func(string))
+}
+
+// numberExpr is a constant.
+type numberExpr float64
+
+func (e numberExpr) eval(vars map[string]float64) float64 { return float64(e) }
+func (e numberExpr) names(fn func(string))                {}
+
+// nameExpr is the value of an input.
+type nameExpr string
+
+func (e nameExpr) eval(vars map[string]float64) float64 { return vars[string(e)] }
+func (e nameExpr) names(fn func(string))                { fn(string(e)) }
+
+// negExpr negates an expression.
+type negExpr struct{ e expr }
+
+func (e negExpr) eval(vars map[string]float64) float64 { return -e.e.eval(vars) }
+func (e negExpr) names(fn func(string))                { e.e.names(fn) }
+
+// binaryExpr applies an operator to two expressions.
+type binaryExpr struct {
+	op          byte
+	left, right expr
+}
+
+func (e binaryExpr) eval(vars map[string]float64) float64 {
+	l, r := e.left.eval(vars), e.right.eval(vars)
+	switch e.op {
+	case '+':
+		return l + r
+	case '-':
+		return l - r
+	case '*':
+		return l * r
+	case '/':
+		return l / r
+	}
+	return math.NaN()
+}
+
+func (e binaryExpr) names(fn func(string)) { e.left.names(fn); e.right.names(fn) }
+
+// callExpr calls one of the functions.
+type callExpr struct {
+	fn   string
+	args []expr
+}
+
+// functions are the functions an expression may call and their number of
+// arguments.
+var functions = map[string]int{"abs": 1, "min": 2, "max": 2}
+
+func (e callExpr) eval(vars map[string]float64) float64 {
+	switch e.fn {
+	case "abs":
+		return math.Abs(e.args[0].eval(vars))
+	case "min":
+		return math.Min(e.args[0].eval(vars), e.args[1].eval(vars))
+	case "max":
+		return math.Max(e.args[0].eval(vars), e.args[1].eval(vars))
+	}
+	return math.NaN()
+}
+
+func (e callExpr) names(fn func(string)) {
+	for _, a := range e.args {
+		a.names(fn)
+	}
+}
+
+// parseExpr parses an expression made of numbers, input names, the
+// operators + - * / and parentheses and the functions abs, min and max.
+func parseExpr(s string) (expr, error) {
+	p := &exprParser{s: s}
+	if p.skip(); p.i == len(s) {
+		return nil, fmt.Errorf("empty expression")
+	}
+	e, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(s) {
+		return nil, fmt.Errorf("unexpected %q at position %d", s[p.i], p.i)
+	}
+	return e, nil
+}
+
+// exprParser parses an expression one byte at a time.
+type exprParser struct {
+	s string
+	i int
+}
+
+// skip moves past any spaces.
+func (p *exprParser) skip() {
+	for p.i < len(p.s) && unicode.IsSpace(rune(p.s[p.i])) {
+		p.i++
+	}
+}
+
+// accept consumes c if it's the next byte.
+func (p *exprParser) accept(c byte) bool {
+	if p.skip(); p.i < len(p.s) && p.s[p.i] == c {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseSum() (expr, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := byte('+')
+		if !p.accept('+') {
+			if op = '-'; !p.accept('-') {
+				return left, nil
+			}
+		}
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op, left, right}
+	}
+}
+
+func (p *exprParser) parseProduct() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := byte('*')
+		if !p.accept('*') {
+			if op = '/'; !p.accept('/') {
+				return left, nil
+			}
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op, left, right}
+	}
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	if p.accept('-') {
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return negExpr{e}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (expr, error) {
+	if p.accept('(') {
+		e, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(')') {
+			return nil, fmt.Errorf("expected ) at position %d", p.i)
+		}
+		return e, nil
+	}
+
+	p.skip()
+	start := p.i
+	for p.i < len(p.s) && isNameByte(p.s[p.i]) {
+		p.i++
+	}
+	word := p.s[start:p.i]
+	if word == "" {
+		if p.i == len(p.s) {
+			return nil, fmt.Errorf("unexpected end of expression")
+		}
+		return nil, fmt.Errorf("unexpected %q at position %d", p.s[p.i], p.i)
+	}
+
+	if c := word[0]; c >= '0' && c <= '9' || c == '.' {
+		v, err := strconv.ParseFloat(word, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s at position %d", word, start)
+		}
+		return numberExpr(v), nil
+	}
+
+	if !p.accept('(') {
+		return nameExpr(word), nil
+	}
+	fn := strings.ToLower(word)
+	n, ok := functions[fn]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s at position %d", word, start)
+	}
+	call := callExpr{fn: fn}
+	for len(call.args) < n {
+		if len(call.args) > 0 && !p.accept(',') {
+			return nil, fmt.Errorf("%s takes %d arguments", fn, n)
+		}
+		arg, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+	}
+	if !p.accept(')') {
+		return nil, fmt.Errorf("expected ) at position %d", p.i)
+	}
+	return call, nil
+}
+
+// isNameByte returns true if c may be part of a name or number.
+func isNameByte(c byte) bool {
+	return c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
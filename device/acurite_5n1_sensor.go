@@ -7,7 +7,6 @@ import (
 
 	influx "github.com/influxdata/influxdb/client/v2"
 	"github.com/jrmycanady/slurp-rtl_433/config"
-	"github.com/jrmycanady/slurp-rtl_433/logger"
 )
 
 var (
@@ -33,6 +32,10 @@ type AcuRite5n1SensorDataPoint struct {
 	WindDir                  string  `json:"wind_dir"`
 	RainfallAccumulationInch float64 `json:"rainfall_accumulation_inch"`
 	RaincounterRaw           int     `json:"raincounter_raw"`
+
+	// TemperatureF and Humidity are only reported by some message types.
+	TemperatureF *float64 `json:"temperature_F"`
+	Humidity     *int     `json:"humidity"`
 }
 
 // GetTimeStr returns the string format of the time as provided by the device
@@ -41,6 +44,11 @@ func (a *AcuRite5n1SensorDataPoint) GetTimeStr() string {
 	return a.TimeStr
 }
 
+// GetModel returns the model name as provided by the device output.
+func (a *AcuRite5n1SensorDataPoint) GetModel() string {
+	return a.Model
+}
+
 // SetTime sets the time value fo the AcuRite5n1SensorDataPoint.
 func (a *AcuRite5n1SensorDataPoint) SetTime(t time.Time) {
 	a.Time = t
@@ -57,11 +65,6 @@ func (a *AcuRite5n1SensorDataPoint) InfluxData(sets map[string]config.MetaDataFi
 		"message_type": strconv.Itoa(a.MessageType),
 		"win_dir":      a.WindDir,
 	}
-	// Parsing any metadata for this type if we have some.
-	for _, set := range sets {
-		logger.Debug.Printf("processing metadata set %s", set)
-		ProcessMetaDataFieldSet(tags, &set)
-	}
 
 	fields := map[string]interface{}{
 		"wind_speed_mph":             a.WindSpeedMPH,
@@ -69,8 +72,15 @@ func (a *AcuRite5n1SensorDataPoint) InfluxData(sets map[string]config.MetaDataFi
 		"rainfall_accumulation_inch": a.RainfallAccumulationInch,
 		"raincounter_raw":            a.RaincounterRaw,
 	}
+	if a.TemperatureF != nil {
+		fields["temperature_F"] = *a.TemperatureF
+	}
+	if a.Humidity != nil {
+		fields["humidity"] = *a.Humidity
+	}
 
 	ParseTime(a)
+	ApplyMeta(sets, tags, fields, a.Time)
 	p, err := influx.NewPoint(AcuRite5n1SensorName, tags, fields, a.Time)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create point: %s", err)
@@ -7,7 +7,6 @@ import (
 
 	influx "github.com/influxdata/influxdb/client/v2"
 	"github.com/jrmycanady/slurp-rtl_433/config"
-	"github.com/jrmycanady/slurp-rtl_433/logger"
 )
 
 var (
@@ -34,6 +33,11 @@ func (a *AcuRite606TXSensorDataPoint) GetTimeStr() string {
 	return a.TimeStr
 }
 
+// GetModel returns the model name as provided by the device output.
+func (a *AcuRite606TXSensorDataPoint) GetModel() string {
+	return a.Model
+}
+
 // SetTime sets the time value fo the AcuRite606TXSensorDataPoint.
 func (a *AcuRite606TXSensorDataPoint) SetTime(t time.Time) {
 	a.Time = t
@@ -46,17 +50,13 @@ func (a *AcuRite606TXSensorDataPoint) InfluxData(sets map[string]config.MetaData
 		"id":      strconv.Itoa(a.ID),
 		"battery": a.Battery,
 	}
-	// Parsing any metadata for this type if we have some.
-	for _, set := range sets {
-		logger.Debug.Printf("processing metadata set %s", set)
-		ProcessMetaDataFieldSet(tags, &set)
-	}
 
 	fields := map[string]interface{}{
 		"temperature_C": a.TemperatureC,
 	}
 
 	ParseTime(a)
+	ApplyMeta(sets, tags, fields, a.Time)
 	p, err := influx.NewPoint(AcuRite606TXSensorName, tags, fields, a.Time)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create point: %s", err)
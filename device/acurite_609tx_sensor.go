@@ -7,7 +7,6 @@ import (
 
 	influx "github.com/influxdata/influxdb/client/v2"
 	"github.com/jrmycanady/slurp-rtl_433/config"
-	"github.com/jrmycanady/slurp-rtl_433/logger"
 )
 
 var (
@@ -36,6 +35,11 @@ func (a *AcuRite609TXCSensorDataPoint) GetTimeStr() string {
 	return a.TimeStr
 }
 
+// GetModel returns the model name as provided by the device output.
+func (a *AcuRite609TXCSensorDataPoint) GetModel() string {
+	return a.Model
+}
+
 // SetTime sets the time value fo the AcuRite609TXCSensorDataPoint.
 func (a *AcuRite609TXCSensorDataPoint) SetTime(t time.Time) {
 	a.Time = t
@@ -49,11 +53,6 @@ func (a *AcuRite609TXCSensorDataPoint) InfluxData(sets map[string]config.MetaDat
 		"status":  strconv.Itoa(a.Status),
 		"battery": a.Battery,
 	}
-	// Parsing any metadata for this type if we have some.
-	for _, set := range sets {
-		logger.Debug.Printf("processing metadata set %s", set)
-		ProcessMetaDataFieldSet(tags, &set)
-	}
 
 	fields := map[string]interface{}{
 		"temperature_C": a.TemperatureC,
@@ -61,6 +60,7 @@ func (a *AcuRite609TXCSensorDataPoint) InfluxData(sets map[string]config.MetaDat
 	}
 
 	ParseTime(a)
+	ApplyMeta(sets, tags, fields, a.Time)
 	p, err := influx.NewPoint(AcuRite609TXCSensorName, tags, fields, a.Time)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create point: %s", err)
@@ -7,7 +7,6 @@ import (
 
 	influx "github.com/influxdata/influxdb/client/v2"
 	"github.com/jrmycanady/slurp-rtl_433/config"
-	"github.com/jrmycanady/slurp-rtl_433/logger"
 )
 
 var (
@@ -36,6 +35,11 @@ func (a *AcuRite986SensorDataPoint) GetTimeStr() string {
 	return a.TimeStr
 }
 
+// GetModel returns the model name as provided by the device output.
+func (a *AcuRite986SensorDataPoint) GetModel() string {
+	return a.Model
+}
+
 // SetTime sets the time value fo the AcuRite986SensorDataPoint.
 func (a *AcuRite986SensorDataPoint) SetTime(t time.Time) {
 	a.Time = t
@@ -50,17 +54,13 @@ func (a *AcuRite986SensorDataPoint) InfluxData(sets map[string]config.MetaDataFi
 		"status":  strconv.Itoa(a.Status),
 		"battery": a.Battery,
 	}
-	// Parsing any metadata for this type if we have some.
-	for _, set := range sets {
-		logger.Debug.Printf("processing metadata set %s", set)
-		ProcessMetaDataFieldSet(tags, &set)
-	}
 
 	fields := map[string]interface{}{
 		"temperature_F": a.TemperatureF,
 	}
 
 	ParseTime(a)
+	ApplyMeta(sets, tags, fields, a.Time)
 	p, err := influx.NewPoint(AcuRite986SensorName, tags, fields, a.Time)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create point: %s", err)
@@ -7,7 +7,6 @@ import (
 
 	influx "github.com/influxdata/influxdb/client/v2"
 	"github.com/jrmycanady/slurp-rtl_433/config"
-	"github.com/jrmycanady/slurp-rtl_433/logger"
 )
 
 var (
@@ -42,6 +41,11 @@ func (a *AcuRiteLightning6045MDataPoint) GetTimeStr() string {
 	return a.TimeStr
 }
 
+// GetModel returns the model name as provided by the device output.
+func (a *AcuRiteLightning6045MDataPoint) GetModel() string {
+	return a.Model
+}
+
 // SetTime sets the time value fo the AcuRiteLightning6045MDataPoint.
 func (a *AcuRiteLightning6045MDataPoint) SetTime(t time.Time) {
 	a.Time = t
@@ -59,11 +63,6 @@ func (a *AcuRiteLightning6045MDataPoint) InfluxData(sets map[string]config.MetaD
 		"battery":     a.Battery,
 		"exception":   strconv.Itoa(a.Exception),
 	}
-	// Parsing any metadata for this type if we have some.
-	for _, set := range sets {
-		logger.Debug.Printf("processing metadata set %s", set)
-		ProcessMetaDataFieldSet(tags, &set)
-	}
 
 	fields := map[string]interface{}{
 		"temperature_F": a.TemperatureF,
@@ -73,6 +72,7 @@ func (a *AcuRiteLightning6045MDataPoint) InfluxData(sets map[string]config.MetaD
 	}
 
 	ParseTime(a)
+	ApplyMeta(sets, tags, fields, a.Time)
 	p, err := influx.NewPoint(AcuRiteLightning6045MName, tags, fields, a.Time)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create point: %s", err)
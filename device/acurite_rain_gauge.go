@@ -7,7 +7,6 @@ import (
 
 	influx "github.com/influxdata/influxdb/client/v2"
 	"github.com/jrmycanady/slurp-rtl_433/config"
-	"github.com/jrmycanady/slurp-rtl_433/logger"
 )
 
 var (
@@ -33,6 +32,11 @@ func (a *AcuRiteRainGaugeDataPoint) GetTimeStr() string {
 	return a.TimeStr
 }
 
+// GetModel returns the model name as provided by the device output.
+func (a *AcuRiteRainGaugeDataPoint) GetModel() string {
+	return a.Model
+}
+
 // SetTime sets the time value fo the AcuRiteRainGaugeDataPoint.
 func (a *AcuRiteRainGaugeDataPoint) SetTime(t time.Time) {
 	a.Time = t
@@ -44,18 +48,13 @@ func (a *AcuRiteRainGaugeDataPoint) InfluxData(sets map[string]config.MetaDataFi
 		"model": a.Model,
 		"id":    strconv.Itoa(a.ID),
 	}
-	// Parsing any metadata for this type if we have some.
-	for _, set := range sets {
-		logger.Debug.Printf("processing metadata set %s", set)
-		ProcessMetaDataFieldSet(tags, &set)
-
-	}
 
 	fields := map[string]interface{}{
 		"rain_mm": a.Rain,
 	}
 
 	ParseTime(a)
+	ApplyMeta(sets, tags, fields, a.Time)
 	p, err := influx.NewPoint(AcuRiteRainGaugeName, tags, fields, a.Time)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create point: %s", err)
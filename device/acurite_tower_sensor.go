@@ -7,7 +7,6 @@ import (
 
 	influx "github.com/influxdata/influxdb/client/v2"
 	"github.com/jrmycanady/slurp-rtl_433/config"
-	"github.com/jrmycanady/slurp-rtl_433/logger"
 )
 
 var (
@@ -37,6 +36,11 @@ func (a *AcuRiteTowerSensorDataPoint) GetTimeStr() string {
 	return a.TimeStr
 }
 
+// GetModel returns the model name as provided by the device output.
+func (a *AcuRiteTowerSensorDataPoint) GetModel() string {
+	return a.Model
+}
+
 // SetTime sets the time value fo the AcuRiteTowerSensorDataPoint.
 func (a *AcuRiteTowerSensorDataPoint) SetTime(t time.Time) {
 	a.Time = t
@@ -51,11 +55,6 @@ func (a *AcuRiteTowerSensorDataPoint) InfluxData(sets map[string]config.MetaData
 		"channel":     a.Channel,
 		"battery_low": strconv.Itoa(a.BatteryLow),
 	}
-	// Parsing any metadata for this type if we have some.
-	for _, set := range sets {
-		logger.Debug.Printf("processing metadata set %s", set)
-		ProcessMetaDataFieldSet(tags, &set)
-	}
 
 	fields := map[string]interface{}{
 		"temperature_C": a.TemperatureC,
@@ -63,6 +62,7 @@ func (a *AcuRiteTowerSensorDataPoint) InfluxData(sets map[string]config.MetaData
 	}
 
 	ParseTime(a)
+	ApplyMeta(sets, tags, fields, a.Time)
 	p, err := influx.NewPoint(AcuRiteTowerSensorName, tags, fields, a.Time)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create point: %s", err)
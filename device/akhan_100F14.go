@@ -7,7 +7,6 @@ import (
 
 	influx "github.com/influxdata/influxdb/client/v2"
 	"github.com/jrmycanady/slurp-rtl_433/config"
-	"github.com/jrmycanady/slurp-rtl_433/logger"
 )
 
 var (
@@ -33,6 +32,11 @@ func (a *Akhan100F14DataPoint) GetTimeStr() string {
 	return a.TimeStr
 }
 
+// GetModel returns the model name as provided by the device output.
+func (a *Akhan100F14DataPoint) GetModel() string {
+	return a.Model
+}
+
 // SetTime sets the time value fo the Akhan100F14DataPoint.
 func (a *Akhan100F14DataPoint) SetTime(t time.Time) {
 	a.Time = t
@@ -45,15 +49,11 @@ func (a *Akhan100F14DataPoint) InfluxData(sets map[string]config.MetaDataFieldSe
 		"id":    strconv.Itoa(a.ID),
 		"data":  a.Data,
 	}
-	// Parsing any metadata for this type if we have some.
-	for _, set := range sets {
-		logger.Debug.Printf("processing metadata set %s", set)
-		ProcessMetaDataFieldSet(tags, &set)
-	}
 
 	fields := map[string]interface{}{}
 
 	ParseTime(a)
+	ApplyMeta(sets, tags, fields, a.Time)
 	p, err := influx.NewPoint(Akhan100F14Name, tags, fields, a.Time)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create point: %s", err)
@@ -7,7 +7,6 @@ import (
 
 	influx "github.com/influxdata/influxdb/client/v2"
 	"github.com/jrmycanady/slurp-rtl_433/config"
-	"github.com/jrmycanady/slurp-rtl_433/logger"
 )
 
 var (
@@ -37,6 +36,11 @@ func (a *AmbientWeatherDataPoint) GetTimeStr() string {
 	return a.TimeStr
 }
 
+// GetModel returns the model name as provided by the device output.
+func (a *AmbientWeatherDataPoint) GetModel() string {
+	return a.Model
+}
+
 // SetTime sets the time value fo the AmbientWeatherDataPoint.
 func (a *AmbientWeatherDataPoint) SetTime(t time.Time) {
 	a.Time = t
@@ -51,18 +55,13 @@ func (a *AmbientWeatherDataPoint) InfluxData(sets map[string]config.MetaDataFiel
 		"channel":    strconv.Itoa(a.Channel),
 		"battery":    a.Battery,
 	}
-	// Parsing any metadata for this type if we have some.
-	for _, set := range sets {
-		logger.Debug.Printf("processing metadata set %s", set)
-		ProcessMetaDataFieldSet(tags, &set)
-		logger.Debug.Printf("%s => %s", tags["channel"], tags["room"])
-	}
 
 	fields := map[string]interface{}{
 		"temperature_f": a.TemperatureF,
 		"humidity":      a.Humidity,
 	}
 	ParseTime(a)
+	ApplyMeta(sets, tags, fields, a.Time)
 	p, err := influx.NewPoint(AmbientWeatherName, tags, fields, a.Time)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create point: %s", err)
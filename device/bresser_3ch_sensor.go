@@ -7,7 +7,6 @@ import (
 
 	influx "github.com/influxdata/influxdb/client/v2"
 	"github.com/jrmycanady/slurp-rtl_433/config"
-	"github.com/jrmycanady/slurp-rtl_433/logger"
 )
 
 var (
@@ -37,6 +36,11 @@ func (a *Bresser3CHSensorDataPoint) GetTimeStr() string {
 	return a.TimeStr
 }
 
+// GetModel returns the model name as provided by the device output.
+func (a *Bresser3CHSensorDataPoint) GetModel() string {
+	return a.Model
+}
+
 // SetTime sets the time value fo the Bresser3CHSensorDataPoint.
 func (a *Bresser3CHSensorDataPoint) SetTime(t time.Time) {
 	a.Time = t
@@ -50,11 +54,6 @@ func (a *Bresser3CHSensorDataPoint) InfluxData(sets map[string]config.MetaDataFi
 		"channel": a.Channel,
 		"battery": a.Battery,
 	}
-	// Parsing any metadata for this type if we have some.
-	for _, set := range sets {
-		logger.Debug.Printf("processing metadata set %s", set)
-		ProcessMetaDataFieldSet(tags, &set)
-	}
 
 	fields := map[string]interface{}{
 		"temperature_F": a.TemperatureF,
@@ -62,6 +61,7 @@ func (a *Bresser3CHSensorDataPoint) InfluxData(sets map[string]config.MetaDataFi
 	}
 
 	ParseTime(a)
+	ApplyMeta(sets, tags, fields, a.Time)
 	p, err := influx.NewPoint(Bresser3CHSensorName, tags, fields, a.Time)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create point: %s", err)
@@ -0,0 +1,52 @@
+package device
+
+import (
+	"fmt"
+	"time"
+
+	influx "github.com/influxdata/influxdb/client/v2"
+	"github.com/jrmycanady/slurp-rtl_433/config"
+	"github.com/jrmycanady/slurp-rtl_433/units"
+)
+
+// BuildPoint builds the InfluxDB point of the DataPoint with the fields
+// processed by ProcessFields and then the Meta sets of its model applied, so
+// the Meta sets see the corrected and converted values.
+func BuildPoint(d DataPoint, cfg config.Config) (*influx.Point, error) {
+	p, err := d.InfluxData(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	model := d.GetModel()
+	_, enrich := cfg.Psychrometrics[model]
+	if len(cfg.Calibration[model]) == 0 && len(cfg.Meta[model]) == 0 && !enrich && cfg.Units == "" {
+		return p, nil
+	}
+
+	tags := p.Tags()
+	fields, err := p.Fields()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read point fields: %s", err)
+	}
+	ProcessFields(cfg, model, tags, fields, p.Time())
+	ApplyMeta(cfg.Meta[model], tags, fields, p.Time())
+
+	p, err = influx.NewPoint(p.Name(), tags, fields, p.Time())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create point: %s", err)
+	}
+	return p, nil
+}
+
+// ProcessFields applies the Calibration sets and Psychrometrics of the model
+// and then the unit system in the configuration to the fields of a point.
+// The fields are calibrated first so the psychrometric values are computed
+// from the corrected values, and both use the field names of the device.
+func ProcessFields(cfg config.Config, model string, tags map[string]string, fields map[string]interface{}, t time.Time) {
+	Calibrate(cfg.Calibration[model], tags, fields, t)
+	if ps, ok := cfg.Psychrometrics[model]; ok {
+		AddPsychrometrics(ps, model, fields)
+	}
+	units.Normalize(cfg.Units, fields)
+}
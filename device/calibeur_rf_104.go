@@ -7,7 +7,6 @@ import (
 
 	influx "github.com/influxdata/influxdb/client/v2"
 	"github.com/jrmycanady/slurp-rtl_433/config"
-	"github.com/jrmycanady/slurp-rtl_433/logger"
 )
 
 var (
@@ -35,6 +34,11 @@ func (a *CalibeurRF104DataPoint) GetTimeStr() string {
 	return a.TimeStr
 }
 
+// GetModel returns the model name as provided by the device output.
+func (a *CalibeurRF104DataPoint) GetModel() string {
+	return a.Model
+}
+
 // SetTime sets the time value fo the CalibeurRF104DataPoint.
 func (a *CalibeurRF104DataPoint) SetTime(t time.Time) {
 	a.Time = t
@@ -46,11 +50,6 @@ func (a *CalibeurRF104DataPoint) InfluxData(sets map[string]config.MetaDataField
 		"model": a.Model,
 		"id":    strconv.Itoa(a.ID),
 	}
-	// Parsing any metadata for this type if we have some.
-	for _, set := range sets {
-		logger.Debug.Printf("processing metadata set %s", set)
-		ProcessMetaDataFieldSet(tags, &set)
-	}
 
 	fields := map[string]interface{}{
 		"temperature_C": a.TemperatureC,
@@ -58,6 +57,7 @@ func (a *CalibeurRF104DataPoint) InfluxData(sets map[string]config.MetaDataField
 	}
 
 	ParseTime(a)
+	ApplyMeta(sets, tags, fields, a.Time)
 	p, err := influx.NewPoint(CalibeurRF104Name, tags, fields, a.Time)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create point: %s", err)
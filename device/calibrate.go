@@ -0,0 +1,131 @@
+package device
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jrmycanady/slurp-rtl_433/config"
+	"github.com/jrmycanady/slurp-rtl_433/units"
+)
+
+// RawFieldSuffix is added to the name of a calibrated field to keep the
+// uncorrected value.
+const RawFieldSuffix = "_raw"
+
+// ValidateCalibrationSet returns an error if the When condition or the field
+// corrections of the set are invalid.
+func ValidateCalibrationSet(set config.CalibrationSet) error {
+	if err := ValidateMetaSet(config.MetaDataFieldSet{When: set.When}); err != nil {
+		return err
+	}
+	if len(set.Fields) == 0 {
+		return fmt.Errorf("no fields to calibrate")
+	}
+
+	for _, name := range calibrationFieldNames(set.Fields) {
+		c := set.Fields[name]
+		switch len(c.Points) {
+		case 0:
+		case 2:
+			if c.Scale != 0 || c.Offset != 0 {
+				return fmt.Errorf("field %s can not combine points with scale or offset", name)
+			}
+			if c.Points[0].Raw == c.Points[1].Raw {
+				return fmt.Errorf("field %s points must have different raw values", name)
+			}
+		default:
+			return fmt.Errorf("field %s needs two points, found %d", name, len(c.Points))
+		}
+	}
+
+	return nil
+}
+
+// Calibrate corrects the numeric fields of a point using the sets whose
+// conditions match its tags and fields. The sets are tried in name order and
+// each field is only corrected by the first matching set that lists it.
+// Integer fields stay integers so the field type in InfluxDB does not change.
+// Sets that are invalid are skipped.
+func Calibrate(sets map[string]config.CalibrationSet, tags map[string]string, fields map[string]interface{}, t time.Time) {
+	if len(sets) == 0 {
+		return
+	}
+	mlog := log.With("model", tags["model"])
+	env := MetaEnv{Tags: tags, Fields: fields, Time: t}
+
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// The conditions are evaluated before any field is corrected so every
+	// set sees the raw values.
+	matched := make([]string, 0, len(names))
+	for _, name := range names {
+		set := sets[name]
+		if err := ValidateCalibrationSet(set); err != nil {
+			mlog.Error.With("set", name).Printf("skipping Calibration set: %s", err)
+			continue
+		}
+		ok, _ := MetaMatches(config.MetaDataFieldSet{CompEqualTags: set.CompEqualTags, When: set.When}, env)
+		mlog.Debug.With("set", name).Printf("Calibration set matched: %v", ok)
+		if ok {
+			matched = append(matched, name)
+		}
+	}
+
+	done := make(map[string]bool)
+	for _, name := range matched {
+		set := sets[name]
+		for _, field := range calibrationFieldNames(set.Fields) {
+			v, ok := units.Number(fields[field])
+			if done[field] || !ok {
+				continue
+			}
+			done[field] = true
+
+			if set.KeepRaw {
+				fields[field+RawFieldSuffix] = fields[field]
+			}
+			fields[field] = sameType(fields[field], calibrateValue(set.Fields[field], v))
+		}
+	}
+}
+
+// calibrateValue returns the corrected value of v.
+func calibrateValue(c config.FieldCalibration, v float64) float64 {
+	if len(c.Points) == 2 {
+		p0, p1 := c.Points[0], c.Points[1]
+		return p0.Actual + (v-p0.Raw)*(p1.Actual-p0.Actual)/(p1.Raw-p0.Raw)
+	}
+
+	scale := c.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	return v*scale + c.Offset
+}
+
+// sameType returns v rounded to the type of orig if it is an integer.
+func sameType(orig interface{}, v float64) interface{} {
+	switch orig.(type) {
+	case int:
+		return int(math.Round(v))
+	case int64:
+		return int64(math.Round(v))
+	}
+	return v
+}
+
+// calibrationFieldNames returns the names of the fields in sorted order.
+func calibrationFieldNames(fields map[string]config.FieldCalibration) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
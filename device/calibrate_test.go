@@ -0,0 +1,60 @@
+package device
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jrmycanady/slurp-rtl_433/config"
+)
+
+func TestCalibrate(t *testing.T) {
+	sets := map[string]config.CalibrationSet{
+		"a-porch": {
+			CompEqualTags: map[string]string{"channel": "1"},
+			KeepRaw:       true,
+			Fields: map[string]config.FieldCalibration{
+				"temperature_F": {Offset: -2},
+				"humidity":      {Scale: 1.1, Offset: -3},
+			},
+		},
+		"b-all": {
+			Fields: map[string]config.FieldCalibration{
+				"temperature_F": {Offset: -10},
+				"pressure":      {Points: []config.CalibrationPoint{{Raw: 10, Actual: 12}, {Raw: 20, Actual: 20}}},
+			},
+		},
+		"c-invalid": {
+			Fields: map[string]config.FieldCalibration{
+				"humidity": {Offset: 1, Points: []config.CalibrationPoint{{Raw: 1, Actual: 1}, {Raw: 2, Actual: 2}}},
+			},
+		},
+	}
+
+	tags := map[string]string{"model": "Calibration test", "channel": "1"}
+	fields := map[string]interface{}{"temperature_F": 72.5, "humidity": int64(50), "pressure": 15.0}
+	Calibrate(sets, tags, fields, time.Time{})
+
+	want := map[string]interface{}{
+		"temperature_F":     70.5,
+		"temperature_F_raw": 72.5,
+		"humidity":          int64(52),
+		"humidity_raw":      int64(50),
+		"pressure":          16.0,
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("expected %s to be %v, got %v", k, v, fields[k])
+		}
+	}
+	if _, ok := fields["pressure_raw"]; ok {
+		t.Errorf("raw value kept for a set without KeepRaw")
+	}
+
+	// Sensors not matching the first set only get the second.
+	tags = map[string]string{"model": "Calibration test", "channel": "2"}
+	fields = map[string]interface{}{"temperature_F": 72.5, "humidity": int64(50)}
+	Calibrate(sets, tags, fields, time.Time{})
+	if fields["temperature_F"] != 62.5 || fields["humidity"] != int64(50) {
+		t.Errorf("unexpected fields for other sensor %v", fields)
+	}
+}
@@ -6,7 +6,6 @@ import (
 
 	influx "github.com/influxdata/influxdb/client/v2"
 	"github.com/jrmycanady/slurp-rtl_433/config"
-	"github.com/jrmycanady/slurp-rtl_433/logger"
 )
 
 var (
@@ -34,6 +33,11 @@ func (a *CurrentCostTXDataPoint) GetTimeStr() string {
 	return a.TimeStr
 }
 
+// GetModel returns the model name as provided by the device output.
+func (a *CurrentCostTXDataPoint) GetModel() string {
+	return a.Model
+}
+
 // SetTime sets the time value fo the CurrentCostTXDataPoint.
 func (a *CurrentCostTXDataPoint) SetTime(t time.Time) {
 	a.Time = t
@@ -44,11 +48,6 @@ func (a *CurrentCostTXDataPoint) InfluxData(sets map[string]config.MetaDataField
 	tags := map[string]string{
 		"model": a.Model,
 	}
-	// Parsing any metadata for this type if we have some.
-	for _, set := range sets {
-		logger.Debug.Printf("processing metadata set %s", set)
-		ProcessMetaDataFieldSet(tags, &set)
-	}
 
 	fields := map[string]interface{}{
 		"power0": a.Power0,
@@ -57,6 +56,7 @@ func (a *CurrentCostTXDataPoint) InfluxData(sets map[string]config.MetaDataField
 	}
 
 	ParseTime(a)
+	ApplyMeta(sets, tags, fields, a.Time)
 	p, err := influx.NewPoint(CurrentCostTXName, tags, fields, a.Time)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create point: %s", err)
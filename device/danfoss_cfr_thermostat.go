@@ -7,7 +7,6 @@ import (
 
 	influx "github.com/influxdata/influxdb/client/v2"
 	"github.com/jrmycanady/slurp-rtl_433/config"
-	"github.com/jrmycanady/slurp-rtl_433/logger"
 )
 
 var (
@@ -35,6 +34,11 @@ func (a *DanfossCFRThermostatDataPoint) GetTimeStr() string {
 	return a.TimeStr
 }
 
+// GetModel returns the model name as provided by the device output.
+func (a *DanfossCFRThermostatDataPoint) GetModel() string {
+	return a.Model
+}
+
 // SetTime sets the time value fo the DanfossCFRThermostatDataPoint.
 func (a *DanfossCFRThermostatDataPoint) SetTime(t time.Time) {
 	a.Time = t
@@ -46,11 +50,6 @@ func (a *DanfossCFRThermostatDataPoint) InfluxData(sets map[string]config.MetaDa
 		"model": a.Model,
 		"id":    strconv.Itoa(a.ID),
 	}
-	// Parsing any metadata for this type if we have some.
-	for _, set := range sets {
-		logger.Debug.Printf("processing metadata set %s", set)
-		ProcessMetaDataFieldSet(tags, &set)
-	}
 
 	fields := map[string]interface{}{
 		"temperature_C": a.TemperatureC,
@@ -58,6 +57,7 @@ func (a *DanfossCFRThermostatDataPoint) InfluxData(sets map[string]config.MetaDa
 	}
 
 	ParseTime(a)
+	ApplyMeta(sets, tags, fields, a.Time)
 	p, err := influx.NewPoint(DanfossCFRThermostatName, tags, fields, a.Time)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create point: %s", err)
@@ -12,6 +12,9 @@ import (
 	"github.com/jrmycanady/slurp-rtl_433/logger"
 )
 
+// log is the logger for the device package.
+var log = logger.For("device")
+
 // DataPoint is in interface for interacting with differnet types of devices.
 //
 // InfluxData creates a new influx data point containing the values for the
@@ -19,10 +22,13 @@ import (
 //
 // GetTimeStr returns the string representation of the time from the DataPoint.
 //
+// GetModel returns the rtl_433 model name of the DataPoint.
+//
 // SetTime sets the time property of the DataPoint.
 type DataPoint interface {
 	InfluxData(sets map[string]config.MetaDataFieldSet) (*influx.Point, error)
 	GetTimeStr() string
+	GetModel() string
 	SetTime(t time.Time)
 }
 
@@ -32,18 +38,51 @@ type BaseDataPoint struct {
 	Model string `json:"model"`
 }
 
+// TimeLayout is the layout of the time rtl_433 reports with each reading.
+const TimeLayout = "2006-01-02 15:04:05"
+
 // ParseTime parses the string time of the DataPoint then stores it in the
 // time property
 func ParseTime(d DataPoint) {
-	t, err := time.Parse("2006-01-02 15:04:05", d.GetTimeStr())
+	t, err := DataPointTime(d)
 	if err != nil {
 		panic(err)
 	}
 	d.SetTime(t)
 }
 
+// DataPointTime parses and returns the time of the DataPoint.
+func DataPointTime(d DataPoint) (time.Time, error) {
+	return time.Parse(TimeLayout, d.GetTimeStr())
+}
+
+// The reasons a line may fail to parse into a DataPoint.
+const (
+	ReasonInvalidJSON   = "invalid json"
+	ReasonUnknownModel  = "unknown model"
+	ReasonInvalidFields = "invalid fields"
+	ReasonInvalidTime   = "invalid time"
+)
+
+// A ParseError describes why a line failed to parse into a DataPoint.
+type ParseError struct {
+	// Reason is one of the Reason constants.
+	Reason string
+
+	// Model is the model of the line if it could be determined.
+	Model string
+
+	// Err is the underlying error.
+	Err error
+}
+
+// Error returns the reason followed by the underlying error.
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Reason, e.Err)
+}
+
 // ParseDataPoint parses the string into the proper DataPoint type. If parsing
-// fails nil will be returned with an error.
+// fails nil will be returned with a *ParseError.
 func ParseDataPoint(d []byte) (DataPoint, error) {
 	var err error
 
@@ -51,134 +90,25 @@ func ParseDataPoint(d []byte) (DataPoint, error) {
 	//determined.
 	b := BaseDataPoint{}
 	if err = json.Unmarshal([]byte(d), &b); err != nil {
-		return nil, err
+		return nil, &ParseError{Reason: ReasonInvalidJSON, Err: err}
 	}
 
-	switch b.Model {
-	case AmbientWeatherModelName:
-		a := AmbientWeatherDataPoint{}
-		if err = json.Unmarshal(d, &a); err != nil {
-			return nil, err
-		}
-		return &a, nil
-	case AcuRiteRainGaugeModelName:
-		a := AcuRiteRainGaugeDataPoint{}
-		if err = json.Unmarshal(d, &a); err != nil {
-			return nil, err
-		}
-		return &a, nil
-	case AcuRite609TXCSensorModelName:
-		a := AcuRite609TXCSensorDataPoint{}
-		if err = json.Unmarshal(d, &a); err != nil {
-			return nil, err
-		}
-		return &a, nil
-	case AcuRiteLightning6045MModelName:
-		a := AcuRiteLightning6045MDataPoint{}
-		if err = json.Unmarshal(d, &a); err != nil {
-			return nil, err
-		}
-		return &a, nil
-	case AcuRiteTowerSensorModelName:
-		a := AcuRiteTowerSensorDataPoint{}
-		if err = json.Unmarshal(d, &a); err != nil {
-			return nil, err
-		}
-		return &a, nil
-	case AcuRite5n1SensorName:
-		a := AcuRite5n1SensorDataPoint{}
-		if err = json.Unmarshal(d, &a); err != nil {
-			return nil, err
-		}
-		return &a, nil
-	case AcuRite986SensorName:
-		a := AcuRite986SensorDataPoint{}
-		if err = json.Unmarshal(d, &a); err != nil {
-			return nil, err
-		}
-		return &a, nil
-	case AcuRite606TXSensorName:
-		a := AcuRite606TXSensorDataPoint{}
-		if err = json.Unmarshal(d, &a); err != nil {
-			return nil, err
-		}
-		return &a, nil
-	case Akhan100F14Name:
-		a := Akhan100F14DataPoint{}
-		if err = json.Unmarshal(d, &a); err != nil {
-			return nil, err
-		}
-		return &a, nil
-	case Bresser3CHSensorName:
-		a := Bresser3CHSensorDataPoint{}
-		if err = json.Unmarshal(d, &a); err != nil {
-			return nil, err
-		}
-		return &a, nil
-	case CalibeurRF104Name:
-		a := CalibeurRF104DataPoint{}
-		if err = json.Unmarshal(d, &a); err != nil {
-			return nil, err
-		}
-		return &a, nil
-	case CurrentCostTXName:
-		a := CurrentCostTXDataPoint{}
-		if err = json.Unmarshal(d, &a); err != nil {
-			return nil, err
-		}
-		return &a, nil
-	case DanfossCFRThermostatName:
-		a := DanfossCFRThermostatDataPoint{}
-		if err = json.Unmarshal(d, &a); err != nil {
-			return nil, err
-		}
-		return &a, nil
-	case EfergyE2CTName:
-		a := EfergyE2CTDataPoint{}
-		if err = json.Unmarshal(d, &a); err != nil {
-			return nil, err
-		}
-		return &a, nil
-	case EfergyOpticalName:
-		a := EfergyOpticalDataPoint{}
-		if err = json.Unmarshal(d, &a); err != nil {
-			return nil, err
-		}
-		return &a, nil
+	dev, ok := LookupModel(b.Model)
+	if !ok {
+		return nil, &ParseError{Reason: ReasonUnknownModel, Model: b.Model, Err: fmt.Errorf("%s", b.Model)}
 	}
 
-	return nil, fmt.Errorf("unknown model: %s", b.Model)
-}
-
-// ProcessMetaDataFieldSet processes the field set by adding the tags
-// if the comparison values are true.
-func ProcessMetaDataFieldSet(pTags map[string]string, f *config.MetaDataFieldSet) {
-	fmt.Println("Starting metadat compare")
-	fmt.Println(pTags)
-	fmt.Println(f.CompEqualTags)
-
-	// Processing each tag that needs a equalCompare.
-	for mT := range f.CompEqualTags {
-		// Looking at each tag to see if we have one to match.
-		for pT := range pTags {
-			// Comparing names to determine if they match. If they don't then
-			// the fieldset doesn't apply and we return.
-			logger.Debug.Printf("%s ?= %s", pT, mT)
-			if pT == mT {
-				logger.Debug.Printf("[%s][%s] ?= [%s][%s]", pT, pTags[pT], mT, f.CompEqualTags[mT])
-
-				if pTags[pT] != f.CompEqualTags[mT] {
-
-					return
-				}
-				logger.Debug.Printf("[%s][%s] == [%s][%s]", pT, pTags[pT], mT, f.CompEqualTags[mT])
-			}
-		}
+	// Fields reported in another unit than the device decodes are
+	// converted first so the setting of rtl_433 does not matter.
+	dp := dev.New()
+	if err = json.Unmarshal(alignUnits(b.Model, dp, d), dp); err != nil {
+		return nil, &ParseError{Reason: ReasonInvalidFields, Model: b.Model, Err: err}
 	}
 
-	// If we made it to here then it does apply so add all the tags.
-	for k := range f.Tags {
-		pTags[k] = f.Tags[k]
+	// Rejecting bad times now as building the point panics on them.
+	if _, err = DataPointTime(dp); err != nil {
+		return nil, &ParseError{Reason: ReasonInvalidTime, Model: b.Model, Err: err}
 	}
 
+	return dp, nil
 }
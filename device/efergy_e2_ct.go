@@ -7,7 +7,6 @@ import (
 
 	influx "github.com/influxdata/influxdb/client/v2"
 	"github.com/jrmycanady/slurp-rtl_433/config"
-	"github.com/jrmycanady/slurp-rtl_433/logger"
 )
 
 var (
@@ -35,6 +34,11 @@ func (a *EfergyE2CTDataPoint) GetTimeStr() string {
 	return a.TimeStr
 }
 
+// GetModel returns the model name as provided by the device output.
+func (a *EfergyE2CTDataPoint) GetModel() string {
+	return a.Model
+}
+
 // SetTime sets the time value fo the EfergyE2CTDataPoint.
 func (a *EfergyE2CTDataPoint) SetTime(t time.Time) {
 	a.Time = t
@@ -48,11 +52,6 @@ func (a *EfergyE2CTDataPoint) InfluxData(sets map[string]config.MetaDataFieldSet
 		"battery": a.Battery,
 		"learn":   a.Learn,
 	}
-	// Parsing any metadata for this type if we have some.
-	for _, set := range sets {
-		logger.Debug.Printf("processing metadata set %s", set)
-		ProcessMetaDataFieldSet(tags, &set)
-	}
 
 	fields := map[string]interface{}{
 		"current": a.Current,
@@ -60,6 +59,7 @@ func (a *EfergyE2CTDataPoint) InfluxData(sets map[string]config.MetaDataFieldSet
 	}
 
 	ParseTime(a)
+	ApplyMeta(sets, tags, fields, a.Time)
 	p, err := influx.NewPoint(EfergyE2CTName, tags, fields, a.Time)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create point: %s", err)
@@ -6,7 +6,6 @@ import (
 
 	influx "github.com/influxdata/influxdb/client/v2"
 	"github.com/jrmycanady/slurp-rtl_433/config"
-	"github.com/jrmycanady/slurp-rtl_433/logger"
 )
 
 var (
@@ -14,7 +13,7 @@ var (
 	EfergyOpticalName = "EfergyOptical"
 
 	// EfergyOpticalModelName is the model name rtl_433 returns.
-	EfergyOpticalModelName = "Efergy e2 CT"
+	EfergyOpticalModelName = "Efergy Optical"
 )
 
 // EfergyOpticalDataPoint represents a datapoint from an EfergyOptical device.
@@ -32,6 +31,11 @@ func (a *EfergyOpticalDataPoint) GetTimeStr() string {
 	return a.TimeStr
 }
 
+// GetModel returns the model name as provided by the device output.
+func (a *EfergyOpticalDataPoint) GetModel() string {
+	return a.Model
+}
+
 // SetTime sets the time value fo the EfergyOpticalDataPoint.
 func (a *EfergyOpticalDataPoint) SetTime(t time.Time) {
 	a.Time = t
@@ -42,11 +46,6 @@ func (a *EfergyOpticalDataPoint) InfluxData(sets map[string]config.MetaDataField
 	tags := map[string]string{
 		"model": a.Model,
 	}
-	// Parsing any metadata for this type if we have some.
-	for _, set := range sets {
-		logger.Debug.Printf("processing metadata set %s", set)
-		ProcessMetaDataFieldSet(tags, &set)
-	}
 
 	fields := map[string]interface{}{
 		"pulses": a.Pulses,
@@ -54,6 +53,7 @@ func (a *EfergyOpticalDataPoint) InfluxData(sets map[string]config.MetaDataField
 	}
 
 	ParseTime(a)
+	ApplyMeta(sets, tags, fields, a.Time)
 	p, err := influx.NewPoint(EfergyOpticalName, tags, fields, a.Time)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create point: %s", err)
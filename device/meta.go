@@ -0,0 +1,307 @@
+package device
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jrmycanady/slurp-rtl_433/config"
+	"github.com/jrmycanady/slurp-rtl_433/rule"
+)
+
+// metaRules caches the parsed When conditions of the Meta sets by their
+// source so each is only parsed once.
+var metaRules sync.Map
+
+// metaRule returns the parsed condition.
+func metaRule(when string) (*rule.Rule, error) {
+	if r, ok := metaRules.Load(when); ok {
+		return r.(*rule.Rule), nil
+	}
+	r, err := rule.Parse(when)
+	if err != nil {
+		return nil, err
+	}
+	metaRules.Store(when, r)
+	return r, nil
+}
+
+// metaBuckets holds the bucket each device is in for every Meta set with
+// Buckets so hysteresis can be applied to the next reading.
+var metaBuckets = struct {
+	lock    sync.Mutex
+	current map[string]bucketState
+}{current: make(map[string]bucketState)}
+
+// maxMetaBuckets is the most devices and sets metaBuckets holds. Once full the
+// one read least recently is forgotten, so devices that go away, such as a
+// sensor that picks a new id when its batteries are changed, are not kept
+// forever.
+const maxMetaBuckets = 4096
+
+// bucketState is the bucket a device is in and the time of its last reading.
+type bucketState struct {
+	bucket int
+	seen   time.Time
+}
+
+// identityTags are the tags identifying a single device of a model.
+var identityTags = []string{"model", "id", "channel", "sensor_id", "device"}
+
+// MetaEnv provides the tags, fields and time of a point to Meta conditions.
+// Tags take precedence over fields of the same name.
+type MetaEnv struct {
+	Tags   map[string]string
+	Fields map[string]interface{}
+	Time   time.Time
+}
+
+// Lookup returns the value of the tag, field or time key.
+func (e MetaEnv) Lookup(key string) (string, bool) {
+	if v, ok := e.Tags[key]; ok {
+		return v, true
+	}
+	if v, ok := e.Fields[key]; ok {
+		return formatField(v), true
+	}
+	if e.Time.IsZero() {
+		return "", false
+	}
+	switch key {
+	case "time_of_day":
+		return e.Time.Format("15:04"), true
+	case "hour":
+		return strconv.Itoa(e.Time.Hour()), true
+	case "weekday":
+		return e.Time.Format("Mon"), true
+	}
+	return "", false
+}
+
+// formatField formats a field value for comparison.
+func formatField(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// ValidateMetaSet returns an error if the When condition or the Buckets of
+// the set are invalid.
+func ValidateMetaSet(set config.MetaDataFieldSet) error {
+	if set.When != "" {
+		if _, err := metaRule(set.When); err != nil {
+			return fmt.Errorf("invalid condition %q: %s", set.When, err)
+		}
+	}
+
+	if len(set.Buckets) == 0 {
+		if set.Field != "" || set.Tag != "" {
+			return fmt.Errorf("field and tag require buckets")
+		}
+		return nil
+	}
+	if set.Field == "" || set.Tag == "" {
+		return fmt.Errorf("buckets require a field and tag")
+	}
+	if set.Hysteresis < 0 {
+		return fmt.Errorf("hysteresis can not be negative")
+	}
+	for i, b := range set.Buckets {
+		if b.Below == nil {
+			if i != len(set.Buckets)-1 {
+				return fmt.Errorf("only the last bucket may leave out below")
+			}
+			continue
+		}
+		if i > 0 && *b.Below <= *set.Buckets[i-1].Below {
+			return fmt.Errorf("bucket %d below %v is not above the bucket before it", i+1, *b.Below)
+		}
+	}
+
+	return nil
+}
+
+// MetaMatches returns true if the Tags of the set should be added to the
+// point. Every CompEqualTags key must be present with the value given and
+// the When condition must hold. An error is returned if the condition can
+// not be parsed.
+func MetaMatches(set config.MetaDataFieldSet, env MetaEnv) (bool, error) {
+	if err := ValidateMetaSet(set); err != nil {
+		return false, err
+	}
+	for k, want := range set.CompEqualTags {
+		if v, ok := env.Lookup(k); !ok || v != want {
+			return false, nil
+		}
+	}
+	if set.When == "" {
+		return true, nil
+	}
+
+	r, _ := metaRule(set.When)
+	return r.Eval(env), nil
+}
+
+// MetaResult is the outcome of applying a single Meta set to a point.
+type MetaResult struct {
+	Name    string
+	Matched bool
+	Err     error
+
+	// Added holds the tags set by the set. A tag with an empty value was
+	// removed by a bucket without a Value.
+	Added map[string]string
+}
+
+// ApplyMeta adds the Tags of every set whose conditions match the point to
+// tags along with the bucket tag of sets with Buckets. The sets are applied
+// in name order so a later set sees the tags added by earlier ones and
+// replaces them on conflict. Sets that are invalid are skipped. The outcome
+// of each set is returned.
+func ApplyMeta(sets map[string]config.MetaDataFieldSet, tags map[string]string, fields map[string]interface{}, t time.Time) []MetaResult {
+	if len(sets) == 0 {
+		return nil
+	}
+	mlog := log.With("model", tags["model"])
+	env := MetaEnv{Tags: tags, Fields: fields, Time: t}
+	key := DeviceKey(tags)
+
+	results := make([]MetaResult, 0, len(sets))
+	for _, name := range MetaSetNames(sets) {
+		set := sets[name]
+		r := MetaResult{Name: name}
+		r.Matched, r.Err = MetaMatches(set, env)
+		if r.Err != nil {
+			mlog.Error.With("set", name).Printf("skipping Meta set: %s", r.Err)
+			results = append(results, r)
+			continue
+		}
+
+		// A bucketed set only applies to points with a numeric field.
+		var value float64
+		if r.Matched && len(set.Buckets) > 0 {
+			v, ok := env.Lookup(set.Field)
+			f, err := strconv.ParseFloat(v, 64)
+			r.Matched = ok && err == nil
+			value = f
+		}
+		mlog.Debug.With("set", name).Printf("Meta set matched: %v", r.Matched)
+		if !r.Matched {
+			results = append(results, r)
+			continue
+		}
+
+		r.Added = make(map[string]string, len(set.Tags)+1)
+		for k, v := range set.Tags {
+			tags[k] = v
+			r.Added[k] = v
+		}
+		if len(set.Buckets) > 0 {
+			b := pickBucket(set, key+"\x00"+name, value, t)
+			if b < len(set.Buckets) && set.Buckets[b].Value != "" {
+				tags[set.Tag] = set.Buckets[b].Value
+			} else {
+				delete(tags, set.Tag)
+			}
+			r.Added[set.Tag] = tags[set.Tag]
+		}
+		results = append(results, r)
+	}
+
+	return results
+}
+
+// DeviceKey returns a key identifying the device the tags came from by its
+// model and identity tags.
+func DeviceKey(tags map[string]string) string {
+	parts := make([]string, 0, len(identityTags))
+	for _, k := range identityTags {
+		if v, ok := tags[k]; ok {
+			parts = append(parts, k+"="+v)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// pickBucket returns the index of the bucket of the set that value falls in
+// for the device and set identified by key, read at t. The device stays in
+// its current bucket while value is within Hysteresis of the bucket. An index
+// past the last bucket is returned for a value above the Below of the last
+// bucket.
+func pickBucket(set config.MetaDataFieldSet, key string, value float64, t time.Time) int {
+	metaBuckets.lock.Lock()
+	defer metaBuckets.lock.Unlock()
+
+	current, known := metaBuckets.current[key]
+	if known {
+		if low, high, ok := bucketRange(set.Buckets, current.bucket); ok && value >= low-set.Hysteresis && value < high+set.Hysteresis {
+			metaBuckets.current[key] = bucketState{bucket: current.bucket, seen: t}
+			return current.bucket
+		}
+	} else {
+		evictBucket()
+	}
+
+	b := len(set.Buckets)
+	for i, bucket := range set.Buckets {
+		if bucket.Below == nil || value < *bucket.Below {
+			b = i
+			break
+		}
+	}
+	metaBuckets.current[key] = bucketState{bucket: b, seen: t}
+
+	return b
+}
+
+// evictBucket forgets the device read least recently if metaBuckets is full.
+// The lock must be held.
+func evictBucket() {
+	if len(metaBuckets.current) < maxMetaBuckets {
+		return
+	}
+
+	oldest := ""
+	for key, s := range metaBuckets.current {
+		if oldest == "" || s.seen.Before(metaBuckets.current[oldest].seen) {
+			oldest = key
+		}
+	}
+	delete(metaBuckets.current, oldest)
+}
+
+// bucketRange returns the values held by bucket i. False is returned if
+// there is no such bucket, which can happen after the configuration is
+// reloaded.
+func bucketRange(buckets []config.MetaBucket, i int) (float64, float64, bool) {
+	if i < 0 || i > len(buckets) || (i == len(buckets) && buckets[i-1].Below == nil) {
+		return 0, 0, false
+	}
+	low, high := math.Inf(-1), math.Inf(1)
+	if i > 0 {
+		low = *buckets[i-1].Below
+	}
+	if i < len(buckets) && buckets[i].Below != nil {
+		high = *buckets[i].Below
+	}
+	return low, high, true
+}
+
+// MetaSetNames returns the names of the sets in the order they are applied.
+func MetaSetNames(sets map[string]config.MetaDataFieldSet) []string {
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
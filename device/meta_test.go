@@ -0,0 +1,125 @@
+package device
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jrmycanady/slurp-rtl_433/config"
+)
+
+func TestApplyMeta(t *testing.T) {
+	sets := map[string]config.MetaDataFieldSet{
+		"a-room": {
+			CompEqualTags: map[string]string{"channel": "A"},
+			Tags:          map[string]string{"room": "garage"},
+		},
+		"b-freezing": {
+			When: `room == garage && temperature_C < 0 && time_of_day >= 22:00`,
+			Tags: map[string]string{"alert": "freezing"},
+		},
+		"c-missing": {
+			CompEqualTags: map[string]string{"sensor_id": "1"},
+			Tags:          map[string]string{"sensor": "one"},
+		},
+		"d-invalid": {
+			When: `id ==`,
+			Tags: map[string]string{"invalid": "true"},
+		},
+	}
+	tags := map[string]string{"model": "Acurite tower sensor", "channel": "A"}
+	fields := map[string]interface{}{"temperature_C": -2.5}
+
+	ApplyMeta(sets, tags, fields, time.Date(2026, 1, 2, 23, 0, 0, 0, time.UTC))
+	if tags["room"] != "garage" || tags["alert"] != "freezing" {
+		t.Errorf("expected matching sets to apply, got %v", tags)
+	}
+	if _, ok := tags["sensor"]; ok {
+		t.Errorf("set comparing a missing tag was applied")
+	}
+	if _, ok := tags["invalid"]; ok {
+		t.Errorf("set with an invalid condition was applied")
+	}
+}
+
+func TestApplyMetaBuckets(t *testing.T) {
+	dry, ok := 30.0, 60.0
+	sets := map[string]config.MetaDataFieldSet{
+		"comfort": {
+			Field:      "humidity",
+			Tag:        "comfort",
+			Hysteresis: 2,
+			Buckets: []config.MetaBucket{
+				{Below: &dry, Value: "dry"},
+				{Below: &ok, Value: "ok"},
+				{Value: "humid"},
+			},
+		},
+	}
+
+	// The device moves into a bucket once the boundary is passed by more
+	// than the hysteresis.
+	steps := []struct {
+		humidity float64
+		want     string
+	}{
+		{25, "dry"}, {31, "dry"}, {32.5, "ok"}, {29, "ok"}, {61, "ok"}, {62, "humid"}, {58.5, "humid"}, {57, "ok"},
+	}
+	for i, step := range steps {
+		tags := map[string]string{"model": "Bucket test", "id": "1"}
+		ApplyMeta(sets, tags, map[string]interface{}{"humidity": step.humidity}, time.Time{})
+		if tags["comfort"] != step.want {
+			t.Errorf("step %d: humidity %v expected comfort %s, got %s", i, step.humidity, step.want, tags["comfort"])
+		}
+	}
+
+	// Other devices keep their own bucket.
+	tags := map[string]string{"model": "Bucket test", "id": "2"}
+	ApplyMeta(sets, tags, map[string]interface{}{"humidity": 59.0}, time.Time{})
+	if tags["comfort"] != "ok" {
+		t.Errorf("expected a new device to start in ok, got %s", tags["comfort"])
+	}
+
+	// Points without the field are left alone.
+	tags = map[string]string{"model": "Bucket test", "id": "3"}
+	if r := ApplyMeta(sets, tags, map[string]interface{}{}, time.Time{}); len(r) != 1 || r[0].Matched {
+		t.Errorf("expected set to not match without the field, got %+v", r)
+	}
+}
+
+func TestPickBucketEvicts(t *testing.T) {
+	metaBuckets.lock.Lock()
+	metaBuckets.current = make(map[string]bucketState)
+	metaBuckets.lock.Unlock()
+
+	set := config.MetaDataFieldSet{Buckets: []config.MetaBucket{{Value: "any"}}}
+	start := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	for i := 0; i <= maxMetaBuckets; i++ {
+		pickBucket(set, fmt.Sprintf("id=%d", i), 1, start.Add(time.Duration(i)*time.Second))
+	}
+	if _, ok := metaBuckets.current["id=0"]; ok || len(metaBuckets.current) != maxMetaBuckets {
+		t.Errorf("expected the device read least recently to be forgotten, got %d devices", len(metaBuckets.current))
+	}
+}
+
+func TestValidateMetaSet(t *testing.T) {
+	low, high := 10.0, 20.0
+	invalid := map[string]config.MetaDataFieldSet{
+		"no field":    {Tag: "hot", Buckets: []config.MetaBucket{{Value: "yes"}}},
+		"no buckets":  {Field: "temperature_F", Tag: "hot"},
+		"open middle": {Field: "a", Tag: "b", Buckets: []config.MetaBucket{{Value: "x"}, {Below: &low}}},
+		"descending":  {Field: "a", Tag: "b", Buckets: []config.MetaBucket{{Below: &high}, {Below: &low}}},
+		"negative":    {Field: "a", Tag: "b", Hysteresis: -1, Buckets: []config.MetaBucket{{Below: &low}}},
+		"bad when":    {When: "id =="},
+	}
+	for name, set := range invalid {
+		if err := ValidateMetaSet(set); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	hot := config.MetaDataFieldSet{Field: "temperature_F", Tag: "hot", Buckets: []config.MetaBucket{{Below: &high}, {Value: "yes"}}}
+	if err := ValidateMetaSet(hot); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,208 @@
+package device
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/jrmycanady/slurp-rtl_433/config"
+	"github.com/jrmycanady/slurp-rtl_433/units"
+)
+
+// The values AddPsychrometrics can add.
+const (
+	DewPoint         = "dew_point"
+	HeatIndex        = "heat_index"
+	AbsoluteHumidity = "absolute_humidity"
+	WindChill        = "wind_chill"
+)
+
+// psychrometricValues are the values added if none are configured.
+var psychrometricValues = []string{DewPoint, HeatIndex, AbsoluteHumidity, WindChill}
+
+// temperatureFields, humidityFields and windSpeedFields are the names the
+// fields are looked for by if not configured, in order of preference.
+var (
+	temperatureFields = []string{"temperature_C", "temperature_F", "temperature_c", "temperature_f"}
+	humidityFields    = []string{"humidity"}
+	windSpeedFields   = []string{"wind_speed_mph", "wind_avg_mi_h", "wind_avg_km_h", "wind_speed_kph", "wind_avg_m_s", "wind_speed_ms"}
+)
+
+// ValidatePsychrometrics returns an error if the configuration is invalid.
+func ValidatePsychrometrics(cfg config.PsychrometricsConfig) error {
+	switch strings.ToUpper(cfg.TemperatureUnit) {
+	case "", "C", "F":
+	default:
+		return fmt.Errorf("invalid temperature unit %q, must be C or F", cfg.TemperatureUnit)
+	}
+	if cfg.WindSpeedUnit != "" {
+		if _, ok := windSpeedUnit(cfg.WindSpeedUnit); !ok {
+			return fmt.Errorf("invalid wind speed unit %q, must be mph, km/h or m/s", cfg.WindSpeedUnit)
+		}
+	}
+	for _, v := range cfg.Fields {
+		switch v {
+		case DewPoint, HeatIndex, AbsoluteHumidity, WindChill:
+		default:
+			return fmt.Errorf("unknown value %q, must be one of %s", v, strings.Join(psychrometricValues, ", "))
+		}
+	}
+	return nil
+}
+
+// AddPsychrometrics adds the dew point, heat index, absolute humidity and
+// wind chill to the fields of a point where the temperature, humidity and
+// wind speed they need are available. The temperatures are written in the
+// unit of the temperature field with a _C or _F suffix and the absolute
+// humidity in g/m³. An invalid configuration is logged and ignored.
+func AddPsychrometrics(cfg config.PsychrometricsConfig, model string, fields map[string]interface{}) {
+	if err := ValidatePsychrometrics(cfg); err != nil {
+		log.Error.With("model", model).Printf("skipping Psychrometrics: %s", err)
+		return
+	}
+
+	tempField, temp, ok := findField(fields, cfg.Temperature, temperatureFields)
+	if !ok {
+		return
+	}
+	unit := strings.ToUpper(cfg.TemperatureUnit)
+	if unit == "" {
+		unit = strings.ToUpper(tempField[len(tempField)-1:])
+	}
+	if unit != "C" && unit != "F" {
+		log.Error.With("model", model).Printf("unit of temperature field %s is unknown, set TemperatureUnit", tempField)
+		return
+	}
+	tempC := temp
+	if unit == "F" {
+		tempC = fahrenheitToCelsius(temp)
+	}
+	toUnit := func(c float64) float64 {
+		if unit == "F" {
+			return celsiusToFahrenheit(c)
+		}
+		return c
+	}
+
+	want := cfg.Fields
+	if len(want) == 0 {
+		want = psychrometricValues
+	}
+	_, rh, hasHumidity := findField(fields, cfg.Humidity, humidityFields)
+	hasHumidity = hasHumidity && rh > 0 && rh <= 100
+	windField, wind, hasWind := findField(fields, cfg.WindSpeed, windSpeedFields)
+
+	for _, v := range want {
+		switch {
+		case v == DewPoint && hasHumidity:
+			fields[DewPoint+"_"+unit] = round2(toUnit(dewPoint(tempC, rh)))
+		case v == HeatIndex && hasHumidity:
+			fields[HeatIndex+"_"+unit] = round2(toUnit(heatIndex(tempC, rh)))
+		case v == AbsoluteHumidity && hasHumidity:
+			fields[AbsoluteHumidity+"_g_m3"] = round2(absoluteHumidity(tempC, rh))
+		case v == WindChill && hasWind:
+			u := cfg.WindSpeedUnit
+			if u == "" {
+				u = windField
+			}
+			toKMH, ok := windSpeedUnit(u)
+			if !ok {
+				log.Error.With("model", model).Printf("unit of wind speed field %s is unknown, set WindSpeedUnit", windField)
+				continue
+			}
+			fields[WindChill+"_"+unit] = round2(toUnit(windChill(tempC, wind*toKMH)))
+		}
+	}
+}
+
+// findField returns the name and value of the numeric field named name or,
+// if name is empty, the first of names present.
+func findField(fields map[string]interface{}, name string, names []string) (string, float64, bool) {
+	if name != "" {
+		names = []string{name}
+	}
+	for _, n := range names {
+		if v, ok := units.Number(fields[n]); ok {
+			return n, v, true
+		}
+	}
+	return "", 0, false
+}
+
+// windSpeedUnit returns the factor converting the wind speed unit, or a
+// field name ending in the unit, to km/h.
+func windSpeedUnit(u string) (float64, bool) {
+	u = strings.ToLower(u)
+	switch {
+	case strings.HasSuffix(u, "mph") || strings.HasSuffix(u, "mi_h"):
+		return 1.609344, true
+	case strings.HasSuffix(u, "km/h") || strings.HasSuffix(u, "km_h") || strings.HasSuffix(u, "kph"):
+		return 1, true
+	case strings.HasSuffix(u, "m/s") || strings.HasSuffix(u, "m_s") || strings.HasSuffix(u, "ms"):
+		return 3.6, true
+	}
+	return 0, false
+}
+
+// dewPoint returns the dew point in °C using the Magnus formula with the
+// coefficients of Alduchov and Eskridge.
+func dewPoint(tempC float64, rh float64) float64 {
+	const a, b = 17.625, 243.04
+	g := math.Log(rh/100) + a*tempC/(b+tempC)
+	return b * g / (a - g)
+}
+
+// heatIndex returns the heat index in °C using the algorithm of the US
+// National Weather Service, which works in °F. The heat index is only
+// defined from 80°F (26.7°C), the air temperature is returned below it.
+func heatIndex(tempC float64, rh float64) float64 {
+	t := celsiusToFahrenheit(tempC)
+	if t < 80 {
+		return tempC
+	}
+	hi := 0.5 * (t + 61 + (t-68)*1.2 + rh*0.094)
+	if (hi+t)/2 < 80 {
+		return fahrenheitToCelsius(hi)
+	}
+
+	hi = -42.379 + 2.04901523*t + 10.14333127*rh - 0.22475541*t*rh - 0.00683783*t*t -
+		0.05481717*rh*rh + 0.00122874*t*t*rh + 0.00085282*t*rh*rh - 0.00000199*t*t*rh*rh
+	if rh < 13 && t >= 80 && t <= 112 {
+		hi -= (13 - rh) / 4 * math.Sqrt((17-math.Abs(t-95))/17)
+	} else if rh > 85 && t >= 80 && t <= 87 {
+		hi += (rh - 85) / 10 * (87 - t) / 5
+	}
+	return fahrenheitToCelsius(hi)
+}
+
+// absoluteHumidity returns the absolute humidity in g/m³.
+func absoluteHumidity(tempC float64, rh float64) float64 {
+	return 6.112 * math.Exp(17.67*tempC/(tempC+243.5)) * rh * 2.1674 / (273.15 + tempC)
+}
+
+// windChill returns the wind chill in °C using the formula of the US
+// National Weather Service and Environment Canada. The wind chill is only
+// defined at or below 10°C with wind of at least 4.8 km/h, the air
+// temperature is returned otherwise.
+func windChill(tempC float64, windKMH float64) float64 {
+	if tempC > 10 || windKMH < 4.8 {
+		return tempC
+	}
+	v := math.Pow(windKMH, 0.16)
+	return 13.12 + 0.6215*tempC - 11.37*v + 0.3965*tempC*v
+}
+
+// celsiusToFahrenheit converts °C to °F.
+func celsiusToFahrenheit(c float64) float64 {
+	return c*9/5 + 32
+}
+
+// fahrenheitToCelsius converts °F to °C.
+func fahrenheitToCelsius(f float64) float64 {
+	return (f - 32) * 5 / 9
+}
+
+// round2 rounds v to two decimal places.
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
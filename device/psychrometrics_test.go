@@ -0,0 +1,66 @@
+package device
+
+import (
+	"math"
+	"testing"
+
+	"github.com/jrmycanady/slurp-rtl_433/config"
+)
+
+func TestAddPsychrometrics(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    config.PsychrometricsConfig
+		fields map[string]interface{}
+		want   map[string]float64
+	}{
+		{
+			name:   "celsius",
+			fields: map[string]interface{}{"temperature_C": 20.0, "humidity": int64(50)},
+			want:   map[string]float64{"dew_point_C": 9.26, "heat_index_C": 20, "absolute_humidity_g_m3": 8.63},
+		},
+		{
+			name:   "fahrenheit heat",
+			fields: map[string]interface{}{"temperature_F": 90.0, "humidity": int64(70)},
+			want:   map[string]float64{"dew_point_F": 78.9, "heat_index_F": 105.92, "absolute_humidity_g_m3": 23.94},
+		},
+		{
+			name:   "wind chill",
+			fields: map[string]interface{}{"temperature_F": 0.0, "wind_speed_mph": 15.0},
+			want:   map[string]float64{"wind_chill_F": -19.4},
+		},
+		{
+			name:   "configured fields",
+			cfg:    config.PsychrometricsConfig{Temperature: "temp", TemperatureUnit: "c", Humidity: "rh", Fields: []string{DewPoint}},
+			fields: map[string]interface{}{"temp": 20.0, "rh": 50.0},
+			want:   map[string]float64{"dew_point_C": 9.26},
+		},
+	}
+
+	for _, test := range tests {
+		n := len(test.fields)
+		AddPsychrometrics(test.cfg, "test", test.fields)
+		for k, want := range test.want {
+			got, ok := test.fields[k].(float64)
+			if !ok || math.Abs(got-want) > 0.1 {
+				t.Errorf("%s: expected %s to be %v, got %v", test.name, k, want, test.fields[k])
+			}
+		}
+		if len(test.fields) != n+len(test.want) {
+			t.Errorf("%s: unexpected fields %v", test.name, test.fields)
+		}
+	}
+}
+
+func TestValidatePsychrometrics(t *testing.T) {
+	invalid := []config.PsychrometricsConfig{
+		{TemperatureUnit: "K"},
+		{WindSpeedUnit: "knots"},
+		{Fields: []string{"humidex"}},
+	}
+	for _, cfg := range invalid {
+		if err := ValidatePsychrometrics(cfg); err == nil {
+			t.Errorf("expected an error for %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package device
+
+import (
+	"sort"
+)
+
+// Device describes a device type that slurp-rtl_433 is able to parse.
+type Device struct {
+	// ModelName is the model name rtl_433 returns.
+	ModelName string
+
+	// Name is the name that is used when storing into influxdb.
+	Name string
+
+	// New creates a new empty DataPoint for the device.
+	New func() DataPoint
+}
+
+// Devices contains all supported devices keyed by the model name rtl_433
+// returns.
+var Devices = map[string]Device{
+	AcuRite5n1SensorModelName:      {AcuRite5n1SensorModelName, AcuRite5n1SensorName, func() DataPoint { return &AcuRite5n1SensorDataPoint{} }},
+	AcuRite606TXSensorModelName:    {AcuRite606TXSensorModelName, AcuRite606TXSensorName, func() DataPoint { return &AcuRite606TXSensorDataPoint{} }},
+	AcuRite609TXCSensorModelName:   {AcuRite609TXCSensorModelName, AcuRite609TXCSensorName, func() DataPoint { return &AcuRite609TXCSensorDataPoint{} }},
+	AcuRite986SensorModelName:      {AcuRite986SensorModelName, AcuRite986SensorName, func() DataPoint { return &AcuRite986SensorDataPoint{} }},
+	AcuRiteLightning6045MModelName: {AcuRiteLightning6045MModelName, AcuRiteLightning6045MName, func() DataPoint { return &AcuRiteLightning6045MDataPoint{} }},
+	AcuRiteRainGaugeModelName:      {AcuRiteRainGaugeModelName, AcuRiteRainGaugeName, func() DataPoint { return &AcuRiteRainGaugeDataPoint{} }},
+	AcuRiteTowerSensorModelName:    {AcuRiteTowerSensorModelName, AcuRiteTowerSensorName, func() DataPoint { return &AcuRiteTowerSensorDataPoint{} }},
+	Akhan100F14ModelName:           {Akhan100F14ModelName, Akhan100F14Name, func() DataPoint { return &Akhan100F14DataPoint{} }},
+	AmbientWeatherModelName:        {AmbientWeatherModelName, AmbientWeatherName, func() DataPoint { return &AmbientWeatherDataPoint{} }},
+	Bresser3CHSensorModelName:      {Bresser3CHSensorModelName, Bresser3CHSensorName, func() DataPoint { return &Bresser3CHSensorDataPoint{} }},
+	CalibeurRF104ModelName:         {CalibeurRF104ModelName, CalibeurRF104Name, func() DataPoint { return &CalibeurRF104DataPoint{} }},
+	CurrentCostTXModelName:         {CurrentCostTXModelName, CurrentCostTXName, func() DataPoint { return &CurrentCostTXDataPoint{} }},
+	DanfossCFRThermostatModelName:  {DanfossCFRThermostatModelName, DanfossCFRThermostatName, func() DataPoint { return &DanfossCFRThermostatDataPoint{} }},
+	EfergyE2CTModelName:            {EfergyE2CTModelName, EfergyE2CTName, func() DataPoint { return &EfergyE2CTDataPoint{} }},
+	EfergyOpticalModelName:         {EfergyOpticalModelName, EfergyOpticalName, func() DataPoint { return &EfergyOpticalDataPoint{} }},
+}
+
+// LookupModel returns the device for the rtl_433 model name provided. False
+// is returned if the model is not supported.
+func LookupModel(model string) (Device, bool) {
+	d, ok := Devices[model]
+	return d, ok
+}
+
+// ModelNames returns the rtl_433 model names of all supported devices in
+// sorted order.
+func ModelNames() []string {
+	names := make([]string, 0, len(Devices))
+	for name := range Devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
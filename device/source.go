@@ -0,0 +1,57 @@
+package device
+
+import (
+	"fmt"
+
+	influx "github.com/influxdata/influxdb/client/v2"
+	"github.com/jrmycanady/slurp-rtl_433/config"
+)
+
+// A SourcedDataPoint is a DataPoint read from a configured source. The tags
+// of the source are added to the point built by InfluxData.
+type SourcedDataPoint struct {
+	DataPoint
+
+	// Source is the name of the source the DataPoint was read from.
+	Source string
+
+	// Tags are the tags of the source.
+	Tags map[string]string
+}
+
+// WithSource wraps d with the source name and tags provided. d is returned as
+// is if there are no tags to add.
+func WithSource(d DataPoint, source string, tags map[string]string) DataPoint {
+	if len(tags) == 0 {
+		return d
+	}
+	return &SourcedDataPoint{DataPoint: d, Source: source, Tags: tags}
+}
+
+// InfluxData builds the point of the wrapped DataPoint and adds the source
+// tags. Tags already set by the device are kept. BuildPoint applies the Meta
+// sets afterwards, so their conditions see the source tags and the tags they
+// add replace those of the source.
+func (s *SourcedDataPoint) InfluxData(sets map[string]config.MetaDataFieldSet) (*influx.Point, error) {
+	p, err := s.DataPoint.InfluxData(sets)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := p.Tags()
+	for k, v := range s.Tags {
+		if _, ok := tags[k]; !ok {
+			tags[k] = v
+		}
+	}
+	fields, err := p.Fields()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read point fields: %s", err)
+	}
+
+	p, err = influx.NewPoint(p.Name(), tags, fields, p.Time())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create point: %s", err)
+	}
+	return p, nil
+}
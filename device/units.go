@@ -0,0 +1,117 @@
+package device
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/jrmycanady/slurp-rtl_433/units"
+)
+
+// decodedFields caches the fields each model decodes keyed by model name.
+var decodedFields sync.Map
+
+// decodedField is a field a DataPoint decodes from the rtl_433 output.
+type decodedField struct {
+	name    string
+	unit    units.Field
+	integer bool
+}
+
+// fieldsWithUnits returns the fields with a unit the DataPoint of the model
+// decodes.
+func fieldsWithUnits(model string, dp DataPoint) []decodedField {
+	if f, ok := decodedFields.Load(model); ok {
+		return f.([]decodedField)
+	}
+
+	fields := make([]decodedField, 0)
+	t := reflect.TypeOf(dp)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		u, ok := units.Parse(name)
+		if !ok {
+			continue
+		}
+		kind := t.Field(i).Type.Kind()
+		if kind == reflect.Ptr {
+			kind = t.Field(i).Type.Elem().Kind()
+		}
+		fields = append(fields, decodedField{name: name, unit: u, integer: kind >= reflect.Int && kind <= reflect.Int64})
+	}
+
+	decodedFields.Store(model, fields)
+	return fields
+}
+
+// alignUnits converts the fields of the line reported in another unit than
+// the DataPoint decodes to that unit, such as temperature_C from rtl_433 run
+// with -C si for a device decoding temperature_F. The line is returned as is
+// if nothing was converted.
+func alignUnits(model string, dp DataPoint, line []byte) []byte {
+	expected := fieldsWithUnits(model, dp)
+	if len(expected) == 0 {
+		return line
+	}
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return line
+	}
+
+	changed := false
+	for _, e := range expected {
+		if hasKey(raw, e.name) {
+			continue
+		}
+		for key, value := range raw {
+			u, ok := units.Parse(key)
+			if !ok || u.Quantity != e.unit.Quantity || u.Raw != e.unit.Raw || !strings.EqualFold(u.Base, e.unit.Base) {
+				continue
+			}
+			var v float64
+			if err := json.Unmarshal(value, &v); err != nil {
+				break
+			}
+			c, err := units.Convert(v, u.Unit, e.unit.Unit)
+			if err != nil {
+				break
+			}
+			if e.integer {
+				c = math.Round(c)
+			}
+			b, err := json.Marshal(c)
+			if err != nil {
+				break
+			}
+			delete(raw, key)
+			raw[e.name] = b
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return line
+	}
+
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return line
+	}
+	return b
+}
+
+// hasKey returns true if the object has the key, ignoring case as decoding
+// into a struct does.
+func hasKey(raw map[string]json.RawMessage, key string) bool {
+	for k := range raw {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package device
+
+import (
+	"testing"
+)
+
+func TestParseDataPointAlignsUnits(t *testing.T) {
+	dp, err := ParseDataPoint([]byte(`{"time" : "2026-01-02 12:00:00", "model" : "Acurite 986 Sensor", "id" : 1, "channel" : "1R", "temperature_C" : -20.0}`))
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	if f := dp.(*AcuRite986SensorDataPoint).TemperatureF; f != -4 {
+		t.Errorf("expected -20C to be read as -4F, got %v", f)
+	}
+
+	dp, err = ParseDataPoint([]byte(`{"time" : "2026-01-02 12:00:00", "model" : "Acurite Lightning 6045M", "id" : 1, "channel" : "A", "temperature_C" : 21.5, "humidity" : 40}`))
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	if f := dp.(*AcuRiteLightning6045MDataPoint).TemperatureF; f != 71 {
+		t.Errorf("expected 21.5C to be read as 71F, got %v", f)
+	}
+
+	dp, err = ParseDataPoint([]byte(`{"time" : "2026-01-02 12:00:00", "model" : "Acurite tower sensor", "id" : 1, "channel" : "A", "temperature_C" : 21.5}`))
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	if c := dp.(*AcuRiteTowerSensorDataPoint).TemperatureC; c != 21.5 {
+		t.Errorf("expected matching unit to be read as is, got %v", c)
+	}
+}
@@ -2,15 +2,26 @@ package dump
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"sync"
 	"time"
 
 	influxClient "github.com/influxdata/influxdb/client/v2"
 	"github.com/jrmycanady/slurp-rtl_433/config"
+	"github.com/jrmycanady/slurp-rtl_433/derive"
 	"github.com/jrmycanady/slurp-rtl_433/device"
+	"github.com/jrmycanady/slurp-rtl_433/energy"
 	"github.com/jrmycanady/slurp-rtl_433/logger"
+	"github.com/jrmycanady/slurp-rtl_433/rain"
 )
 
+// log is the logger for the dump package.
+var log = logger.For("dump")
+
+// influxLog is the logger for messages about the InfluxDB sink.
+var influxLog = log.With("sink", "influxdb")
+
 // Dumper represents the process that flushes datapoints to the differnet
 // output such as InfluxDB.
 type Dumper struct {
@@ -22,6 +33,67 @@ type Dumper struct {
 	lock           *sync.Mutex
 	running        bool
 	bp             influxClient.BatchPoints
+
+	// derived computes the Derived values from the points.
+	derived *derive.Engine
+
+	// rain tracks the rain counters of the Rain sensors.
+	rain *rain.Tracker
+
+	// energy tracks the energy totals of the Energy sensors.
+	energy *energy.Tracker
+
+	// out receives the rendered points when running in dry run mode.
+	out io.Writer
+
+	// stopErr is why the points in flight were not written when stopped. It
+	// is set before doneChan is closed.
+	stopErr error
+
+	// The following track the state of the dumper for status reporting and
+	// are protected by lock.
+	startTime           time.Time
+	lastDataPointTime   time.Time
+	lastInfluxDBContact time.Time
+	lastActivity        time.Time
+	retrying            bool
+	pendingPoints       int
+	dataPointsReceived  uint64
+	pointsWritten       uint64
+}
+
+// DumperStatus is a snapshot of the current state of a Dumper.
+type DumperStatus struct {
+	// Running is true if the dumper process is running.
+	Running bool `json:"running"`
+
+	// Retrying is true while the dumper is stuck retrying a failed flush.
+	Retrying bool `json:"retrying"`
+
+	// PendingPoints is the number of points waiting to be flushed.
+	PendingPoints int `json:"pendingPoints"`
+
+	// StartTime is the time the dumper was started.
+	StartTime time.Time `json:"startTime"`
+
+	// LastDataPointTime is the time the last datapoint was received. It is
+	// the zero time if no datapoint has been received.
+	LastDataPointTime time.Time `json:"lastDataPointTime"`
+
+	// LastInfluxDBContact is the time of the last successful ping or write to
+	// InfluxDB.
+	LastInfluxDBContact time.Time `json:"lastInfluxDBContact"`
+
+	// LastActivity is the last time the dump loop or a flush retry ran. It
+	// stops advancing if the dumper is wedged.
+	LastActivity time.Time `json:"lastActivity"`
+
+	// DataPointsReceived is the total number of datapoints received.
+	DataPointsReceived uint64 `json:"dataPointsReceived"`
+
+	// PointsWritten is the total number of points written to InfluxDB or
+	// rendered in dry run mode.
+	PointsWritten uint64 `json:"pointsWritten"`
 }
 
 // NewDumper creates a new dumper instance that is ready to start.
@@ -32,6 +104,10 @@ func NewDumper(cfg config.Config, dataPointChan <-chan device.DataPoint) *Dumper
 		doneChan:       make(chan struct{}),
 		cfg:            cfg,
 		lock:           &sync.Mutex{},
+		derived:        derive.New(cfg),
+		rain:           rain.NewTracker(cfg.FileMetaDataPath, cfg.DryRun),
+		energy:         energy.NewTracker(cfg.FileMetaDataPath, cfg.DryRun),
+		out:            os.Stdout,
 	}
 }
 
@@ -42,16 +118,109 @@ func (d *Dumper) SetRunning(state bool) {
 	d.running = state
 }
 
+// Running returns the current running state of the dumper.
+func (d *Dumper) Running() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.running
+}
+
+// Status returns a snapshot of the current state of the dumper.
+func (d *Dumper) Status() DumperStatus {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return DumperStatus{
+		Running:             d.running,
+		Retrying:            d.retrying,
+		PendingPoints:       d.pendingPoints,
+		StartTime:           d.startTime,
+		LastDataPointTime:   d.lastDataPointTime,
+		LastInfluxDBContact: d.lastInfluxDBContact,
+		LastActivity:        d.lastActivity,
+		DataPointsReceived:  d.dataPointsReceived,
+		PointsWritten:       d.pointsWritten,
+	}
+}
+
+// config returns the current configuration of the dumper. It must be used
+// for any setting that may change with Reload.
+func (d *Dumper) config() config.Config {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.cfg
+}
+
+// KeepStateInMemory keeps the rain counters and energy totals in memory only
+// so they are not read from or written to the meta data directory. It must be
+// called before StartDump.
+func (d *Dumper) KeepStateInMemory() {
+	d.rain = rain.NewTracker("", true)
+	d.energy = energy.NewTracker("", true)
+}
+
+// Reload applies the settings from cfg that may change while running. These
+// are the Meta rules, the Calibration sets, the Psychrometrics, the unit
+// system, the Derived values, the Rain and Energy sensors, the schema and the
+// flush thresholds. Points waiting to be flushed are kept. All other settings
+// require a restart and are ignored.
+func (d *Dumper) Reload(cfg config.Config) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.cfg.Meta = cfg.Meta
+	d.cfg.Calibration = cfg.Calibration
+	d.cfg.Psychrometrics = cfg.Psychrometrics
+	d.cfg.Units = cfg.Units
+	d.cfg.Derived = cfg.Derived
+	d.derived.Update(cfg)
+	d.cfg.Rain = cfg.Rain
+	d.cfg.Energy = cfg.Energy
+	d.cfg.InfluxDB.FlushDataPointCount = cfg.InfluxDB.FlushDataPointCount
+	d.cfg.InfluxDB.FlushTimeTrigger = cfg.InfluxDB.FlushTimeTrigger
+	d.cfg.InfluxDB.Schema = cfg.InfluxDB.Schema
+}
+
+// touch records that the dumper is still active.
+func (d *Dumper) touch() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.lastActivity = time.Now()
+}
+
+// setRetrying sets the retrying state of the dumper.
+func (d *Dumper) setRetrying(state bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.retrying = state
+}
+
 // StartDump attempts to start the dumber. An error is returned if it failed
 // to do so.
 func (d *Dumper) StartDump() error {
 
-	// Building influxdb client.
-	iClient, err := buildInfluxClient(d.cfg)
-	if err != nil {
-		return err
+	// Building influxdb client. No client is needed for a dry run.
+	if !d.cfg.DryRun {
+		iClient, err := buildInfluxClient(d.cfg)
+		if err != nil {
+			return err
+		}
+		d.iClient = iClient
 	}
-	d.iClient = iClient
+
+	// Loading the rain counters and energy totals. They start over if they
+	// can not be read.
+	if err := d.rain.Load(); err != nil {
+		log.Error.Printf("starting rain counters over: %s", err)
+	}
+	if err := d.energy.Load(); err != nil {
+		log.Error.Printf("starting energy totals over: %s", err)
+	}
+
+	d.lock.Lock()
+	d.startTime = time.Now()
+	d.lastInfluxDBContact = d.startTime
+	d.lastActivity = d.startTime
+	d.lock.Unlock()
 
 	// Starting dumper process.
 	go d.dump()
@@ -59,9 +228,12 @@ func (d *Dumper) StartDump() error {
 	return nil
 }
 
-// StopDump requests the dumper to stop.
-func (d *Dumper) StopDump() {
+// StopDump requests the dumper to stop and blocks until any points in flight
+// have been flushed. An error is returned if they could not be written.
+func (d *Dumper) StopDump() error {
 	d.cancelChan <- struct{}{}
+	<-d.doneChan
+	return d.stopErr
 }
 
 // dumper listens on the dataPointsChan and proceses the datapoints as they come
@@ -73,8 +245,9 @@ func (d *Dumper) dump() {
 	var err error
 	d.SetRunning(true)
 	defer d.SetRunning(false)
+	defer close(d.doneChan)
 
-	logger.Info.Println("dumper has entered the running state")
+	log.Info.Println("dumper has entered the running state")
 
 	d.bp, err = influxClient.NewBatchPoints(influxClient.BatchPointsConfig{
 		Database:  d.cfg.InfluxDB.Database,
@@ -85,50 +258,93 @@ func (d *Dumper) dump() {
 	}
 	lastFlushTime := time.Now()
 	flushTicker := time.NewTicker(time.Duration(10) * time.Second)
+	stateTicker := time.NewTicker(time.Duration(d.cfg.StateSaveSeconds) * time.Second)
+	defer d.saveState()
 	for {
+		d.touch()
 		select {
 		case <-flushTicker.C:
-			logger.Debug.Println("flush ticker ticked")
+			log.Debug.Println("flush ticker ticked")
 			if len(d.bp.Points()) == 0 {
 				continue
 			}
-			if time.Since(lastFlushTime).Seconds() >= d.cfg.InfluxDB.FlushTimeTrigger {
+			if time.Since(lastFlushTime).Seconds() >= d.config().InfluxDB.FlushTimeTrigger {
 				if !d.flushUntilCancel() {
-					logger.Info.Println("dumper received a request to cancel during dumper flush")
+					log.Info.Println("dumper received a request to cancel during dumper flush")
+					d.stopErr = fmt.Errorf("stopped while retrying a failed flush, %d points not written", len(d.bp.Points()))
 					return
 				}
 				lastFlushTime = time.Now()
 			}
+		case <-stateTicker.C:
+			d.saveState()
 		case dp := <-d.dataPointsChan:
-			logger.Debug.Println("new datapoint received")
+			log.Debug.Println("new datapoint received")
+			cfg := d.config()
+
+			p, err := device.BuildPoint(dp, cfg)
+			if err != nil {
+				log.Error.With("model", dp.GetModel()).Printf("failed to build InfluxDB point: %s", err)
+				continue
+			}
+			if p, err = d.rain.Observe(cfg.Rain, dp.GetModel(), p); err != nil {
+				log.Error.With("model", dp.GetModel()).Printf("failed to add rain: %s", err)
+			}
+			if p, err = d.energy.Observe(cfg.Energy, dp.GetModel(), p); err != nil {
+				log.Error.With("model", dp.GetModel()).Printf("failed to add energy: %s", err)
+			}
+
+			derived := d.derived.Observe(dp.GetModel(), p)
+			points, err := ApplySchema(cfg.InfluxDB.Schema, p)
+			if err != nil {
+				log.Error.With("model", dp.GetModel()).Printf("failed to apply %s schema: %s", cfg.InfluxDB.Schema, err)
+			}
+			points = append(points, derived...)
 
-			switch v := dp.(type) {
-			case *device.AmbientWeatherDataPoint:
-				p, err := v.InfluxData(d.cfg.Meta[device.AmbientWeatherModelName])
-				if err != nil {
-					continue
+			d.lock.Lock()
+			d.lastDataPointTime = time.Now()
+			d.dataPointsReceived++
+			d.lock.Unlock()
+
+			// Rendering the points in place of sending them if a dry run.
+			if cfg.DryRun {
+				for _, p := range points {
+					d.render(p, cfg.DryRunFormat)
 				}
+				continue
+			}
+			for _, p := range points {
 				d.bp.AddPoint(p)
 			}
 
-			logger.Debug.Printf("time until time flush: %f/%f", time.Since(lastFlushTime).Seconds(), d.cfg.InfluxDB.FlushTimeTrigger)
+			d.lock.Lock()
+			d.pendingPoints = len(d.bp.Points())
+			d.lock.Unlock()
+
+			log.Debug.Printf("time until time flush: %f/%f", time.Since(lastFlushTime).Seconds(), cfg.InfluxDB.FlushTimeTrigger)
 
 			// Flush if full or not sent in a while.
-			if len(d.bp.Points()) >= d.cfg.InfluxDB.FlushDataPointCount || time.Since(lastFlushTime).Seconds() >= d.cfg.InfluxDB.FlushTimeTrigger {
+			if len(d.bp.Points()) >= cfg.InfluxDB.FlushDataPointCount || time.Since(lastFlushTime).Seconds() >= cfg.InfluxDB.FlushTimeTrigger {
 
 				if !d.flushUntilCancel() {
-					logger.Info.Println("dumper received a request to cancel during dumper flush")
+					log.Info.Println("dumper received a request to cancel during dumper flush")
+					d.stopErr = fmt.Errorf("stopped while retrying a failed flush, %d points not written", len(d.bp.Points()))
 					return
 				}
 
 				lastFlushTime = time.Now()
 			}
 		case <-d.cancelChan:
-			logger.Info.Println("dumper has received a request to cancel")
+			log.Info.Println("dumper has received a request to cancel")
 
 			// attempt to flush any points in flight.
+			if d.cfg.DryRun {
+				return
+			}
 			if err = d.flush(); err != nil {
 				//TODO save points in flight.
+				influxLog.Error.Printf("failed to flush points in flight: %s", err)
+				d.stopErr = fmt.Errorf("failed to flush %d points in flight: %s", len(d.bp.Points()), err)
 			}
 			return
 
@@ -136,6 +352,33 @@ func (d *Dumper) dump() {
 	}
 }
 
+// saveState writes the rain counters and energy totals that have changed to
+// the meta data directory.
+func (d *Dumper) saveState() {
+	if d.rain.Dirty() {
+		if err := d.rain.Save(); err != nil {
+			log.Error.Printf("failed to save rain counters: %s", err)
+		}
+	}
+	if d.energy.Dirty() {
+		if err := d.energy.Save(); err != nil {
+			log.Error.Printf("failed to save energy totals: %s", err)
+		}
+	}
+}
+
+// render writes p to the dry run output in format.
+func (d *Dumper) render(p *influxClient.Point, format string) {
+	if err := RenderPoint(d.out, p, format); err != nil {
+		log.Error.Printf("failed to render point: %s", err)
+		return
+	}
+
+	d.lock.Lock()
+	d.pointsWritten++
+	d.lock.Unlock()
+}
+
 // flush flushes the datapoints to influx if possible.
 func (d *Dumper) flush() error {
 	var err error
@@ -143,6 +386,7 @@ func (d *Dumper) flush() error {
 	if err := d.iClient.Write(d.bp); err != nil {
 		return fmt.Errorf("failed to send points to InfluxDB %s", err)
 	}
+	count := len(d.bp.Points())
 
 	// clearing out points.
 	d.bp, err = influxClient.NewBatchPoints(influxClient.BatchPointsConfig{
@@ -153,7 +397,13 @@ func (d *Dumper) flush() error {
 		panic(err)
 	}
 
-	logger.Info.Printf("dumped %d datapoints to InfluxDB", len(d.bp.Points()))
+	d.lock.Lock()
+	d.lastInfluxDBContact = time.Now()
+	d.pointsWritten += uint64(count)
+	d.pendingPoints = 0
+	d.lock.Unlock()
+
+	influxLog.Info.With("points", count).Printf("dumped %d datapoints to InfluxDB", count)
 	return nil
 }
 
@@ -167,9 +417,11 @@ func (d *Dumper) flushUntilCancel() (ok bool) {
 	err = fmt.Errorf("error")
 	failures := 0
 	failureWaitTime := 1
+	defer d.setRetrying(false)
 	for err != nil {
 		if err = d.flush(); err != nil {
 			failures++
+			d.setRetrying(true)
 
 			if failures%10 == 0 {
 				if failures < 30 {
@@ -177,9 +429,10 @@ func (d *Dumper) flushUntilCancel() (ok bool) {
 				}
 			}
 
-			logger.Error.Printf("failed to send data to InfluxDB: %s", err)
-			logger.Info.Printf("waiting %d second before retry", failureWaitTime)
+			influxLog.Error.With("failures", failures).Printf("failed to send data to InfluxDB: %s", err)
+			influxLog.Info.Printf("waiting %d second before retry", failureWaitTime)
 			time.Sleep(time.Duration(failureWaitTime) * time.Second)
+			d.touch()
 		}
 		// Check for a cancel before trying again.
 		select {
@@ -193,6 +446,16 @@ func (d *Dumper) flushUntilCancel() (ok bool) {
 	return true
 }
 
+// PingInfluxDB verifies the InfluxDB server in the configuration provided can
+// be reached.
+func PingInfluxDB(cfg config.Config) error {
+	client, err := buildInfluxClient(cfg)
+	if err != nil {
+		return err
+	}
+	return client.Close()
+}
+
 // buildInfluxClient generates a new InfluxDB client based on the configuration provided.
 func buildInfluxClient(config config.Config) (influxClient.Client, error) {
 	var err error
@@ -0,0 +1,45 @@
+package dump
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/jrmycanady/slurp-rtl_433/config"
+	"github.com/jrmycanady/slurp-rtl_433/device"
+)
+
+func TestStopDumpReportsFailedFlush(t *testing.T) {
+	// InfluxDB is reachable but every write fails.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.Error(w, `{"error":"database not found"}`, http.StatusNotFound)
+	}))
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	cfg := config.NewConfig()
+	cfg.InfluxDB.FQDN = host
+	cfg.InfluxDB.Port, _ = strconv.Atoi(port)
+	dataPointChan := make(chan device.DataPoint)
+	d := NewDumper(cfg, dataPointChan)
+	d.KeepStateInMemory()
+	if err := d.StartDump(); err != nil {
+		t.Fatalf("failed to start dumper: %s", err)
+	}
+
+	dp, err := device.ParseDataPoint([]byte(`{"time" : "2018-07-05 01:07:43", "model" : "Ambient Weather F007TH Thermo-Hygrometer", "device" : 1, "channel" : 1, "battery" : "OK", "temperature_F" : 72.2, "humidity" : 40}`))
+	if err != nil {
+		t.Fatalf("failed to parse line: %s", err)
+	}
+	dataPointChan <- dp
+
+	if err := d.StopDump(); err == nil {
+		t.Errorf("expected the failed flush of the last point to be returned")
+	}
+}
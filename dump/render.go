@@ -0,0 +1,62 @@
+package dump
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	influxClient "github.com/influxdata/influxdb/client/v2"
+)
+
+const (
+	// FormatLine renders points as InfluxDB line protocol.
+	FormatLine = "line"
+
+	// FormatJSON renders points as indented JSON.
+	FormatJSON = "json"
+)
+
+// renderedPoint is the JSON representation of a point.
+type renderedPoint struct {
+	Measurement string                 `json:"measurement"`
+	Tags        map[string]string      `json:"tags"`
+	Fields      map[string]interface{} `json:"fields"`
+	Time        time.Time              `json:"time"`
+}
+
+// ValidFormat returns an error if format is not a supported render format.
+func ValidFormat(format string) error {
+	switch format {
+	case FormatLine, FormatJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown format %s, must be %s or %s", format, FormatLine, FormatJSON)
+}
+
+// RenderPoint writes p to w in the format provided.
+func RenderPoint(w io.Writer, p *influxClient.Point, format string) error {
+	switch format {
+	case FormatLine:
+		_, err := fmt.Fprintln(w, p.String())
+		return err
+	case FormatJSON:
+		fields, err := p.Fields()
+		if err != nil {
+			return fmt.Errorf("failed to read point fields: %s", err)
+		}
+		b, err := json.MarshalIndent(renderedPoint{
+			Measurement: p.Name(),
+			Tags:        p.Tags(),
+			Fields:      fields,
+			Time:        p.Time(),
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	}
+
+	return ValidFormat(format)
+}
@@ -0,0 +1,44 @@
+package dump
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	influxClient "github.com/influxdata/influxdb/client/v2"
+)
+
+func TestRenderPoint(t *testing.T) {
+	p, err := influxClient.NewPoint("AmbientWeather",
+		map[string]string{"channel": "1", "room": "attic"},
+		map[string]interface{}{"temperature_f": 72.2},
+		time.Unix(1530752863, 0))
+	if err != nil {
+		t.Fatalf("failed to build point: %s", err)
+	}
+
+	var b bytes.Buffer
+	if err = RenderPoint(&b, p, FormatLine); err != nil {
+		t.Fatalf("failed to render line: %s", err)
+	}
+	if want := p.String() + "\n"; b.String() != want {
+		t.Fatalf("expected %q, got %q", want, b.String())
+	}
+
+	b.Reset()
+	if err = RenderPoint(&b, p, FormatJSON); err != nil {
+		t.Fatalf("failed to render json: %s", err)
+	}
+	var r renderedPoint
+	if err = json.Unmarshal(b.Bytes(), &r); err != nil {
+		t.Fatalf("failed to decode json %s: %s", b.String(), err)
+	}
+	if r.Measurement != "AmbientWeather" || r.Tags["room"] != "attic" || r.Fields["temperature_f"] != 72.2 || !r.Time.Equal(time.Unix(1530752863, 0)) {
+		t.Fatalf("unexpected json point %+v", r)
+	}
+
+	if err = RenderPoint(&b, p, "xml"); err == nil {
+		t.Fatalf("expected error for unknown format")
+	}
+}
@@ -0,0 +1,165 @@
+package dump
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	influxClient "github.com/influxdata/influxdb/client/v2"
+	"github.com/jrmycanady/slurp-rtl_433/units"
+)
+
+const (
+	// SchemaDevice writes each reading to the measurement of its device with
+	// the fields of the device.
+	SchemaDevice = "device"
+
+	// SchemaQuantity writes each field with a known quantity to the
+	// measurement of the quantity, such as temperature, with a value field.
+	SchemaQuantity = "quantity"
+
+	// SchemaBoth writes both layouts.
+	SchemaBoth = "both"
+)
+
+const (
+	// QuantityValueField is the field holding the value in the quantity
+	// schema.
+	QuantityValueField = "value"
+
+	// QuantityFieldTag is the tag holding the name of the field the value
+	// came from in the quantity schema.
+	QuantityFieldTag = "field"
+
+	// QuantityUnitTag is the tag holding the unit of the value in the
+	// quantity schema.
+	QuantityUnitTag = "unit"
+
+	// QuantityDeviceTag is the tag holding the measurement of the device in
+	// the quantity schema.
+	QuantityDeviceTag = "device_measurement"
+)
+
+// quantity is the measurement of a field in the quantity schema. The unit is
+// taken from the field name if empty.
+type quantity struct {
+	measurement string
+	unit        string
+}
+
+// quantities maps the name of a field, without any unit suffix, to its
+// quantity. The counters reported by the devices, the change since the
+// previous reading and the totals over a period are kept in separate
+// measurements as they can not be aggregated together.
+var quantities = map[string]quantity{
+	"temperature":            {"temperature", ""},
+	"setpoint":               {"setpoint", ""},
+	"dew_point":              {"dew_point", ""},
+	"heat_index":             {"heat_index", ""},
+	"wind_chill":             {"wind_chill", ""},
+	"humidity":               {"humidity", "%"},
+	"absolute_humidity_g_m3": {"absolute_humidity", "g_m3"},
+	"wind_speed":             {"wind_speed", ""},
+	"wind_avg":               {"wind_speed", ""},
+	"wind_max":               {"wind_gust", ""},
+	"wind_dir_deg":           {"wind_direction", "deg"},
+	"rainfall_accumulation":  {"rain_counter", ""},
+	"rain":                   {"rain_counter", ""},
+	"rain_delta":             {"rain_delta", ""},
+	"rain_1h":                {"rain_1h", ""},
+	"rain_today":             {"rain_today", ""},
+	"rain_rate":              {"rain_rate", ""},
+	"pressure":               {"pressure", ""},
+	"power":                  {"power", "W"},
+	"power0":                 {"power", "W"},
+	"power1":                 {"power", "W"},
+	"power2":                 {"power", "W"},
+	"current":                {"current", "A"},
+	"energy_kwh":             {"energy_total", "kWh"},
+	"energy_delta_kwh":       {"energy_delta", "kWh"},
+	"energy_today_kwh":       {"energy_today", "kWh"},
+
+	// The Efergy Optical reports its estimate of the power as energy.
+	"energy": {"power", "kW"},
+}
+
+// ValidSchema returns an error if schema is not a supported output schema.
+func ValidSchema(schema string) error {
+	switch schema {
+	case SchemaDevice, SchemaQuantity, SchemaBoth:
+		return nil
+	}
+	return fmt.Errorf("unknown schema %s, must be %s, %s or %s", schema, SchemaDevice, SchemaQuantity, SchemaBoth)
+}
+
+// ApplySchema returns the points to write for the device point p in the
+// schema. An unknown schema is treated as SchemaDevice.
+func ApplySchema(schema string, p *influxClient.Point) ([]*influxClient.Point, error) {
+	switch schema {
+	case SchemaQuantity:
+		return QuantityPoints(p)
+	case SchemaBoth:
+		q, err := QuantityPoints(p)
+		return append([]*influxClient.Point{p}, q...), err
+	}
+	return []*influxClient.Point{p}, nil
+}
+
+// QuantityPoints returns a point for each field of p with a known quantity.
+// The point is written to the measurement of the quantity with the tags of p
+// plus the field name, unit and device measurement as tags. Fields holding
+// raw uncalibrated values and fields with an unknown quantity are left out.
+func QuantityPoints(p *influxClient.Point) ([]*influxClient.Point, error) {
+	fields, err := p.Fields()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read point fields: %s", err)
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	points := make([]*influxClient.Point, 0, len(names))
+	for _, name := range names {
+		q, unit, ok := quantityOf(name)
+		if !ok {
+			continue
+		}
+		value, ok := fields[name].(float64)
+		if !ok {
+			n, isInt := fields[name].(int64)
+			if !isInt {
+				continue
+			}
+			value = float64(n)
+		}
+
+		tags := p.Tags()
+		tags[QuantityFieldTag] = name
+		tags[QuantityUnitTag] = unit
+		tags[QuantityDeviceTag] = p.Name()
+		qp, err := influxClient.NewPoint(q.measurement, tags, map[string]interface{}{QuantityValueField: value}, p.Time())
+		if err != nil {
+			return points, fmt.Errorf("failed to create %s point: %s", q.measurement, err)
+		}
+		points = append(points, qp)
+	}
+
+	return points, nil
+}
+
+// quantityOf returns the quantity and unit of the field.
+func quantityOf(name string) (quantity, string, bool) {
+	if f, ok := units.Parse(name); ok {
+		q, known := quantities[f.Base]
+		unit := f.Unit
+		if f.Quantity == units.Temperature {
+			unit = strings.ToUpper(unit)
+		}
+		return q, unit, known && !f.Raw
+	}
+	q, ok := quantities[name]
+	return q, q.unit, ok
+}
@@ -0,0 +1,73 @@
+package dump
+
+import (
+	"testing"
+	"time"
+
+	influxClient "github.com/influxdata/influxdb/client/v2"
+)
+
+func TestApplySchema(t *testing.T) {
+	p, err := influxClient.NewPoint("AmbientWeather",
+		map[string]string{"model": "Ambient Weather F007TH Thermo-Hygrometer", "channel": "1", "room": "attic"},
+		map[string]interface{}{"temperature_f": 72.2, "temperature_f_raw": 74.2, "humidity": int64(40), "battery_ok": "OK"},
+		time.Unix(1530752863, 0))
+	if err != nil {
+		t.Fatalf("failed to build point: %s", err)
+	}
+
+	points, err := ApplySchema(SchemaQuantity, p)
+	if err != nil {
+		t.Fatalf("failed to apply schema: %s", err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("expected humidity and temperature points, got %d", len(points))
+	}
+	humidity, temp := points[0], points[1]
+	fields, _ := temp.Fields()
+	tags := temp.Tags()
+	if temp.Name() != "temperature" || fields[QuantityValueField] != 72.2 || tags[QuantityUnitTag] != "F" || tags[QuantityFieldTag] != "temperature_f" || tags[QuantityDeviceTag] != "AmbientWeather" || tags["room"] != "attic" {
+		t.Errorf("unexpected temperature point %s", temp)
+	}
+	fields, _ = humidity.Fields()
+	if humidity.Name() != "humidity" || fields[QuantityValueField] != 40.0 || humidity.Tags()[QuantityUnitTag] != "%" {
+		t.Errorf("unexpected humidity point %s", humidity)
+	}
+
+	if points, _ := ApplySchema(SchemaBoth, p); len(points) != 3 || points[0] != p {
+		t.Errorf("expected the device point followed by the quantities, got %d points", len(points))
+	}
+	if points, _ := ApplySchema(SchemaDevice, p); len(points) != 1 || points[0] != p {
+		t.Errorf("expected only the device point")
+	}
+}
+
+func TestQuantityPointsSeparateCountersAndTotals(t *testing.T) {
+	p, err := influxClient.NewPoint("AcuRite5n1Sensor",
+		map[string]string{"model": "Acurite 5n1 sensor"},
+		map[string]interface{}{"rainfall_accumulation_mm": 250.0, "rain_delta_mm": 0.5, "rain_today_mm": 3.0, "energy": 1.2},
+		time.Unix(1530752863, 0))
+	if err != nil {
+		t.Fatalf("failed to build point: %s", err)
+	}
+
+	points, err := QuantityPoints(p)
+	if err != nil {
+		t.Fatalf("failed to build quantity points: %s", err)
+	}
+	got := map[string]string{}
+	for _, qp := range points {
+		got[qp.Tags()[QuantityFieldTag]] = qp.Name() + " " + qp.Tags()[QuantityUnitTag]
+	}
+	want := map[string]string{
+		"rainfall_accumulation_mm": "rain_counter mm",
+		"rain_delta_mm":            "rain_delta mm",
+		"rain_today_mm":            "rain_today mm",
+		"energy":                   "power kW",
+	}
+	for field, m := range want {
+		if got[field] != m {
+			t.Errorf("expected %s in %s, got %q", field, m, got[field])
+		}
+	}
+}
@@ -0,0 +1,331 @@
+// Package energy integrates the power reported by energy monitors, such as
+// the Efergy and CurrentCost sensors, over time into the energy used in kWh.
+//
+// The power is read from the power fields of a reading or computed from the
+// current with a configured voltage and power factor. The energy between two
+// readings of a sensor is the average of their power multiplied by the time
+// between them. The running total of each sensor, along with the energy of
+// the day, is saved in the meta data directory so it carries on across
+// restarts. The times of the readings are used rather than the time they are
+// processed, so the day ends at midnight in the time zone rtl_433 writes
+// times in.
+package energy
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	influx "github.com/influxdata/influxdb/client/v2"
+	"github.com/jrmycanady/slurp-rtl_433/config"
+	"github.com/jrmycanady/slurp-rtl_433/device"
+	"github.com/jrmycanady/slurp-rtl_433/file"
+	"github.com/jrmycanady/slurp-rtl_433/logger"
+	"github.com/jrmycanady/slurp-rtl_433/units"
+)
+
+// log is the logger for the energy package.
+var log = logger.For("energy")
+
+const (
+	// FileName is the name of the file within the meta data directory
+	// holding the energy totals.
+	FileName = "energy.json"
+
+	// stateVersion is the version of the energy file format.
+	stateVersion = 1
+
+	// DefaultVoltage is the voltage the power is computed from the current
+	// with if not configured.
+	DefaultVoltage = 230
+
+	// DefaultPowerFactor is the power factor if not configured.
+	DefaultPowerFactor = 1
+
+	// DefaultMaxGapSeconds is the longest gap in reception energy is counted
+	// across if not configured.
+	DefaultMaxGapSeconds = 300
+
+	// dayLayout formats the day of a reading.
+	dayLayout = "2006-01-02"
+)
+
+// The fields added.
+const (
+	// PowerField is the power in watts. It is only added if computed from
+	// the current or the sum of several power fields.
+	PowerField = "power"
+
+	// TotalField is the energy used since the sensor was first read in kWh.
+	TotalField = "energy_kwh"
+
+	// DeltaField is the energy used since the previous reading in kWh.
+	DeltaField = "energy_delta_kwh"
+
+	// TodayField is the energy used since midnight in kWh.
+	TodayField = "energy_today_kwh"
+)
+
+// powerFields and currentFields are the fields the power and current are read
+// from if not configured. Every power field present is summed.
+var (
+	powerFields   = []string{"power", "power0", "power1", "power2"}
+	currentFields = []string{"current"}
+)
+
+// modelPower is how the power is read from the models that report it under
+// another name if neither powerFields nor currentField are configured.
+var modelPower = map[string]config.EnergyConfig{
+	// The Efergy Optical reports its estimate of the power in kW as energy.
+	device.EfergyOpticalModelName: {PowerFields: []string{"energy"}, PowerScale: 1000},
+}
+
+// A Tracker keeps the energy total of every sensor. It is safe for concurrent
+// use.
+type Tracker struct {
+	file *file.JSONFile
+
+	// lock protects sensors.
+	lock    *sync.Mutex
+	sensors map[string]*Sensor
+}
+
+// Sensor is the energy total of a sensor.
+type Sensor struct {
+	Key string `json:"key"`
+
+	// Power is the last power in watts read at Time.
+	Power float64   `json:"power"`
+	Time  time.Time `json:"time"`
+
+	// Total is the energy used since the sensor was first read in kWh. The
+	// totals are kept unrounded so small amounts are not lost.
+	Total float64 `json:"total"`
+
+	// Today is the energy used on Day in kWh.
+	Day   string  `json:"day"`
+	Today float64 `json:"today"`
+}
+
+// stateFile is the on disk format of the energy file.
+type stateFile struct {
+	Version int       `json:"version"`
+	Sensors []*Sensor `json:"sensors"`
+}
+
+// NewTracker creates an empty Tracker saved in dir. Nothing is written if
+// readOnly is true. An empty dir keeps the totals in memory only.
+func NewTracker(dir string, readOnly bool) *Tracker {
+	return &Tracker{
+		file:    file.NewJSONFile(dir, FileName, readOnly),
+		lock:    &sync.Mutex{},
+		sensors: make(map[string]*Sensor),
+	}
+}
+
+// Validate returns an error if the configuration is invalid.
+func Validate(cfg config.EnergyConfig) error {
+	if len(cfg.PowerFields) > 0 && cfg.CurrentField != "" {
+		return fmt.Errorf("only one of powerFields and currentField may be set")
+	}
+	if cfg.PowerScale < 0 {
+		return fmt.Errorf("powerScale must not be negative")
+	}
+	if cfg.Voltage < 0 {
+		return fmt.Errorf("voltage must not be negative")
+	}
+	if cfg.PowerFactor < 0 || cfg.PowerFactor > 1 {
+		return fmt.Errorf("powerFactor must be between 0 and 1")
+	}
+	if cfg.MaxGapSeconds < 0 {
+		return fmt.Errorf("maxGapSeconds must not be negative")
+	}
+	return nil
+}
+
+// Path returns the path of the energy file.
+func (t *Tracker) Path() string {
+	return t.file.Path()
+}
+
+// Load reads the energy file replacing the totals of any sensors already
+// known. A missing file is not an error.
+func (t *Tracker) Load() error {
+	sf := stateFile{}
+	if ok, err := t.file.Load(&sf); !ok {
+		return err
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.sensors = make(map[string]*Sensor)
+	for _, s := range sf.Sensors {
+		t.sensors[s.Key] = s
+	}
+
+	return nil
+}
+
+// Dirty returns true if a total has changed since the last write.
+func (t *Tracker) Dirty() bool {
+	return t.file.Dirty()
+}
+
+// Save writes the totals of all sensors to the energy file.
+func (t *Tracker) Save() error {
+	return t.file.Save(t.snapshot)
+}
+
+// snapshot returns a copy of the state of all sensors in the file format.
+func (t *Tracker) snapshot() interface{} {
+	t.lock.Lock()
+	sf := stateFile{Version: stateVersion, Sensors: make([]*Sensor, 0, len(t.sensors))}
+	for _, s := range t.sensors {
+		c := *s
+		sf.Sensors = append(sf.Sensors, &c)
+	}
+	t.lock.Unlock()
+	sort.Slice(sf.Sensors, func(i, j int) bool { return sf.Sensors[i].Key < sf.Sensors[j].Key })
+
+	return sf
+}
+
+// Observe adds the power and the energy used since the previous reading of
+// the sensor, since midnight and in total to the point if Energy is
+// configured for the model. The point is returned as is if the model is not
+// configured or has no power or current. Readings older than the last one of
+// the sensor only get the power. The first reading of a sensor, and one after
+// a gap in reception that is not filled, has no energy since the previous
+// reading. Observe may be called on a nil Tracker, which returns p.
+func (t *Tracker) Observe(cfgs map[string]config.EnergyConfig, model string, p *influx.Point) (*influx.Point, error) {
+	if t == nil {
+		return p, nil
+	}
+	cfg, ok := cfgs[model]
+	if !ok {
+		return p, nil
+	}
+	fields, err := p.Fields()
+	if err != nil {
+		return p, fmt.Errorf("failed to read point fields: %s", err)
+	}
+	if d, ok := modelPower[model]; ok && len(cfg.PowerFields) == 0 && cfg.CurrentField == "" {
+		cfg.PowerFields, cfg.PowerScale = d.PowerFields, d.PowerScale
+	}
+	power, ok := readPower(cfg, fields)
+	if !ok {
+		return p, nil
+	}
+
+	t.update(cfg, model, p.Tags(), power, p.Time(), fields)
+	np, err := influx.NewPoint(p.Name(), p.Tags(), fields, p.Time())
+	if err != nil {
+		return p, fmt.Errorf("failed to create point: %s", err)
+	}
+	return np, nil
+}
+
+// update records the power of the sensor read at tm and adds the energy
+// fields.
+func (t *Tracker) update(cfg config.EnergyConfig, model string, tags map[string]string, power float64, tm time.Time, fields map[string]interface{}) {
+	maxGap := time.Duration(cfg.MaxGapSeconds) * time.Second
+	if maxGap <= 0 {
+		maxGap = DefaultMaxGapSeconds * time.Second
+	}
+	key := device.DeviceKey(tags)
+	day := tm.Format(dayLayout)
+	l := log.With("model", model, "sensor", key)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s, known := t.sensors[key]
+	if !known {
+		s = &Sensor{Key: key, Power: power, Time: tm, Day: day}
+		t.sensors[key] = s
+	} else if tm.Before(s.Time) {
+		l.Debug.Printf("ignoring power read at %s before the last reading at %s", tm, s.Time)
+		return
+	}
+
+	if s.Day != day {
+		s.Day = day
+		s.Today = 0
+	}
+	if known {
+		gap := tm.Sub(s.Time)
+		if gap <= maxGap || cfg.FillGaps {
+			delta := (s.Power + power) / 2 * gap.Hours() / 1000
+			s.Total += delta
+			s.Today += delta
+			fields[DeltaField] = units.Round(delta)
+		} else {
+			l.Info.Printf("no reading for %s, not counting the energy used", gap)
+		}
+	}
+	s.Power = power
+	s.Time = tm
+
+	fields[TotalField] = units.Round(s.Total)
+	fields[TodayField] = units.Round(s.Today)
+	t.file.MarkDirty()
+}
+
+// readPower returns the power in watts of the fields of a reading and adds it as
+// the power field if computed from the current or the sum of several fields.
+// False is returned if none of the fields are present.
+func readPower(cfg config.EnergyConfig, fields map[string]interface{}) (float64, bool) {
+	if len(cfg.PowerFields) == 0 && cfg.CurrentField == "" {
+		if power, ok := sumPower(powerFields, 1, fields); ok {
+			return power, true
+		}
+	}
+	if len(cfg.PowerFields) > 0 {
+		scale := cfg.PowerScale
+		if scale == 0 {
+			scale = 1
+		}
+		return sumPower(cfg.PowerFields, scale, fields)
+	}
+
+	names := currentFields
+	if cfg.CurrentField != "" {
+		names = []string{cfg.CurrentField}
+	}
+	voltage := cfg.Voltage
+	if voltage == 0 {
+		voltage = DefaultVoltage
+	}
+	pf := cfg.PowerFactor
+	if pf == 0 {
+		pf = DefaultPowerFactor
+	}
+	for _, n := range names {
+		if current, ok := units.Number(fields[n]); ok {
+			power := units.Round(current * voltage * pf)
+			fields[PowerField] = power
+			return power, true
+		}
+	}
+	return 0, false
+}
+
+// sumPower returns the sum of the fields present multiplied by scale. The sum
+// is added as the power field unless it is the power field as is.
+func sumPower(names []string, scale float64, fields map[string]interface{}) (float64, bool) {
+	sum, found := 0.0, make([]string, 0, len(names))
+	for _, n := range names {
+		if v, ok := units.Number(fields[n]); ok {
+			sum += v
+			found = append(found, n)
+		}
+	}
+	if len(found) == 0 {
+		return 0, false
+	}
+	sum = units.Round(sum * scale)
+	if len(found) > 1 || found[0] != PowerField || scale != 1 {
+		fields[PowerField] = sum
+	}
+	return sum, true
+}
@@ -0,0 +1,152 @@
+package energy
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	influx "github.com/influxdata/influxdb/client/v2"
+	"github.com/jrmycanady/slurp-rtl_433/config"
+	"github.com/jrmycanady/slurp-rtl_433/device"
+)
+
+const efergy = "Efergy e2 CT"
+
+// reading is a current read at an offset from the start of a test.
+type reading struct {
+	after   time.Duration
+	current int64
+}
+
+// start is ten minutes before midnight so the tests can cross it.
+var start = time.Date(2026, 5, 1, 23, 50, 0, 0, time.UTC)
+
+// observe observes each reading in order and returns the fields of the last.
+func observe(t *testing.T, tr *Tracker, cfg config.EnergyConfig, readings []reading) map[string]interface{} {
+	var fields map[string]interface{}
+	for _, r := range readings {
+		p, err := influx.NewPoint("EfergyE2CT", map[string]string{"model": efergy, "id": "42"}, map[string]interface{}{"current": r.current}, start.Add(r.after))
+		if err != nil {
+			t.Fatalf("failed to create point: %s", err)
+		}
+		p, err = tr.Observe(map[string]config.EnergyConfig{efergy: cfg}, efergy, p)
+		if err != nil {
+			t.Fatalf("failed to observe: %s", err)
+		}
+		fields, _ = p.Fields()
+	}
+	return fields
+}
+
+func TestObserve(t *testing.T) {
+	// Five minutes averaging 1.2 kW.
+	used := []reading{{0, 10}, {5 * time.Minute, 1}}
+	gap := []reading{{-2 * time.Hour, 1}, {-time.Hour, 1}}
+	tests := []struct {
+		name     string
+		fillGaps bool
+		readings []reading
+		want     map[string]interface{}
+	}{
+		{
+			name:     "first reading",
+			readings: used[:1],
+			want:     map[string]interface{}{"current": int64(10), PowerField: 2160.0, TotalField: 0.0, TodayField: 0.0},
+		},
+		{
+			name:     "energy used",
+			readings: used,
+			want:     map[string]interface{}{"current": int64(1), PowerField: 216.0, DeltaField: 0.099, TotalField: 0.099, TodayField: 0.099},
+		},
+		{
+			name:     "midnight",
+			readings: append(used, reading{15 * time.Minute, 1}),
+			want:     map[string]interface{}{"current": int64(1), PowerField: 216.0, DeltaField: 0.036, TotalField: 0.135, TodayField: 0.036},
+		},
+		{
+			name:     "older reading",
+			readings: append(used, reading{0, 5}),
+			want:     map[string]interface{}{"current": int64(5), PowerField: 1080.0},
+		},
+		{
+			name:     "gap",
+			readings: gap,
+			want:     map[string]interface{}{"current": int64(1), PowerField: 216.0, TotalField: 0.0, TodayField: 0.0},
+		},
+		{
+			name:     "filled gap",
+			fillGaps: true,
+			readings: gap,
+			want:     map[string]interface{}{"current": int64(1), PowerField: 216.0, DeltaField: 0.216, TotalField: 0.216, TodayField: 0.216},
+		},
+	}
+	for _, tt := range tests {
+		cfg := config.EnergyConfig{Voltage: 240, PowerFactor: 0.9, MaxGapSeconds: 600, FillGaps: tt.fillGaps}
+		if fields := observe(t, NewTracker("", true), cfg, tt.readings); !reflect.DeepEqual(fields, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, fields)
+		}
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.EnergyConfig{Voltage: 240, PowerFactor: 0.9, MaxGapSeconds: 600}
+	tr := NewTracker(dir, false)
+	observe(t, tr, cfg, []reading{{0, 10}, {5 * time.Minute, 1}})
+	if err := tr.Save(); err != nil {
+		t.Fatalf("failed to save: %s", err)
+	}
+
+	tr = NewTracker(dir, false)
+	if err := tr.Load(); err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+	fields := observe(t, tr, cfg, []reading{{15 * time.Minute, 1}})
+	if fields[DeltaField] != 0.036 || fields[TotalField] != 0.135 {
+		t.Errorf("expected the total to carry on after loading, got %v", fields)
+	}
+}
+
+func TestObserveEfergyOptical(t *testing.T) {
+	tr := NewTracker("", true)
+	cfgs := map[string]config.EnergyConfig{device.EfergyOpticalModelName: {}}
+	var fields map[string]interface{}
+	for _, line := range []string{
+		`{"time" : "2026-05-01 12:00:00", "model" : "Efergy Optical", "pulses" : 1210, "energy" : 1.2}`,
+		`{"time" : "2026-05-01 12:04:00", "model" : "Efergy Optical", "pulses" : 1380, "energy" : 1.8}`,
+	} {
+		dp, err := device.ParseDataPoint([]byte(line))
+		if err != nil {
+			t.Fatalf("failed to parse line: %s", err)
+		}
+		p, err := dp.InfluxData(nil)
+		if err != nil {
+			t.Fatalf("failed to create point: %s", err)
+		}
+		if p, err = tr.Observe(cfgs, dp.GetModel(), p); err != nil {
+			t.Fatalf("failed to observe: %s", err)
+		}
+		fields, _ = p.Fields()
+	}
+
+	// Four minutes averaging 1.5 kW.
+	if fields[PowerField] != 1800.0 || fields[DeltaField] != 0.1 || fields[TotalField] != 0.1 {
+		t.Errorf("expected the energy field read as kW, got %v", fields)
+	}
+}
+
+func TestReadPower(t *testing.T) {
+	fields := map[string]interface{}{"power0": int64(100), "power1": int64(250), "power2": int64(0)}
+	if p, ok := readPower(config.EnergyConfig{}, fields); !ok || p != 350 || fields[PowerField] != 350.0 {
+		t.Errorf("expected the power fields summed, got %v %v", p, fields)
+	}
+
+	fields = map[string]interface{}{"energy": 1.5}
+	if p, ok := readPower(config.EnergyConfig{PowerFields: []string{"energy"}, PowerScale: 1000}, fields); !ok || p != 1500 {
+		t.Errorf("expected the scaled power, got %v %v", p, ok)
+	}
+
+	if _, ok := readPower(config.EnergyConfig{}, map[string]interface{}{"pulses": int64(3)}); ok {
+		t.Errorf("expected no power without power or current fields")
+	}
+}
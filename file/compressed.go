@@ -0,0 +1,160 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/jrmycanady/slurp-rtl_433/device"
+	"github.com/klauspost/compress/zstd"
+)
+
+// decompressors build a decompressing reader for each supported extension of
+// a compressed log rotation.
+var decompressors = map[string]func(r io.Reader) (io.ReadCloser, error){
+	".gz": func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+	".zst": func(r io.Reader) (io.ReadCloser, error) {
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	},
+}
+
+// Compressed returns true if the file at path is a compressed log rotation.
+func Compressed(path string) bool {
+	_, ok := decompressors[filepath.Ext(path)]
+	return ok
+}
+
+// Decompress returns a reader of the decompressed contents of r if path is a
+// compressed log rotation. Otherwise r is returned as is.
+func Decompress(path string, r io.Reader) (io.ReadCloser, error) {
+	d, ok := decompressors[filepath.Ext(path)]
+	if !ok {
+		return ioutil.NopCloser(r), nil
+	}
+	return d(r)
+}
+
+// decompressedFingerprint returns the fingerprint of the first n decompressed
+// bytes of the compressed file at path.
+func decompressedFingerprint(path string, n int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	r, err := Decompress(path, f)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	b := make([]byte, n)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return "", fmt.Errorf("failed to read fingerprint: %s", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// findPriorIdentity searches the known uncompressed log files for the one
+// the compressed file at path was created from by comparing fingerprints. The
+// prior file must no longer exist at its last known path as the compression
+// may still be in progress, in which case inProgress is true. Nil is returned
+// if there is no match. The files are decompressed without holding the lock
+// so the status and other lookups are not held up.
+func (f *Filer) findPriorIdentity(path string) (prior *LogFile, inProgress bool) {
+	f.lock.Lock()
+	files := make([]*LogFile, 0, len(f.Files))
+	for _, l := range f.Files {
+		files = append(files, l)
+	}
+	f.lock.Unlock()
+
+	for _, l := range files {
+		// The identity of a file being slurped may change under it.
+		s := l.snapshot()
+		if s.FingerprintSize == 0 || Compressed(s.LogFilePath) {
+			continue
+		}
+		fp, err := decompressedFingerprint(path, s.FingerprintSize)
+		if err != nil || fp != s.Fingerprint {
+			continue
+		}
+
+		if stat, err := os.Stat(s.LogFilePath); err == nil {
+			if sys, ok := stat.Sys().(*syscall.Stat_t); ok && sys.Ino == s.Inode && (s.Dev == 0 || uint64(sys.Dev) == s.Dev) {
+				return l, true
+			}
+		}
+		return l, false
+	}
+
+	return nil, false
+}
+
+// slurpCompressed reads the remainder of a compressed log file after the
+// offset and sends the results to the dataPointChan. Compressed files never
+// change so the file is marked done once the end is reached.
+func (l *LogFile) slurpCompressed(f *os.File, dataPointChan chan<- device.DataPoint) {
+	flog := l.log()
+
+	d, err := Decompress(l.path(), f)
+	if err != nil {
+		flog.Error.Printf("failed to open decompressing reader: %s", err)
+		return
+	}
+	defer d.Close()
+
+	r := bufio.NewReader(d)
+	if _, err = io.CopyN(ioutil.Discard, r, l.offset()); err != nil {
+		flog.Error.With("offset", l.offset()).Printf("failed to skip to offset: %s", err)
+		return
+	}
+
+	for {
+		// Check to see if we should stop.
+		select {
+		case <-l.slurpCancelChan:
+			flog.Verbose.Println("stop for slurper received")
+			return
+		default:
+		}
+
+		raw, err := r.ReadBytes(lf)
+		if err != nil && err != io.EOF {
+			// The partial line is dropped so the next attempt resumes at the
+			// start of it.
+			flog.Error.With("offset", l.offset()).Printf("failed to read compressed file: %s", err)
+			return
+		}
+		if line := bytes.TrimRight(raw, "\r\n"); len(line) > 0 {
+			if err := l.savePoint(line, dataPointChan); err != nil {
+				flog.Error.With("offset", l.offset()).Printf("failed to save data point: %s", err)
+			}
+		}
+		l.advance(int64(len(raw)))
+
+		if err == io.EOF {
+			l.setDone()
+			l.Save()
+			flog.Info.With("offset", l.offset()).Println("finished slurping compressed file")
+			return
+		}
+		l.Save()
+	}
+}
@@ -0,0 +1,249 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jrmycanady/slurp-rtl_433/device"
+)
+
+const (
+	// DiscoveredFileName is the name of the file within the meta data
+	// directory holding the discovered devices.
+	DiscoveredFileName = "discovered.json"
+
+	// discoveredVersion is the version of the discovered file format.
+	discoveredVersion = 1
+)
+
+// A DiscoveredDevice is a model, id and channel combination that has been
+// heard.
+type DiscoveredDevice struct {
+	Model   string `json:"model"`
+	ID      string `json:"id"`
+	Channel string `json:"channel"`
+
+	// Supported is true if the model is supported and so may be given Meta
+	// rules.
+	Supported bool `json:"supported"`
+
+	// Count is the number of lines heard.
+	Count int64 `json:"count"`
+
+	// RSSI is the most recent signal strength in dB if rtl_433 reports it.
+	RSSI *float64 `json:"rssi,omitempty"`
+
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+
+	// Sample is the most recent line heard.
+	Sample json.RawMessage `json:"sample"`
+}
+
+// key returns the key identifying the device.
+func (d *DiscoveredDevice) key() string {
+	return d.Model + "\x00" + d.ID + "\x00" + d.Channel
+}
+
+// Discovered keeps an inventory of every device heard, supported or not, so
+// new sensors can be identified. It's persisted to the discovered file in the
+// meta data directory by the Filer along with the state. Once maxDevices are
+// known the device heard least recently is forgotten to make room.
+type Discovered struct {
+	dir        string
+	readOnly   bool
+	maxDevices int
+
+	// lock protects devices and dirty.
+	lock    *sync.Mutex
+	devices map[string]*DiscoveredDevice
+	dirty   bool
+}
+
+// discoveredFile is the on disk format of the discovered file.
+type discoveredFile struct {
+	Version int                 `json:"version"`
+	Devices []*DiscoveredDevice `json:"devices"`
+}
+
+// discoveredLine contains the fields read from a line to identify a device.
+type discoveredLine struct {
+	Model   string      `json:"model"`
+	ID      interface{} `json:"id"`
+	Channel interface{} `json:"channel"`
+	RSSI    *float64    `json:"rssi"`
+}
+
+// NewDiscovered creates an empty Discovered that is saved in dir. Nothing is
+// written if readOnly is true. A maxDevices of 0 or less is unlimited.
+func NewDiscovered(dir string, readOnly bool, maxDevices int) *Discovered {
+	return &Discovered{
+		dir:        dir,
+		readOnly:   readOnly,
+		maxDevices: maxDevices,
+		lock:       &sync.Mutex{},
+		devices:    make(map[string]*DiscoveredDevice),
+	}
+}
+
+// Path returns the path of the discovered file.
+func (d *Discovered) Path() string {
+	return filepath.Join(d.dir, DiscoveredFileName)
+}
+
+// Load reads the discovered file replacing any devices already known. A
+// missing file is not an error.
+func (d *Discovered) Load() error {
+	b, err := ioutil.ReadFile(d.Path())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read discovered file: %s", err)
+	}
+
+	df := discoveredFile{}
+	if err = json.Unmarshal(b, &df); err != nil {
+		return fmt.Errorf("failed to parse discovered file %s: %s", d.Path(), err)
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.devices = make(map[string]*DiscoveredDevice)
+	for _, dev := range df.Devices {
+		d.devices[dev.key()] = dev
+	}
+
+	return nil
+}
+
+// Observe records the line as heard at t. Lines that are not JSON or have no
+// model are ignored.
+func (d *Discovered) Observe(line []byte, t time.Time) {
+	if !json.Valid(line) {
+		return
+	}
+	l := discoveredLine{}
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(&l); err != nil || l.Model == "" {
+		return
+	}
+
+	// Numbers are kept as written so ids and channels read the same as the
+	// rtl_433 output.
+	dev := &DiscoveredDevice{Model: l.Model}
+	if l.ID != nil {
+		dev.ID = fmt.Sprint(l.ID)
+	}
+	if l.Channel != nil {
+		dev.Channel = fmt.Sprint(l.Channel)
+	}
+	_, dev.Supported = device.LookupModel(l.Model)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if known, ok := d.devices[dev.key()]; ok {
+		dev = known
+	} else {
+		d.evict()
+		dev.FirstSeen = t
+		d.devices[dev.key()] = dev
+	}
+	dev.Count++
+	dev.LastSeen = t
+	if l.RSSI != nil {
+		dev.RSSI = l.RSSI
+	}
+	dev.Sample = append(json.RawMessage{}, line...)
+	d.dirty = true
+}
+
+// evict forgets the device heard least recently if the inventory is full. The
+// lock must be held.
+func (d *Discovered) evict() {
+	if d.maxDevices <= 0 || len(d.devices) < d.maxDevices {
+		return
+	}
+
+	var oldest *DiscoveredDevice
+	for _, dev := range d.devices {
+		if oldest == nil || dev.LastSeen.Before(oldest.LastSeen) {
+			oldest = dev
+		}
+	}
+	delete(d.devices, oldest.key())
+}
+
+// Devices returns a copy of all discovered devices sorted by model, id and
+// channel. Only unsupported models are returned if unsupportedOnly is true.
+func (d *Discovered) Devices(unsupportedOnly bool) []DiscoveredDevice {
+	devices := make([]DiscoveredDevice, 0)
+	if d == nil {
+		return devices
+	}
+
+	d.lock.Lock()
+	for _, dev := range d.devices {
+		if unsupportedOnly && dev.Supported {
+			continue
+		}
+		devices = append(devices, *dev)
+	}
+	d.lock.Unlock()
+
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].Model != devices[j].Model {
+			return devices[i].Model < devices[j].Model
+		}
+		if devices[i].ID != devices[j].ID {
+			return devices[i].ID < devices[j].ID
+		}
+		return devices[i].Channel < devices[j].Channel
+	})
+
+	return devices
+}
+
+// Dirty returns true if a device has been heard since the last write.
+func (d *Discovered) Dirty() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.dirty
+}
+
+// Save writes all devices to the discovered file.
+func (d *Discovered) Save() error {
+	devices := d.Devices(false)
+	d.lock.Lock()
+	d.dirty = false
+	d.lock.Unlock()
+	if d.readOnly {
+		return nil
+	}
+
+	df := discoveredFile{Version: discoveredVersion, Devices: make([]*DiscoveredDevice, 0, len(devices))}
+	for i := range devices {
+		df.Devices = append(df.Devices, &devices[i])
+	}
+
+	b, err := json.MarshalIndent(df, "", "  ")
+	if err == nil {
+		err = WriteFileAtomic(d.Path(), b)
+	}
+	if err != nil {
+		d.lock.Lock()
+		d.dirty = true
+		d.lock.Unlock()
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package file
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiscovered(t *testing.T) {
+	dir := t.TempDir()
+	d := NewDiscovered(dir, false, 2)
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	d.Observe([]byte(`{"time" : "2026-01-02 03:04:05", "model" : "Doorbell", "id" : 4660, "rssi" : -12.5}`), start)
+	d.Observe([]byte(`{"time" : "2026-01-02 03:04:06", "model" : "Doorbell", "id" : 4660}`), start.Add(time.Second))
+	d.Observe([]byte(`{"time" : "2026-01-02 03:04:07", "model" : "Acurite tower sensor", "id" : 1, "channel" : "A"}`), start.Add(2*time.Second))
+	d.Observe([]byte(`Tuning to 433.920MHz`), start)
+
+	devices := d.Devices(false)
+	if len(devices) != 2 {
+		t.Fatalf("expected 2 devices, got %+v", devices)
+	}
+	tower, bell := devices[0], devices[1]
+	if !tower.Supported || tower.ID != "1" || tower.Channel != "A" {
+		t.Fatalf("unexpected supported device %+v", tower)
+	}
+	if bell.Supported || bell.ID != "4660" || bell.Count != 2 || bell.RSSI == nil || *bell.RSSI != -12.5 {
+		t.Fatalf("unexpected unsupported device %+v", bell)
+	}
+	if !bell.FirstSeen.Equal(start) || !bell.LastSeen.Equal(start.Add(time.Second)) {
+		t.Fatalf("unexpected times %s %s", bell.FirstSeen, bell.LastSeen)
+	}
+	if len(d.Devices(true)) != 1 {
+		t.Fatalf("expected only the unsupported device")
+	}
+
+	// The device heard least recently makes room for a new one.
+	d.Observe([]byte(`{"model" : "TPMS", "id" : "0a1b"}`), start.Add(3*time.Second))
+	for _, dev := range d.Devices(false) {
+		if dev.Model == "Doorbell" {
+			t.Fatalf("least recently heard device was not evicted")
+		}
+	}
+
+	if err := d.Save(); err != nil {
+		t.Fatalf("failed to save: %s", err)
+	}
+	loaded := NewDiscovered(dir, true, 0)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+	devices = loaded.Devices(false)
+	if len(devices) != 2 || devices[1].Model != "TPMS" || devices[1].ID != "0a1b" {
+		t.Fatalf("unexpected loaded devices %+v", devices)
+	}
+}
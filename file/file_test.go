@@ -1,7 +1,20 @@
 package file
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/jrmycanady/slurp-rtl_433/config"
+	"github.com/jrmycanady/slurp-rtl_433/device"
 )
 
 func TestValidateLogFileName(t *testing.T) {
@@ -17,6 +30,19 @@ func TestValidateLogFileName(t *testing.T) {
 		t.Fatalf("goodNameRotated failed")
 	}
 
+	for _, compressed := range []string{"rtl_433_data.log.2.gz", "rtl_433_data.log.1.zst", "rtl_433_data.log.gz"} {
+		if !validateLogFileName(configName, compressed) {
+			t.Fatalf("%s failed", compressed)
+		}
+	}
+
+	if !validateLogFileName("*_data.log", "rtl_433_data.log.3") {
+		t.Fatalf("glob failed")
+	}
+	if validateLogFileName("*_data.log", "rtl_433.log") {
+		t.Fatalf("glob matched other log")
+	}
+
 	badName := "blarg"
 	if validateLogFileName(configName, badName) {
 		t.Fatalf("badName succeeded")
@@ -25,3 +51,182 @@ func TestValidateLogFileName(t *testing.T) {
 	// configNameWithPath := "/path/to/file/rtl_433_data.log"
 
 }
+
+func TestVerifyIdentity(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rtl_433_data.log")
+	write := func(data string) *os.File {
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("failed to write log: %s", err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("failed to open log: %s", err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+
+	l, err := NewLogFile([]byte{})
+	if err != nil {
+		t.Fatalf("failed to create log file: %s", err)
+	}
+	l.LogFilePath = path
+
+	// The fingerprint is built from the start of the file.
+	if err = l.verifyIdentity(write("line one\nline two\n")); err != nil {
+		t.Fatalf("failed to verify: %s", err)
+	}
+	if l.FingerprintSize != 18 || l.Fingerprint == "" {
+		t.Fatalf("unexpected fingerprint %d %s", l.FingerprintSize, l.Fingerprint)
+	}
+	l.Offset = 18
+
+	// Appending keeps the offset.
+	if err = l.verifyIdentity(write("line one\nline two\nline three\n")); err != nil {
+		t.Fatalf("failed to verify: %s", err)
+	}
+	if l.Offset != 18 || l.FingerprintSize != 29 {
+		t.Fatalf("append changed offset to %d and fingerprint size to %d", l.Offset, l.FingerprintSize)
+	}
+
+	// Truncating resets the offset.
+	if err = l.verifyIdentity(write("new\n")); err != nil {
+		t.Fatalf("failed to verify: %s", err)
+	}
+	if l.Offset != 0 || l.FingerprintSize != 4 {
+		t.Fatalf("truncate left offset %d and fingerprint size %d", l.Offset, l.FingerprintSize)
+	}
+
+	// Replacing the contents with a larger file resets the offset.
+	l.Offset = 4
+	if err = l.verifyIdentity(write("different contents\n")); err != nil {
+		t.Fatalf("failed to verify: %s", err)
+	}
+	if l.Offset != 0 {
+		t.Fatalf("replace left offset %d", l.Offset)
+	}
+}
+
+func TestSlurpCompressed(t *testing.T) {
+	dir := t.TempDir()
+	lines := `{"time" : "2018-07-05 01:07:43", "model" : "Ambient Weather F007TH Thermo-Hygrometer", "channel" : 1}
+{"time" : "2018-07-05 01:07:47", "model" : "Ambient Weather F007TH Thermo-Hygrometer", "channel" : 2}
+{"time" : "2018-07-05 01:07:52", "model" : "Ambient Weather F007TH Thermo-Hygrometer", "channel" : 3}
+`
+	// The prior uncompressed file was read up to the second line.
+	prior, _ := NewLogFile([]byte{})
+	prior.LogFilePath = filepath.Join(dir, "rtl_433_data.log.1")
+	prior.Offset = int64(strings.Index(lines, "\n") + 1)
+	prior.FingerprintSize = fingerprintSize
+	sum := sha256.Sum256([]byte(lines[:fingerprintSize]))
+	prior.Fingerprint = hex.EncodeToString(sum[:])
+
+	path := filepath.Join(dir, "rtl_433_data.log.1.gz")
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write([]byte(lines))
+	w.Close()
+	if err := ioutil.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write compressed log: %s", err)
+	}
+
+	f := NewFiler(config.NewConfig(), nil)
+	f.Files[prior.MetaDataID.String()] = prior
+	found, inProgress := f.findPriorIdentity(path)
+	if found != prior || inProgress {
+		t.Fatalf("prior identity not found")
+	}
+
+	l, _ := NewLogFile([]byte{})
+	l.LogFilePath = path
+	l.Offset = prior.Offset
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open compressed log: %s", err)
+	}
+	defer file.Close()
+
+	dataPointChan := make(chan device.DataPoint, 10)
+	l.slurpCompressed(file, dataPointChan)
+	if len(dataPointChan) != 2 {
+		t.Fatalf("expected the remaining 2 readings, got %d", len(dataPointChan))
+	}
+	if !l.Done || l.Offset != int64(len(lines)) {
+		t.Fatalf("expected done at offset %d, got %v at %d", len(lines), l.Done, l.Offset)
+	}
+
+	// An archive without a known prior file is recorded as done unread.
+	if err := os.Rename(path, filepath.Join(dir, "rtl_433_data.log.2.gz")); err != nil {
+		t.Fatalf("failed to rename compressed log: %s", err)
+	}
+	delete(f.Files, prior.MetaDataID.String())
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read dir: %s", err)
+	}
+	f.slurpLogFiles(config.SourceConfig{}, "rtl_433_data.log", dir, infos)
+	if len(f.Files) != 1 {
+		t.Fatalf("expected the archive to be recorded, got %d files", len(f.Files))
+	}
+	for _, archive := range f.Files {
+		if s := archive.Status(); !s.Done || s.Offset != 0 || s.Slurping {
+			t.Errorf("expected the archive done without reading, got %+v", s)
+		}
+	}
+}
+
+// TestSaveWhileSlurping is meant to be run with -race.
+func TestSaveWhileSlurping(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rtl_433_data.log")
+	line := `{"time" : "2018-07-05 01:07:43", "model" : "Ambient Weather F007TH Thermo-Hygrometer", "channel" : 1}` + "\n"
+	if err := ioutil.WriteFile(path, []byte(strings.Repeat(line, 50)), 0644); err != nil {
+		t.Fatalf("failed to write log: %s", err)
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat log: %s", err)
+	}
+
+	s := NewStateStore(dir, false)
+	l, _ := NewLogFile([]byte{})
+	l.LogFilePath = path
+	l.Inode = stat.Sys().(*syscall.Stat_t).Ino
+	l.init(s)
+
+	dataPointChan := make(chan device.DataPoint)
+	go func() {
+		for range dataPointChan {
+		}
+	}()
+	defer close(dataPointChan)
+	go l.slurp(dataPointChan, 1)
+
+	// The state is saved and the status read while the slurper runs, as the
+	// filer and status server do.
+	end := int64(50 * len(line))
+	deadline := time.Now().Add(10 * time.Second)
+	for l.Status().Offset < end && time.Now().Before(deadline) {
+		if err := s.Save([]*LogFile{l}); err != nil {
+			t.Fatalf("failed to save: %s", err)
+		}
+	}
+	l.StopSlurp()
+	if l.Status().Offset != end {
+		t.Fatalf("expected offset %d, got %d", end, l.Status().Offset)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	l, _ := NewLogFile([]byte(`{"inode":42}`))
+	if !l.matches(7, 42) || l.Dev != 7 {
+		t.Fatalf("expected a file without a device to match and take on device 7, got %d", l.Dev)
+	}
+	if l.matches(8, 42) {
+		t.Errorf("expected the same inode on another device not to match")
+	}
+	if l.matches(7, 43) {
+		t.Errorf("expected another inode not to match")
+	}
+}
@@ -4,22 +4,29 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/jrmycanady/slurp-rtl_433/config"
+	"github.com/jrmycanady/slurp-rtl_433/deadletter"
 	"github.com/jrmycanady/slurp-rtl_433/device"
+	"github.com/jrmycanady/slurp-rtl_433/filter"
 	"github.com/jrmycanady/slurp-rtl_433/logger"
 )
 
+// log is the logger for the file package.
+var log = logger.For("file")
+
 const (
 	dot byte = 46
 )
 
 var (
-	logFileRE = regexp.MustCompile(`^(?P<filename>.*.log)(?:\.\d*)?$`)
+	logFileRE = regexp.MustCompile(`^(?P<filename>.*.log)(?:\.\d*)?(?:\.gz|\.zst)?$`)
 )
 
 // A Filer manages finding/identifying log files as well as starting/stopping
@@ -53,10 +60,59 @@ type Filer struct {
 
 	// dropOff
 	dropOffChan chan<- device.DataPoint
+
+	// lock protects Files from concurrent access while the filer is running.
+	lock *sync.Mutex
+
+	// state persists the meta data of all Files.
+	state *StateStore
+
+	// deadLetters records the lines that fail to parse. It's nil if disabled
+	// or during a dry run.
+	deadLetters *deadletter.Writer
+
+	// discovered is the inventory of devices heard. It's nil if disabled.
+	discovered *Discovered
+
+	// filter decides which readings are kept. It's created on start.
+	filter *filter.Filter
+}
+
+// FilerStatus is a snapshot of the current state of a Filer.
+type FilerStatus struct {
+	// Running is true if the filer is currently running.
+	Running bool `json:"running"`
+
+	// Files contains the status of each file known to the filer.
+	Files []LogFileStatus `json:"files"`
+
+	// Filter contains the counts of readings kept and dropped by the filter.
+	Filter filter.Status `json:"filter"`
+}
+
+// Status returns a snapshot of the current state of the Filer and all the
+// LogFiles it knows about.
+func (f *Filer) Status() FilerStatus {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	s := FilerStatus{
+		Running: f.running,
+		Files:   make([]LogFileStatus, 0, len(f.Files)),
+		Filter:  f.filter.Status(),
+	}
+	for i := range f.Files {
+		s.Files = append(s.Files, f.Files[i].Status())
+	}
+	sort.Slice(s.Files, func(i, j int) bool { return s.Files[i].LogFilePath < s.Files[j].LogFilePath })
+
+	return s
 }
 
 // Running returns the current running status of the Filer.
 func (f *Filer) Running() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
 	return f.running
 }
 
@@ -83,21 +139,39 @@ func NewFiler(cfg config.Config, dropOffChan chan<- device.DataPoint) *Filer {
 		CancelChan:  make(chan struct{}),
 		doneChan:    make(chan struct{}, 2),
 		dropOffChan: dropOffChan,
+		lock:        &sync.Mutex{},
+		state:       NewStateStore(cfg.FileMetaDataPath, cfg.DryRun),
+		discovered:  newDiscovered(cfg),
+	}
+}
+
+// newDiscovered creates the inventory of devices heard if enabled in cfg.
+func newDiscovered(cfg config.Config) *Discovered {
+	if !cfg.Discovery.Enabled {
+		return nil
 	}
+	return NewDiscovered(cfg.FileMetaDataPath, cfg.DryRun, cfg.Discovery.MaxDevices)
+}
+
+// Discovered returns the inventory of devices heard. It's nil if discovery
+// is disabled.
+func (f *Filer) Discovered() *Discovered {
+	return f.discovered
 }
 
-// findLogFileByInode searches all known LogFiles for the inode provided.
-func (f *Filer) findLogFileByInode(inode uint64) *LogFile {
+// findLogFile searches all known LogFiles for the device and inode provided.
+func (f *Filer) findLogFile(dev uint64, inode uint64) *LogFile {
+	f.lock.Lock()
+	defer f.lock.Unlock()
 
-	logger.Debug.Println("searching for file by inode")
+	log.Debug.Println("searching for file by device and inode")
 	for i := range f.Files {
-		logger.Debug.Printf("%d =?= %d", f.Files[i].Inode, inode)
-		if f.Files[i].Inode == inode {
-			logger.Debug.Printf("found known file with inode %d", inode)
+		if f.Files[i].matches(dev, inode) {
+			log.Debug.Printf("found known file with device %d and inode %d", dev, inode)
 			return f.Files[i]
 		}
 	}
-	logger.Debug.Printf("did not find known file with inode %d", inode)
+	log.Debug.Printf("did not find known file with device %d and inode %d", dev, inode)
 	return nil
 }
 
@@ -107,7 +181,7 @@ func (f *Filer) findLogFileByInode(inode uint64) *LogFile {
 func (f *Filer) Start() error {
 	var err error
 
-	logger.Info.Println("starting filer")
+	log.Info.Println("starting filer")
 
 	// Doing nothing if not configured.
 	if !f.configured {
@@ -115,18 +189,67 @@ func (f *Filer) Start() error {
 
 	}
 
+	if f.filter, err = filter.New(f.cfg.Filter); err != nil {
+		log.Error.Printf("failed to build filter: %s", err)
+		f.shutdown()
+		return fmt.Errorf("failed to start filer: %s", err)
+	}
+	for _, d := range f.filter.Describe() {
+		log.Info.Printf("filter rule %s", d)
+	}
+
+	// Opening the dead letter file before any slurping starts.
+	if f.cfg.DeadLetter.Enabled && !f.cfg.DryRun {
+		maxBytes := int64(f.cfg.DeadLetter.MaxSizeMB * 1024 * 1024)
+		if f.deadLetters, err = deadletter.Open(f.cfg.DeadLetterPath(), maxBytes, f.cfg.DeadLetter.MaxBackups); err != nil {
+			log.Error.Printf("failed to open dead letter file: %s", err)
+			f.shutdown()
+			return fmt.Errorf("failed to start filer: %s", err)
+		}
+	}
+
 	// Loading known log files from the meta data.
-	if err = f.loadLogMetaData(); err != nil {
-		logger.Error.Printf("failed to load log meta data files: %s", err)
+	if err = f.loadState(); err != nil {
+		log.Error.Printf("failed to load log meta data files: %s", err)
+		if f.deadLetters != nil {
+			f.deadLetters.Close()
+		}
 		f.shutdown()
 		return fmt.Errorf("failed to start filer: %s", err)
 	}
 
-	logger.Info.Printf("filer found %d log meta data files", len(f.Files))
+	log.Info.Printf("filer found %d log meta data files", len(f.Files))
+
+	// A lost inventory is rebuilt as devices are heard so it does not stop
+	// the filer.
+	if f.discovered != nil {
+		if err = f.discovered.Load(); err != nil {
+			log.Error.Printf("failed to load discovered devices: %s", err)
+		}
+	}
 
 	// Starting process loop.
 	go f.run()
+	f.lock.Lock()
 	f.running = true
+	f.lock.Unlock()
+
+	return nil
+}
+
+// Reload applies the settings in cfg that can change while running. If the
+// filter rules are invalid the current rules are kept and an error is
+// returned.
+func (f *Filer) Reload(cfg config.Config) error {
+	if f.filter == nil {
+		return nil
+	}
+	if err := f.filter.Update(cfg.Filter); err != nil {
+		return fmt.Errorf("failed to update filter: %s", err)
+	}
+	for _, d := range f.filter.Describe() {
+		log.Info.Printf("filter rule %s", d)
+	}
 
 	return nil
 }
@@ -141,14 +264,14 @@ func (f *Filer) Stop() {
 	start := time.Now()
 	for {
 		// No longer running to exit.
-		if f.running == false {
+		if !f.Running() {
 			return
 		}
 
 		time.Sleep(time.Duration(1) * time.Second)
 
 		if time.Since(start).Seconds() > float64(f.cfg.FilerShutdownMaxWaitSeconds) {
-			logger.Error.Printf("exceeded FilerShutdownMaxWaitTimeSeconds of %d, forcing shutdown now", f.cfg.FilerShutdownMaxWaitSeconds)
+			log.Error.Printf("exceeded FilerShutdownMaxWaitTimeSeconds of %d, forcing shutdown now", f.cfg.FilerShutdownMaxWaitSeconds)
 			return
 		}
 	}
@@ -162,24 +285,44 @@ func (f *Filer) run() {
 	// Generating the ticker to for checks for new files.
 	findTimer := time.NewTicker(time.Duration(f.cfg.LogFileCheckTimeSeconds) * time.Second)
 
+	// Generating the ticker to write any changes to the state.
+	stateTimer := time.NewTicker(time.Duration(f.cfg.StateSaveSeconds) * time.Second)
+
 	for {
 		select {
 		case <-findTimer.C:
 			if err = f.findAndSlurpLogFiles(); err != nil {
-				logger.Error.Printf("failed to find any log files: %s", err)
+				log.Error.Printf("failed to find any log files: %s", err)
 			} else {
-				logger.Info.Println("log file search complete")
+				log.Info.Println("log file search complete")
+			}
+			f.collectGarbage()
+		case <-stateTimer.C:
+			if f.state.Dirty() {
+				f.saveState()
+			}
+			if f.discovered != nil && f.discovered.Dirty() {
+				f.saveDiscovered()
 			}
 		case <-f.CancelChan:
-			logger.Info.Println("cancel received, stopping all file slurpers")
+			log.Info.Println("cancel received, stopping all file slurpers")
 
 			for i := range f.Files {
-				logger.Verbose.Printf("stopping slurper for %s", f.Files[i].LogFilePath)
+				log.Verbose.Printf("stopping slurper for %s", f.Files[i].LogFilePath)
 				f.Files[i].StopSlurp()
-				logger.Debug.Printf("stopping of slurper for %s compelte", f.Files[i].LogFilePath)
+				log.Debug.Printf("stopping of slurper for %s compelte", f.Files[i].LogFilePath)
+			}
+			f.saveState()
+			if f.discovered != nil {
+				f.saveDiscovered()
+			}
+			if f.deadLetters != nil {
+				if err = f.deadLetters.Close(); err != nil {
+					log.Error.Printf("failed to close dead letter file: %s", err)
+				}
 			}
 			f.shutdown()
-			logger.Info.Println("filer has stopped")
+			log.Info.Println("filer has stopped")
 			return
 		}
 	}
@@ -189,110 +332,172 @@ func (f *Filer) run() {
 // stop. Generally Stop() should be used.
 func (f *Filer) shutdown() {
 	f.doneChan <- struct{}{}
+	f.lock.Lock()
 	f.running = false
+	f.lock.Unlock()
 	return
 }
 
-func (f *Filer) loadLogMetaData() error {
+// loadState loads all known log files from the state store.
+func (f *Filer) loadState() error {
 	if !f.configured {
 		panic(fmt.Errorf("cannot start filer that is not configured"))
 	}
 
-	// Opening the metadata directory and loading all metadata files.
-	files, err := ioutil.ReadDir(f.cfg.FileMetaDataPath)
+	files, err := f.state.Load()
 	if err != nil {
 		return err
 	}
 
-	for i := range files {
-
-		// Ignoring all directories and any files with a length too short.
-		if files[i].IsDir() || len(files[i].Name()) != 41 {
-			logger.Info.Printf("metadata load ignoring directory or file with too short of name %s", files[i].Name())
-			continue
+	// Files migrated from older versions have never been seen so they are
+	// given the full retention period to show up.
+	now := time.Now()
+	f.lock.Lock()
+	for _, l := range files {
+		if l.LastSeen.IsZero() {
+			l.LastSeen = now
 		}
+		l.deadLetters = f.deadLetters
+		l.discovered = f.discovered
+		l.filter = f.filter
+		f.Files[l.MetaDataID.String()] = l
+	}
+	f.lock.Unlock()
 
-		// Parsing the uuid so we can build the file.
-		id, err := uuid.Parse(files[i].Name()[:36])
-		if err != nil {
-			logger.Info.Printf("meta data file name did not parse into uuid correctly: %s", files[i].Name())
-			continue
-		}
+	return nil
+}
 
-		// Read all the data in the file.
-		file, err := ioutil.ReadFile(f.cfg.FileMetaDataPath + "/" + id.String() + ".meta")
-		if err != nil {
-			continue
-		}
+// saveState writes the state of all known log files to the state store.
+func (f *Filer) saveState() {
+	f.lock.Lock()
+	files := make([]*LogFile, 0, len(f.Files))
+	for i := range f.Files {
+		files = append(files, f.Files[i])
+	}
+	f.lock.Unlock()
 
-		// Load the metadate file.
-		lf, err := NewLogFile(file)
-		if err != nil {
-			logger.Info.Printf("failed to load meta data from %s", files[i].Name())
+	if err := f.state.Save(files); err != nil {
+		log.Error.Printf("failed to save state to %s: %s", f.state.Path(), err)
+	}
+}
+
+// saveDiscovered writes the inventory of devices heard.
+func (f *Filer) saveDiscovered() {
+	if err := f.discovered.Save(); err != nil {
+		log.Error.Printf("failed to save discovered devices to %s: %s", f.discovered.Path(), err)
+	}
+}
+
+// collectGarbage forgets any log files that have not been found for longer
+// than StateRetentionSeconds and are not being slurped.
+func (f *Filer) collectGarbage() {
+	retention := time.Duration(f.cfg.StateRetentionSeconds) * time.Second
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for id, l := range f.Files {
+		if l.SlurpRunning() || time.Since(l.lastSeen()) < retention {
 			continue
 		}
+		l.log().Info.With("lastSeen", l.lastSeen()).Println("forgetting log file that no longer exists")
+		delete(f.Files, id)
+		f.state.MarkDirty()
+	}
+}
 
-		f.Files[id.String()] = lf
+// findAndSlurpLogFiles searches the locations of all enabled sources for log
+// files and begins the slurp process on any that need to be processed. It's
+// assumed that the Slurp method of the LogFile is smart enough to handle
+// multiple calls and will not double slurp or slurp an old file.
+func (f *Filer) findAndSlurpLogFiles() error {
+	log.Verbose.Printf("starting find for new log files for slurping")
 
+	failed := 0
+	sources := f.cfg.DataSources()
+	for _, source := range sources {
+		if !source.IsEnabled() {
+			log.Verbose.With("source", source.Name).Println("source is disabled, ignoring")
+			continue
+		}
+		if err := f.findAndSlurpSource(source); err != nil {
+			log.Error.With("source", source.Name).Printf("failed to search source: %s", err)
+			failed++
+		}
+	}
+	if failed == len(sources) {
+		return fmt.Errorf("all %d sources failed", failed)
 	}
 
 	return nil
 }
 
-// findAndSlurpLogFiles searches the log file directory for log files and
-// begins the slurp process on any that need to be processed. It's assumed that
-// the Slurp method of the LogFile is smart enough to handle multiple calls
-// and will not double slurp or slurp an old file.
-func (f *Filer) findAndSlurpLogFiles() error {
-	var err error
-	logger.Verbose.Printf("starting find for new log files for slurping")
+// findAndSlurpSource searches the location of source for log files and
+// begins the slurp process on any that need to be processed.
+func (f *Filer) findAndSlurpSource(source config.SourceConfig) error {
+	dirPattern, namePattern := filepath.Split(source.Location)
+	if dirPattern == "" {
+		dirPattern = "."
+	}
+	if namePattern == "" {
+		return fmt.Errorf("no file name in location %s", source.Location)
+	}
 
-	// Opening directory to get list of all possible files for slurping.
-	files := make([]os.FileInfo, 0, 0)
-	if f.cfg.DataFileDir != "" {
-		files, err = ioutil.ReadDir(f.cfg.DataFileDir)
-		if err != nil {
-			return fmt.Errorf("failed to read directory at %s: %s", f.cfg.DataFileDir, err)
-		}
-	} else {
-		// No directory was in the config so it must be a single file. If no
-		// file is found it's not an error as it could show up later.
-		file, err := os.Stat(f.cfg.DataFileDir)
+	// The directory may be a glob matching many directories. If none exist
+	// it's not an error as they could show up later.
+	dirs, err := filepath.Glob(filepath.Clean(dirPattern))
+	if err != nil {
+		return fmt.Errorf("invalid location %s: %s", source.Location, err)
+	}
+	if len(dirs) == 0 {
+		log.Verbose.With("source", source.Name).Printf("did not find directory %s", dirPattern)
+		return nil
+	}
+
+	for _, dir := range dirs {
+		files, err := ioutil.ReadDir(dir)
 		if err != nil {
-			logger.Verbose.Printf("did not find log file named %s", f.cfg.DataFileDir)
-			return nil
+			return fmt.Errorf("failed to read directory at %s: %s", dir, err)
 		}
-		files = append(files, file)
+		f.slurpLogFiles(source, namePattern, dir, files)
 	}
 
+	return nil
+}
+
+// slurpLogFiles begins the slurp process on any of the files found in dir
+// that match the namePattern of the source.
+func (f *Filer) slurpLogFiles(source config.SourceConfig, namePattern string, dir string, files []os.FileInfo) {
 	// Checking each file to see if it's a log file.
 	for i := range files {
-		logger.Verbose.Printf("checking (file | dir) %s", files[i].Name())
+		log.Verbose.Printf("checking (file | dir) %s", files[i].Name())
 
 		// Validating the name matches the expected file name.
-		if !validateLogFileName(f.cfg.DataFileName, files[i].Name()) {
-			logger.Verbose.Printf("%s name does not match expected format %s", files[i].Name(), f.cfg.DataFileName)
+		if !validateLogFileName(namePattern, files[i].Name()) {
+			log.Verbose.Printf("%s name does not match expected format %s", files[i].Name(), namePattern)
 			continue
 		}
 
 		if files[i].IsDir() {
 			// Ignoring any directories.
-			logger.Verbose.Printf("%s is a directory, ignoring", files[i].Name())
+			log.Verbose.Printf("%s is a directory, ignoring", files[i].Name())
 			continue
 		}
 
 		stat, ok := files[i].Sys().(*syscall.Stat_t)
 		if !ok {
 			// Ignoring any files we can't stat.
-			logger.Verbose.Printf("failed to stat %s, ignoring", files[i].Name())
+			log.Verbose.Printf("failed to stat %s, ignoring", files[i].Name())
 			continue
 		}
 
 		// Finding if we already have the file and processing accordinly.
-		foundFile := f.findLogFileByInode(stat.Ino)
+		foundFile := f.findLogFile(uint64(stat.Dev), stat.Ino)
 		if foundFile != nil {
-			logger.Verbose.Printf("file %s already known to filer, updating to found", files[i].Name())
+			log.Verbose.Printf("file %s already known to filer, updating to found", files[i].Name())
 			foundFile.SetFound(true)
+			foundFile.SetLogFilePath(filepath.Join(dir, files[i].Name()))
+			foundFile.setSource(source.Name, source.Tags)
+			foundFile.setLastSeen(time.Now())
 			foundFile.StartSlurp(f.dropOffChan, f.cfg.SlurpSleepTimeSeconds, f.cfg.SlurperShutdownMaxWaitSeconds)
 			continue
 		}
@@ -300,43 +505,81 @@ func (f *Filer) findAndSlurpLogFiles() error {
 		// Building new file and adding to the list.
 		newFile, err := NewLogFile([]byte{})
 		if err != nil {
-			logger.Error.Printf("failed to load file %s: %s", files[i].Name(), err)
+			log.Error.Printf("failed to load file %s: %s", files[i].Name(), err)
 			continue
 		}
 
-		newFile.LogFilePath = fmt.Sprintf("%s/%s", f.cfg.DataFileDir, files[i].Name())
+		newFile.LogFilePath = filepath.Join(dir, files[i].Name())
 		newFile.Offset = 0
 		newFile.Inode = stat.Ino
+		newFile.Dev = uint64(stat.Dev)
 		newFile.found = true
-		newFile.MetaDataFilePath = fmt.Sprintf("%s/%s.meta", f.cfg.FileMetaDataPath, newFile.MetaDataID)
-
-		logger.Info.Printf("found new file %s with inode %d", files[i].Name(), stat.Ino)
-		if err = newFile.Save(); err != nil {
-			logger.Info.Printf("failed to save file meta data to %s, not slurping", newFile.MetaDataFilePath)
-			continue
+		newFile.LastSeen = time.Now()
+		newFile.init(f.state)
+		newFile.deadLetters = f.deadLetters
+		newFile.discovered = f.discovered
+		newFile.filter = f.filter
+		newFile.setSource(source.Name, source.Tags)
+
+		// A compressed rotation carries on from the offset of the file it was
+		// compressed from so any unread remainder is not lost. One without a
+		// known prior file, such as an archive present before the first run,
+		// was read before or never will be, so it is recorded as done rather
+		// than sending its readings again. replay reads such archives.
+		if Compressed(newFile.LogFilePath) {
+			prior, inProgress := f.findPriorIdentity(newFile.LogFilePath)
+			if inProgress {
+				log.Verbose.With("file", newFile.LogFilePath).Println("compression still in progress, waiting")
+				continue
+			}
+			if prior != nil {
+				f.retire(prior)
+				ps := prior.snapshot()
+				newFile.Offset = ps.Offset
+				newFile.Fingerprint = ps.Fingerprint
+				newFile.FingerprintSize = ps.FingerprintSize
+				log.Info.With("file", newFile.LogFilePath, "prior", ps.LogFilePath, "offset", ps.Offset).Println("compressed file continues from prior file")
+			} else {
+				newFile.Done = true
+				log.Info.With("file", newFile.LogFilePath).Println("compressed file has no known prior file, skipping")
+			}
 		}
+
+		log.Info.With("file", newFile.LogFilePath, "inode", stat.Ino).Println("found new file")
+		newFile.Save()
+		f.lock.Lock()
 		f.Files[newFile.MetaDataID.String()] = newFile
+		f.lock.Unlock()
 		newFile.StartSlurp(f.dropOffChan, f.cfg.SlurpSleepTimeSeconds, f.cfg.SlurperShutdownMaxWaitSeconds)
 	}
-	return nil
+}
+
+// retire stops slurping the LogFile and forgets it along with its meta data.
+func (f *Filer) retire(l *LogFile) {
+	l.StopSlurp()
 
+	f.lock.Lock()
+	delete(f.Files, l.MetaDataID.String())
+	f.lock.Unlock()
+	f.state.MarkDirty()
 }
 
 // validateLogFileName validates the found log file name matches the expected
-// name taking into account logrotate number indicators. It returns true if
-// the name is valid otherwise it will return false. It will also return false
-// if the expected name does not end with .log.
+// name taking into account logrotate number indicators and compression
+// extensions. The expected name may be a glob pattern. It returns true if the
+// name is valid otherwise it will return false. It will also return false if
+// the found name does not end with .log before any rotation indicators.
 func validateLogFileName(expected string, found string) bool {
 
 	// validating format and pulling out name
 	results := logFileRE.FindSubmatch([]byte(found))
 	if len(results) != 2 {
-		fmt.Println("not long enough")
+		log.Debug.With("file", found).Println("file name does not match log file format")
 		return false
 	}
 
-	if expected != string(results[1]) {
-		fmt.Printf("%s, %s, does not match", results[1], expected)
+	if ok, _ := filepath.Match(expected, string(results[1])); !ok {
+		log.Debug.With("file", found, "expected", expected).Println("file name does not match expected name")
 		return false
 	}
 
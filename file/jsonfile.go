@@ -0,0 +1,105 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A JSONFile keeps the state of a tracker, such as the rain counters, in a
+// JSON file in the meta data directory. Like the StateStore, changes are only
+// marked dirty and written in batches, replacing the file atomically.
+type JSONFile struct {
+	// dir is the meta data directory holding the file. The state is kept in
+	// memory only if empty.
+	dir string
+
+	// name is the name of the file within dir.
+	name string
+
+	// readOnly is true if the file should never be written, such as during
+	// a dry run.
+	readOnly bool
+
+	// lock protects dirty.
+	lock *sync.Mutex
+
+	// dirty is true if the state has changed since the last write.
+	dirty bool
+}
+
+// NewJSONFile creates a JSONFile named name in dir. Nothing is written if
+// readOnly is true or dir is empty.
+func NewJSONFile(dir string, name string, readOnly bool) *JSONFile {
+	return &JSONFile{
+		dir:      dir,
+		name:     name,
+		readOnly: readOnly || dir == "",
+		lock:     &sync.Mutex{},
+	}
+}
+
+// Path returns the path of the file.
+func (j *JSONFile) Path() string {
+	return filepath.Join(j.dir, j.name)
+}
+
+// Load decodes the file into v. False is returned without an error if the
+// file is missing or the state is kept in memory only.
+func (j *JSONFile) Load(v interface{}) (bool, error) {
+	if j.dir == "" {
+		return false, nil
+	}
+	b, err := ioutil.ReadFile(j.Path())
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %s", j.name, err)
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		return false, fmt.Errorf("failed to parse %s: %s", j.Path(), err)
+	}
+
+	return true, nil
+}
+
+// MarkDirty records that the state has changed and must be written.
+func (j *JSONFile) MarkDirty() {
+	j.lock.Lock()
+	j.dirty = true
+	j.lock.Unlock()
+}
+
+// Dirty returns true if the state has changed since the last write.
+func (j *JSONFile) Dirty() bool {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.dirty
+}
+
+// Save writes the state returned by snapshot to the file. The dirty flag is
+// cleared before snapshot is called so changes made while writing are kept
+// for the next write.
+func (j *JSONFile) Save(snapshot func() interface{}) error {
+	j.lock.Lock()
+	j.dirty = false
+	j.lock.Unlock()
+	if j.readOnly {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(snapshot(), "", "  ")
+	if err == nil {
+		err = WriteFileAtomic(j.Path(), b)
+	}
+	if err != nil {
+		j.MarkDirty()
+		return err
+	}
+
+	return nil
+}
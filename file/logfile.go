@@ -1,17 +1,20 @@
 package file
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jrmycanady/slurp-rtl_433/deadletter"
 	"github.com/jrmycanady/slurp-rtl_433/device"
+	"github.com/jrmycanady/slurp-rtl_433/filter"
 	"github.com/jrmycanady/slurp-rtl_433/logger"
 )
 
@@ -21,6 +24,10 @@ const (
 
 	// The byte value for a carriage return.
 	cr byte = 13
+
+	// fingerprintSize is the maximum number of bytes from the start of a log
+	// file used to build its fingerprint.
+	fingerprintSize = 256
 )
 
 // A LogFile represents a rtl_433 json output file. Various actions can be
@@ -29,18 +36,42 @@ type LogFile struct {
 	// Inode is the inode of the file system for the log file itself.
 	Inode uint64 `json:"inode"`
 
+	// Dev is the device of the file system holding the log file. An inode is
+	// only unique within a device so both identify the file. It is 0 in meta
+	// data saved before it was recorded.
+	Dev uint64 `json:"dev"`
+
 	// Offset is the last read location that was successfully processed.
 	Offset int64 `json:"offset"`
 
-	// MetaDataID is the id of the meta data file.
+	// MetaDataID is the id of the meta data.
 	MetaDataID uuid.UUID `json:"metaDataId"`
 
-	// MetaDataFilePath is the full file path of the MetData file.
-	MetaDataFilePath string `json:"metaDataFilePath"`
-
 	// LogFilePath is the last known path to the log file.
 	LogFilePath string `json:"logFilePath"`
 
+	// Fingerprint is the hex encoded SHA-256 of the first FingerprintSize
+	// bytes of the log file. It identifies the file if the inode is reused or
+	// the file is truncated.
+	Fingerprint string `json:"fingerprint"`
+
+	// FingerprintSize is the number of bytes used to build the Fingerprint.
+	// It grows with the file up to fingerprintSize.
+	FingerprintSize int64 `json:"fingerprintSize"`
+
+	// Done is true once a compressed log file has been read to the end.
+	// Compressed files never change so it is not read again.
+	Done bool `json:"done"`
+
+	// LastSeen is the last time the filer found the log file.
+	LastSeen time.Time `json:"lastSeen"`
+
+	// Source is the name of the source the log file was found by.
+	Source string `json:"source"`
+
+	// tags are the tags of the source added to every point.
+	tags map[string]string
+
 	lock *sync.Mutex
 
 	// slurpCancelChan provides a channel that can be closed to tell the slurp
@@ -56,11 +87,120 @@ type LogFile struct {
 
 	// SlurperShutdownMaxWaitSeconds is the maximum seconds a slurper will wait to shutdown.
 	SlurperShutdownMaxWaitSeconds float64
+
+	// store is the StateStore the meta data is saved to.
+	store *StateStore
+
+	// deadLetters records the lines that fail to parse. Nothing is recorded
+	// if nil.
+	deadLetters *deadletter.Writer
+
+	// discovered records the devices heard. Nothing is recorded if nil.
+	discovered *Discovered
+
+	// filter decides which readings are kept. Everything is kept if nil.
+	filter *filter.Filter
+}
+
+// LogFileStatus is a snapshot of the current state of a LogFile.
+type LogFileStatus struct {
+	// LogFilePath is the last known path to the log file.
+	LogFilePath string `json:"logFilePath"`
+
+	// Source is the name of the source the log file was found by.
+	Source string `json:"source"`
+
+	// Inode is the inode of the log file.
+	Inode uint64 `json:"inode"`
+
+	// Offset is the last read location that was successfully processed.
+	Offset int64 `json:"offset"`
+
+	// Found is true if the filer has found the file.
+	Found bool `json:"found"`
+
+	// Slurping is true if a slurp is currently running on the file.
+	Slurping bool `json:"slurping"`
+
+	// Done is true once a compressed log file has been read to the end.
+	Done bool `json:"done"`
+}
+
+// Status returns a snapshot of the current state of the LogFile.
+func (l *LogFile) Status() LogFileStatus {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return LogFileStatus{
+		LogFilePath: l.LogFilePath,
+		Source:      l.Source,
+		Inode:       l.Inode,
+		Offset:      l.Offset,
+		Found:       l.found,
+		Slurping:    l.slurpRunning,
+		Done:        l.Done,
+	}
+}
+
+// matches returns true if the file on device dev with the inode ino is the
+// log file. A log file without a device, as loaded from older meta data,
+// matches the inode on any device and takes on dev.
+func (l *LogFile) matches(dev uint64, ino uint64) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.Inode != ino || (l.Dev != 0 && l.Dev != dev) {
+		return false
+	}
+	l.Dev = dev
+	return true
+}
+
+// log returns a logger with the file and inode fields set for this LogFile.
+func (l *LogFile) log() *logger.Component {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return log.With("file", l.LogFilePath, "inode", l.Inode)
+}
+
+// path returns the last known path of the log file.
+func (l *LogFile) path() string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.LogFilePath
+}
+
+// offset returns the last read location that was successfully processed.
+func (l *LogFile) offset() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.Offset
+}
+
+// advance moves the offset on by n bytes once they have been processed.
+func (l *LogFile) advance(n int64) {
+	l.lock.Lock()
+	l.Offset += n
+	l.lock.Unlock()
+}
+
+// done returns true once a compressed log file has been read to the end.
+func (l *LogFile) done() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.Done
+}
+
+// setDone records that a compressed log file has been read to the end.
+func (l *LogFile) setDone() {
+	l.lock.Lock()
+	l.Done = true
+	l.lock.Unlock()
 }
 
 // Found returns that found status of the LogFile. True if the LogFile has
 // beenf found.
 func (l *LogFile) Found() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
 	return l.found
 }
 
@@ -71,6 +211,14 @@ func (l *LogFile) SetFound(f bool) {
 	l.lock.Unlock()
 }
 
+// SetLogFilePath updates the last known path of the log file, such as after
+// it has been rotated.
+func (l *LogFile) SetLogFilePath(path string) {
+	l.lock.Lock()
+	l.LogFilePath = path
+	l.lock.Unlock()
+}
+
 // NewLogFile creates a new log file. Optionally a marshalled json string
 // of the meta data can be provided which will be the source of the
 // configuration. If it's empty a new LogFile will be generated.
@@ -96,19 +244,65 @@ func NewLogFile(metaDataJSON []byte) (*LogFile, error) {
 	return &l, nil
 }
 
-// Save saves the log file meta data.
-func (l *LogFile) Save() error {
+// Save records that the log file meta data has changed. The meta data is
+// written by the StateStore with the next batch.
+func (l *LogFile) Save() {
+	if l.store != nil {
+		l.store.MarkDirty()
+	}
+}
+
+// init sets the StateStore the meta data is saved to.
+func (l *LogFile) init(s *StateStore) {
+	l.store = s
+}
+
+// snapshot returns a copy of the LogFile for saving.
+func (l *LogFile) snapshot() *LogFile {
 	l.lock.Lock()
 	defer l.lock.Unlock()
-	j, err := json.Marshal(l)
-	if err != nil {
-		return err
+	c := *l
+	return &c
+}
+
+// setSource sets the source the log file was found by.
+func (l *LogFile) setSource(name string, tags map[string]string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.Source != name {
+		l.Source = name
+		if l.store != nil {
+			l.store.MarkDirty()
+		}
 	}
-	return ioutil.WriteFile(l.MetaDataFilePath, j, 0644)
+	l.tags = tags
+}
+
+// source returns the name and tags of the source the log file was found by.
+func (l *LogFile) source() (string, map[string]string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.Source, l.tags
+}
+
+// setLastSeen records the time the filer last found the log file.
+func (l *LogFile) setLastSeen(t time.Time) {
+	l.lock.Lock()
+	l.LastSeen = t
+	l.lock.Unlock()
+}
+
+// lastSeen returns the time the filer last found the log file.
+func (l *LogFile) lastSeen() time.Time {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.LastSeen
 }
 
 // SlurpRunning returns true if a slurp is currently running on the file.
 func (l *LogFile) SlurpRunning() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
 	return l.slurpRunning
 }
 
@@ -130,6 +324,7 @@ func (l *LogFile) setSlurpRunning(state bool) {
 func (l *LogFile) slurp(dataPointChan chan<- device.DataPoint, sleepTimeSeconds int) {
 	l.setSlurpRunning(true)
 	defer l.setSlurpRunning(false)
+	flog := l.log()
 
 	// Preventing crazy low sleep time.
 	if sleepTimeSeconds < 1 {
@@ -137,10 +332,11 @@ func (l *LogFile) slurp(dataPointChan chan<- device.DataPoint, sleepTimeSeconds
 	}
 
 	// Opening the file for processing.
-	f, err := os.Open(l.LogFilePath)
+	path := l.path()
+	f, err := os.Open(path)
 	if err != nil {
-		logger.Error.Printf("failed to open file %s", l.LogFilePath)
-		logger.Debug.Printf("failed to open file %s: %s", l.LogFilePath, err)
+		flog.Error.Println("failed to open file")
+		flog.Debug.With("error", err).Println("failed to open file")
 		return
 	}
 	defer f.Close()
@@ -148,16 +344,26 @@ func (l *LogFile) slurp(dataPointChan chan<- device.DataPoint, sleepTimeSeconds
 	// Validating it's still the same file.
 	stat, err := f.Stat()
 	if err != nil {
-		logger.Error.Printf("failed to stat file %s", l.LogFilePath)
+		flog.Error.With("error", err).Println("failed to stat file")
 		return
 	}
 	sys := stat.Sys().(*syscall.Stat_t)
-	if sys.Ino != l.Inode {
-		logger.Error.Printf("the inode has changed so the file is new %s", l.LogFilePath)
+	if !l.matches(uint64(sys.Dev), sys.Ino) {
+		flog.Error.With("foundInode", sys.Ino).Println("the inode has changed so the file is new")
 		return
 	}
 
-	logger.Info.Printf("opened and starting slurping of %s", l.LogFilePath)
+	flog.Info.Println("opened and starting slurping of file")
+
+	// Compressed rotations are read once through a decompressing reader.
+	if Compressed(path) {
+		l.slurpCompressed(f, dataPointChan)
+		return
+	}
+	if err = l.verifyIdentity(f); err != nil {
+		flog.Error.Printf("failed to verify file identity: %s", err)
+		return
+	}
 
 	// Processing the file until slurpCancelChan is closed.
 	for {
@@ -168,31 +374,39 @@ func (l *LogFile) slurp(dataPointChan chan<- device.DataPoint, sleepTimeSeconds
 		// Check to see if we should stop.
 		select {
 		case <-l.slurpCancelChan:
-			logger.Verbose.Printf("stop for slurper on %s received", l.LogFilePath)
+			flog.Verbose.Println("stop for slurper received")
 			return
 		default:
 		}
 
+		// Starting over if the file has been truncated or replaced since the
+		// last pass.
+		if err = l.verifyIdentity(f); err != nil {
+			flog.Error.Printf("failed to verify file identity: %s", err)
+			return
+		}
+
 		// Seeking to the last location not recorded.
-		_, err = f.Seek(l.Offset, 0)
+		offset := l.offset()
+		_, err = f.Seek(offset, 0)
 		if err != nil {
-			logger.Error.Printf("failed to seek to file %s", l.LogFilePath)
-			logger.Debug.Printf("failed to seek to file %s: %s", l.LogFilePath, err)
+			flog.Error.Println("failed to seek in file")
+			flog.Debug.With("error", err).Println("failed to seek in file")
 			return
 		}
-		logger.Debug.Printf("seeking to %d complete on %s", l.Offset, l.LogFilePath)
+		flog.Debug.With("offset", offset).Println("seeking complete")
 		if err == io.EOF {
-			logger.Debug.Println("already at end of file")
+			flog.Debug.Println("already at end of file")
 		}
 
 		// Reading until we reach the end of the file.
 		for err != io.EOF {
-			logger.Debug.Printf("starting read of file")
+			flog.Debug.Printf("starting read of file")
 
 			// Check to see if we should stop.
 			select {
 			case <-l.slurpCancelChan:
-				logger.Verbose.Printf("stop for slurper on %s received", l.LogFilePath)
+				flog.Verbose.Println("stop for slurper received")
 				return
 			default:
 			}
@@ -200,7 +414,7 @@ func (l *LogFile) slurp(dataPointChan chan<- device.DataPoint, sleepTimeSeconds
 			// Reading up to the buffer length.
 			n, err = f.Read(buff)
 
-			logger.Debug.Printf("read in %d => %s", n, buff)
+			flog.Debug.Printf("read in %d => %s", n, buff)
 
 			// Check each character in the buffer for line feed \n or carriage return \r.
 			// Finding it means the line has ended and we should save it off. Then continue on.
@@ -215,8 +429,8 @@ func (l *LogFile) slurp(dataPointChan chan<- device.DataPoint, sleepTimeSeconds
 					// Add anything before the \r to the line and saving it.
 					line = append(line, buff[startIndex:i]...)
 					if len(line) > 0 {
-						if err := savePoint(line, dataPointChan); err != nil {
-							logger.Error.Printf("failed to save data point: %s", err)
+						if err := l.savePoint(line, dataPointChan); err != nil {
+							flog.Error.With("offset", l.offset()).Printf("failed to save data point: %s", err)
 						}
 						l.Save()
 					}
@@ -239,10 +453,10 @@ func (l *LogFile) slurp(dataPointChan chan<- device.DataPoint, sleepTimeSeconds
 					line = append(line, buff[startIndex:i]...)
 					// Saving the line if not empty.
 					if len(line) > 0 {
-						if err := savePoint(line, dataPointChan); err != nil {
-							logger.Error.Printf("failed to save data point: %s", err)
+						if err := l.savePoint(line, dataPointChan); err != nil {
+							flog.Error.With("offset", l.offset()).Printf("failed to save data point: %s", err)
 						}
-						l.Offset += int64(len(line)) + 1 // Adding 1 for \n
+						l.advance(int64(len(line)) + 1) // Adding 1 for \n
 						l.Save()
 					}
 
@@ -263,29 +477,142 @@ func (l *LogFile) slurp(dataPointChan chan<- device.DataPoint, sleepTimeSeconds
 	}
 }
 
-// savePoint builds a new datapoint from line and sends it to the dataPointChan.
-func savePoint(line []byte, dataPointChan chan<- device.DataPoint) error {
+// verifyIdentity checks the open file f is still the file described by the
+// LogFile. If the file is smaller than the offset or fingerprint it has been
+// truncated, such as by logrotate copytruncate. If the fingerprint no longer
+// matches the contents have been replaced or the inode has been reused. In
+// both cases the offset is reset so the file is read from the start. The
+// fingerprint is extended as the file grows.
+func (l *LogFile) verifyIdentity(f *os.File) error {
+	stat, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %s", err)
+	}
+	size := stat.Size()
+	changed := false
+
+	// Only the slurper changes the identity, so a copy taken now stays
+	// current while the file is checked.
+	l.lock.Lock()
+	offset, fpSize, fp := l.Offset, l.FingerprintSize, l.Fingerprint
+	l.lock.Unlock()
+
+	reason := ""
+	switch {
+	case size < offset || size < fpSize:
+		reason = "file is smaller than the offset, it has been truncated"
+	case fpSize > 0:
+		current, err := fingerprint(f, fpSize)
+		if err != nil {
+			return err
+		}
+		if current != fp {
+			reason = "file fingerprint does not match, it has been replaced"
+		}
+	}
+	if reason != "" {
+		l.log().Info.With("offset", offset, "size", size).Printf("%s, reading from the start", reason)
+		offset, fpSize, fp = 0, 0, ""
+		changed = true
+	}
+
+	// Extending the fingerprint to cover as much of the start as possible.
+	if fpSize < fingerprintSize && size > fpSize {
+		n := size
+		if n > fingerprintSize {
+			n = fingerprintSize
+		}
+		extended, err := fingerprint(f, n)
+		if err != nil {
+			return err
+		}
+		fp, fpSize = extended, n
+		changed = true
+	}
+
+	if changed {
+		l.lock.Lock()
+		l.Offset, l.FingerprintSize, l.Fingerprint = offset, fpSize, fp
+		l.lock.Unlock()
+		l.Save()
+	}
+	return nil
+}
+
+// fingerprint returns the hex encoded SHA-256 of the first n bytes of f.
+func fingerprint(f io.ReaderAt, n int64) (string, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(io.NewSectionReader(f, 0, n), b); err != nil {
+		return "", fmt.Errorf("failed to read fingerprint: %s", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// savePoint builds a new datapoint from line, adds the tags of the source and
+// sends it to the dataPointChan. Readings dropped by the filter are not sent.
+func (l *LogFile) savePoint(line []byte, dataPointChan chan<- device.DataPoint) error {
+	if l.discovered != nil {
+		l.discovered.Observe(line, time.Now())
+	}
+
 	d, err := device.ParseDataPoint(line)
 	if err != nil {
+		l.reject(line, err)
 		return fmt.Errorf("failed to build datapoint for saving: %s", err)
 	}
+	if keep, rule := l.filter.Keep(d.GetModel(), line); !keep {
+		l.log().Debug.With("model", d.GetModel(), "rule", rule).Println("reading dropped by filter")
+		return nil
+	}
 
-	dataPointChan <- d
+	source, tags := l.source()
+	dataPointChan <- device.WithSource(d, source, tags)
 
 	return nil
 }
 
+// reject records the line that failed to parse with err in the dead letter
+// file. The offset must still be the start of the line.
+func (l *LogFile) reject(line []byte, err error) {
+	if l.deadLetters == nil {
+		return
+	}
+
+	source, _ := l.source()
+	e := deadletter.Entry{
+		Time:   time.Now(),
+		Reason: device.ReasonInvalidJSON,
+		Error:  err.Error(),
+		Source: source,
+		File:   l.path(),
+		Offset: l.offset(),
+		Line:   string(line),
+	}
+	if perr, ok := err.(*device.ParseError); ok {
+		e.Reason = perr.Reason
+		e.Model = perr.Model
+	}
+	if err = l.deadLetters.Write(e); err != nil {
+		l.log().Error.Printf("failed to write dead letter: %s", err)
+	}
+}
+
 // StartSlurp starts slurping the file if possible and sending data to the
 // dataPointChan specified. sleepTimeSeconds is the amount of time the slurper
 // sleeps before looking for new data in the file. The minimum value is 1.
 func (l *LogFile) StartSlurp(dataPointChan chan<- device.DataPoint, sleepTimeSeconds int, maxShutdownWait float64) {
 	// Don't start a new slurp if it's already running.
-	if l.slurpRunning {
-		logger.Verbose.Printf("slurper already started for %s", l.LogFilePath)
+	if l.SlurpRunning() {
+		l.log().Verbose.Println("slurper already started")
+		return
+	}
+	if l.done() {
+		l.log().Verbose.Println("compressed file already slurped")
 		return
 	}
 	go l.slurp(dataPointChan, sleepTimeSeconds)
-	logger.Verbose.Printf("starting slurper for %s at offset %d", l.LogFilePath, l.Offset)
+	l.log().Verbose.With("offset", l.offset()).Println("starting slurper")
 }
 
 // StopSlurp stops slupring and blocks until stopped.
@@ -297,13 +624,13 @@ func (l *LogFile) StopSlurp() {
 	start := time.Now()
 	for l.SlurpRunning() {
 		if time.Since(start).Seconds() > l.SlurperShutdownMaxWaitSeconds {
-			logger.Verbose.Printf("forcing slurp of %s to stop due to exceeding limit", l.LogFilePath)
+			l.log().Verbose.Println("forcing slurp to stop due to exceeding limit")
 			return
 		}
-		logger.Debug.Printf("slurpRunning is: %v", l.SlurpRunning())
+		log.Debug.Printf("slurpRunning is: %v", l.SlurpRunning())
 		time.Sleep(time.Duration(1) * time.Second)
 
 	}
-	logger.Verbose.Printf("slurper for %s has stopped: ", l.LogFilePath)
+	l.log().Verbose.Println("slurper has stopped")
 	return
 }
@@ -0,0 +1,234 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// StateFileName is the name of the state file within the meta data
+	// directory.
+	StateFileName = "state.json"
+
+	// stateVersion is the version of the state file format.
+	stateVersion = 1
+)
+
+// A StateStore persists the state of all known log files to a single JSON
+// file. Changes are only marked dirty by the LogFiles and written in batches
+// by the Filer. The file is replaced atomically by writing a temporary file
+// and renaming it over the old one so a crash never leaves a partial file.
+type StateStore struct {
+	// dir is the meta data directory holding the state file.
+	dir string
+
+	// readOnly is true if the state should never be written, such as during
+	// a dry run.
+	readOnly bool
+
+	// lock protects dirty.
+	lock *sync.Mutex
+
+	// dirty is true if a LogFile has changed since the last write.
+	dirty bool
+}
+
+// stateFile is the on disk format of the state file.
+type stateFile struct {
+	Version int        `json:"version"`
+	Files   []*LogFile `json:"files"`
+}
+
+// NewStateStore creates a StateStore that keeps the state file in dir.
+func NewStateStore(dir string, readOnly bool) *StateStore {
+	return &StateStore{
+		dir:      dir,
+		readOnly: readOnly,
+		lock:     &sync.Mutex{},
+	}
+}
+
+// Path returns the path of the state file.
+func (s *StateStore) Path() string {
+	return filepath.Join(s.dir, StateFileName)
+}
+
+// MarkDirty records that the state has changed and must be written.
+func (s *StateStore) MarkDirty() {
+	s.lock.Lock()
+	s.dirty = true
+	s.lock.Unlock()
+}
+
+// Dirty returns true if the state has changed since the last write.
+func (s *StateStore) Dirty() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.dirty
+}
+
+// Load reads all LogFiles from the state file. Any meta data files left by
+// older versions, one UUID named .meta file per log file, are migrated into
+// the state which is then written and the old files removed.
+func (s *StateStore) Load() ([]*LogFile, error) {
+	files := make([]*LogFile, 0)
+
+	b, err := ioutil.ReadFile(s.Path())
+	switch {
+	case err == nil:
+		state := stateFile{}
+		if err = json.Unmarshal(b, &state); err != nil {
+			return nil, fmt.Errorf("failed to parse state file %s: %s", s.Path(), err)
+		}
+		for _, l := range state.Files {
+			l.init(s)
+			files = append(files, l)
+		}
+	case os.IsNotExist(err):
+	default:
+		return nil, fmt.Errorf("failed to read state file: %s", err)
+	}
+
+	legacy, paths, err := s.loadLegacy()
+	if err != nil {
+		// A dry run never writes so the directory need not exist.
+		if s.readOnly && os.IsNotExist(err) {
+			return files, nil
+		}
+		return nil, err
+	}
+	if len(legacy) == 0 {
+		return files, nil
+	}
+
+	// Migrating any legacy files not already in the state.
+	known := make(map[uuid.UUID]bool)
+	for _, l := range files {
+		known[l.MetaDataID] = true
+	}
+	for _, l := range legacy {
+		if !known[l.MetaDataID] {
+			files = append(files, l)
+		}
+	}
+	if s.readOnly {
+		return files, nil
+	}
+	if err = s.Save(files); err != nil {
+		return nil, fmt.Errorf("failed to save migrated meta data: %s", err)
+	}
+	for _, p := range paths {
+		if err = os.Remove(p); err != nil {
+			log.Error.Printf("failed to remove migrated meta data file %s: %s", p, err)
+		}
+	}
+	log.Info.Printf("migrated %d meta data files into %s", len(legacy), s.Path())
+
+	return files, nil
+}
+
+// loadLegacy reads the UUID named .meta files used by older versions. The
+// LogFiles and the paths of the files read are returned.
+func (s *StateStore) loadLegacy() ([]*LogFile, []string, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files := make([]*LogFile, 0)
+	paths := make([]string, 0)
+	for i := range entries {
+		name := entries[i].Name()
+		if entries[i].IsDir() || !strings.HasSuffix(name, ".meta") {
+			continue
+		}
+
+		// Parsing the uuid so we know it's a meta data file.
+		if _, err := uuid.Parse(strings.TrimSuffix(name, ".meta")); err != nil {
+			log.Info.Printf("meta data file name did not parse into uuid correctly: %s", name)
+			continue
+		}
+
+		path := filepath.Join(s.dir, name)
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Error.Printf("failed to read meta data file %s: %s", path, err)
+			continue
+		}
+		l, err := NewLogFile(b)
+		if err != nil {
+			log.Info.Printf("failed to load meta data from %s", name)
+			continue
+		}
+		l.init(s)
+		files = append(files, l)
+		paths = append(paths, path)
+	}
+
+	return files, paths, nil
+}
+
+// Save writes the state of files to the state file. The dirty flag is
+// cleared before the state is captured so changes made while writing are
+// kept for the next write.
+func (s *StateStore) Save(files []*LogFile) error {
+	s.lock.Lock()
+	s.dirty = false
+	s.lock.Unlock()
+	if s.readOnly {
+		return nil
+	}
+
+	state := stateFile{Version: stateVersion, Files: make([]*LogFile, 0, len(files))}
+	for _, l := range files {
+		state.Files = append(state.Files, l.snapshot())
+	}
+	sort.Slice(state.Files, func(i, j int) bool { return state.Files[i].LogFilePath < state.Files[j].LogFilePath })
+
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		s.MarkDirty()
+		return err
+	}
+	if err = WriteFileAtomic(s.Path(), b); err != nil {
+		s.MarkDirty()
+		return err
+	}
+
+	return nil
+}
+
+// WriteFileAtomic writes data to a temporary file in the same directory as
+// path, syncs it and renames it over path.
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
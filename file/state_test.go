@@ -0,0 +1,89 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jrmycanady/slurp-rtl_433/config"
+)
+
+func TestStateStoreMigrate(t *testing.T) {
+	dir := t.TempDir()
+	id := uuid.New()
+	legacy := filepath.Join(dir, id.String()+".meta")
+	meta := `{"inode":42,"offset":100,"metaDataId":"` + id.String() + `","metaDataFilePath":"` + legacy + `","logFilePath":"/var/log/rtl_433_data.log"}`
+	if err := ioutil.WriteFile(legacy, []byte(meta), 0644); err != nil {
+		t.Fatalf("failed to write legacy meta data: %s", err)
+	}
+
+	s := NewStateStore(dir, false)
+	files, err := s.Load()
+	if err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+	if len(files) != 1 || files[0].Inode != 42 || files[0].Offset != 100 || files[0].MetaDataID != id {
+		t.Fatalf("unexpected migrated files %+v", files)
+	}
+	if _, err = os.Stat(legacy); !os.IsNotExist(err) {
+		t.Fatalf("legacy meta data file not removed")
+	}
+
+	// The migrated state is loaded from the state file alone.
+	files[0].Offset = 200
+	files[0].Save()
+	if !s.Dirty() {
+		t.Fatalf("save did not mark the state dirty")
+	}
+	if err = s.Save(files); err != nil {
+		t.Fatalf("failed to save: %s", err)
+	}
+	files, err = NewStateStore(dir, false).Load()
+	if err != nil {
+		t.Fatalf("failed to reload: %s", err)
+	}
+	if len(files) != 1 || files[0].Offset != 200 {
+		t.Fatalf("unexpected reloaded files %+v", files)
+	}
+}
+
+func TestStateStoreReadOnly(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	s := NewStateStore(dir, true)
+	if _, err := s.Load(); err != nil {
+		t.Fatalf("read only store failed on missing directory: %s", err)
+	}
+	if err := s.Save([]*LogFile{}); err != nil {
+		t.Fatalf("read only store failed to save: %s", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("read only store wrote state")
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.FileMetaDataPath = t.TempDir()
+	f := NewFiler(cfg, nil)
+
+	stale, _ := NewLogFile([]byte{})
+	stale.LastSeen = time.Now().Add(-time.Duration(cfg.StateRetentionSeconds+1) * time.Second)
+	fresh, _ := NewLogFile([]byte{})
+	fresh.LastSeen = time.Now()
+	f.Files[stale.MetaDataID.String()] = stale
+	f.Files[fresh.MetaDataID.String()] = fresh
+
+	f.collectGarbage()
+	if _, ok := f.Files[stale.MetaDataID.String()]; ok {
+		t.Fatalf("stale file not collected")
+	}
+	if _, ok := f.Files[fresh.MetaDataID.String()]; !ok {
+		t.Fatalf("fresh file collected")
+	}
+	if !f.state.Dirty() {
+		t.Fatalf("collection did not mark the state dirty")
+	}
+}
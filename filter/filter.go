@@ -0,0 +1,233 @@
+// Package filter decides which readings are kept based on the values of
+// their fields, such as dropping the sensors of neighbours.
+//
+// In deny mode a reading matching any rule is dropped. In allow mode only
+// the readings matching a rule are kept. A rule matches when every field it
+// lists is present and matches its pattern. The counts of kept and dropped
+// readings are tracked for the status report.
+package filter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/jrmycanady/slurp-rtl_433/config"
+	"github.com/jrmycanady/slurp-rtl_433/match"
+)
+
+// The modes of a Filter.
+const (
+	ModeDeny  = "deny"
+	ModeAllow = "allow"
+)
+
+// A Filter applies the filter rules to readings. It is safe for concurrent
+// use and the rules may be updated while in use.
+type Filter struct {
+	// lock protects all fields.
+	lock *sync.Mutex
+
+	mode  string
+	rules []rule
+
+	kept           int64
+	dropped        int64
+	droppedByModel map[string]int64
+	droppedByRule  map[string]int64
+}
+
+// rule is a compiled FilterRule.
+type rule struct {
+	name   string
+	fields map[string]match.Matcher
+}
+
+// Status is a snapshot of the counts of a Filter.
+type Status struct {
+	Mode    string `json:"mode"`
+	Rules   int    `json:"rules"`
+	Kept    int64  `json:"kept"`
+	Dropped int64  `json:"dropped"`
+
+	// DroppedByModel counts the dropped readings of each model.
+	DroppedByModel map[string]int64 `json:"droppedByModel"`
+
+	// DroppedByRule counts the readings dropped by each rule in deny mode.
+	DroppedByRule map[string]int64 `json:"droppedByRule"`
+}
+
+// New creates a Filter from the configuration. An error is returned if the
+// mode or any pattern is invalid.
+func New(cfg config.FilterConfig) (*Filter, error) {
+	f := &Filter{
+		lock:           &sync.Mutex{},
+		droppedByModel: make(map[string]int64),
+		droppedByRule:  make(map[string]int64),
+	}
+	if err := f.Update(cfg); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Update replaces the mode and rules with those in the configuration keeping
+// the counts. If the configuration is invalid the current rules are kept
+// and an error is returned.
+func (f *Filter) Update(cfg config.FilterConfig) error {
+	mode := cfg.Mode
+	switch mode {
+	case "":
+		mode = ModeDeny
+	case ModeDeny, ModeAllow:
+	default:
+		return fmt.Errorf("invalid filter mode %q, must be %s or %s", cfg.Mode, ModeDeny, ModeAllow)
+	}
+
+	rules := make([]rule, 0, len(cfg.Rules))
+	for i, r := range cfg.Rules {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("rule %d", i+1)
+		}
+		if len(r.Fields) == 0 {
+			return fmt.Errorf("filter %s has no fields", name)
+		}
+		compiled := rule{name: name, fields: make(map[string]match.Matcher)}
+		for field, pattern := range r.Fields {
+			m, err := match.Parse(pattern)
+			if err != nil {
+				return fmt.Errorf("filter %s field %s: %s", name, field, err)
+			}
+			compiled.fields[field] = m
+		}
+		rules = append(rules, compiled)
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.mode = mode
+	f.rules = rules
+
+	return nil
+}
+
+// Keep returns true if the reading in the rtl_433 output line should be
+// kept. If it is dropped the name of the deciding rule is returned, which is
+// empty in allow mode. A nil Filter keeps everything.
+func (f *Filter) Keep(model string, line []byte) (bool, string) {
+	if f == nil {
+		return true, ""
+	}
+
+	f.lock.Lock()
+	mode, rules := f.mode, f.rules
+	f.lock.Unlock()
+
+	matched := ""
+	if len(rules) > 0 {
+		fields := decodeFields(line)
+		for _, r := range rules {
+			if r.match(fields) {
+				matched = r.name
+				break
+			}
+		}
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if (mode == ModeDeny) == (matched == "") {
+		f.kept++
+		return true, ""
+	}
+	f.dropped++
+	f.droppedByModel[model]++
+	if mode == ModeDeny {
+		f.droppedByRule[matched]++
+		return false, matched
+	}
+
+	return false, ""
+}
+
+// Status returns a snapshot of the counts.
+func (f *Filter) Status() Status {
+	if f == nil {
+		return Status{Mode: ModeDeny, DroppedByModel: map[string]int64{}, DroppedByRule: map[string]int64{}}
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	s := Status{
+		Mode:           f.mode,
+		Rules:          len(f.rules),
+		Kept:           f.kept,
+		Dropped:        f.dropped,
+		DroppedByModel: make(map[string]int64, len(f.droppedByModel)),
+		DroppedByRule:  make(map[string]int64, len(f.droppedByRule)),
+	}
+	for k, v := range f.droppedByModel {
+		s.DroppedByModel[k] = v
+	}
+	for k, v := range f.droppedByRule {
+		s.DroppedByRule[k] = v
+	}
+
+	return s
+}
+
+// match returns true if every field of the rule is present and matches.
+func (r rule) match(fields map[string]string) bool {
+	for name, m := range r.fields {
+		v, ok := fields[name]
+		if !ok || !m.Match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeFields returns the top level fields of the line formatted for
+// matching. Nil is returned if the line is not a JSON object.
+func decodeFields(line []byte) map[string]string {
+	raw := make(map[string]interface{})
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil
+	}
+
+	fields := make(map[string]string, len(raw))
+	for k, v := range raw {
+		fields[k] = match.Value(v)
+	}
+	return fields
+}
+
+// Describe returns a line describing each rule for logs and the check-config
+// command.
+func (f *Filter) Describe() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	lines := make([]string, 0, len(f.rules))
+	for _, r := range f.rules {
+		names := make([]string, 0, len(r.fields))
+		for name := range r.fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		d := fmt.Sprintf("%s %s:", f.mode, r.name)
+		for _, name := range names {
+			d += fmt.Sprintf(" %s=%s", name, r.fields[name])
+		}
+		lines = append(lines, d)
+	}
+
+	return lines
+}
@@ -0,0 +1,70 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/jrmycanady/slurp-rtl_433/config"
+)
+
+const (
+	ours      = `{"model" : "Acurite tower sensor", "id" : 1234, "channel" : "A", "temperature_C" : 21.5}`
+	neighbour = `{"model" : "Acurite tower sensor", "id" : 99, "channel" : "C", "temperature_C" : 18.0}`
+	tpms      = `{"model" : "Toyota", "type" : "TPMS", "id" : "0a1b2c3d"}`
+)
+
+func TestFilterDeny(t *testing.T) {
+	f, err := New(config.FilterConfig{Rules: []config.FilterRule{
+		{Name: "neighbour", Fields: map[string]string{"model": "/^Acurite/", "channel": "/^[BC]$/"}},
+		{Name: "tpms", Fields: map[string]string{"type": "TPMS"}},
+	}})
+	if err != nil {
+		t.Fatalf("failed to build filter: %s", err)
+	}
+
+	if keep, _ := f.Keep("Acurite tower sensor", []byte(ours)); !keep {
+		t.Errorf("our sensor was dropped")
+	}
+	if keep, rule := f.Keep("Acurite tower sensor", []byte(neighbour)); keep || rule != "neighbour" {
+		t.Errorf("neighbour was not dropped by its rule, got %v %s", keep, rule)
+	}
+	if keep, rule := f.Keep("Toyota", []byte(tpms)); keep || rule != "tpms" {
+		t.Errorf("tpms was not dropped by its rule, got %v %s", keep, rule)
+	}
+
+	s := f.Status()
+	if s.Mode != ModeDeny || s.Kept != 1 || s.Dropped != 2 || s.DroppedByModel["Toyota"] != 1 || s.DroppedByRule["neighbour"] != 1 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestFilterAllow(t *testing.T) {
+	f, err := New(config.FilterConfig{Mode: ModeAllow, Rules: []config.FilterRule{
+		{Fields: map[string]string{"id": "1000..2000", "temperature_C": "-40..60"}},
+	}})
+	if err != nil {
+		t.Fatalf("failed to build filter: %s", err)
+	}
+
+	if keep, _ := f.Keep("Acurite tower sensor", []byte(ours)); !keep {
+		t.Errorf("allowed sensor was dropped")
+	}
+	if keep, _ := f.Keep("Acurite tower sensor", []byte(neighbour)); keep {
+		t.Errorf("sensor outside the id range was kept")
+	}
+	if keep, _ := f.Keep("Toyota", []byte(tpms)); keep {
+		t.Errorf("sensor missing a field was kept")
+	}
+
+	// An invalid update keeps the current rules.
+	if err = f.Update(config.FilterConfig{Mode: "block"}); err == nil {
+		t.Fatalf("expected invalid mode to fail")
+	}
+	if s := f.Status(); s.Mode != ModeAllow || s.Rules != 1 || s.Dropped != 2 {
+		t.Errorf("unexpected status after failed update %+v", s)
+	}
+
+	var none *Filter
+	if keep, _ := none.Keep("Toyota", []byte(tpms)); !keep {
+		t.Errorf("nil filter dropped a reading")
+	}
+}
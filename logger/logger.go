@@ -2,100 +2,505 @@
 // building separate loggers for each category. The output location of
 // the logs can be specified on creation.
 //
-// Logging Levels
-//
 // The logger offers 4 log levels. In general Error and Info should be used
 // if at all possible.
-// Error:
-//  * Fails to perform an action that should be successful. i.e. Database failures.
-// Info:
-//  * General program flow used by administrators
-// Verbose:
-//  * Detailed flow that may be used to track production issues.
-// Debug:
-//  * Very low level state items that should never hit log files in production.
-//  * Sensitive information could be in the log file..
-//  * Generally these could be removed without any end user ever carring
 //
-// Usage
+// Error: Fails to perform an action that should be successful. i.e. Database
+// failures.
+//
+// Info: General program flow used by administrators.
+//
+// Verbose: Detailed flow that may be used to track production issues.
+//
+// Debug: Very low level state items that should never hit log files in
+// production. Sensitive information could be in the log file. Generally these
+// could be removed without any end user ever carring.
+//
+// Each package should create its own Component with For so the levels can be
+// overridden per package. Key/value fields may be attached to a Component or
+// a single level with With and are written along with every message.
+//
+//	var log = logger.For("file")
+//	log.Error.With("file", path, "inode", inode).Printf("failed to open file")
 //
 // The logger configures on import to utilize os.Stdout. By default all
-// loggers are enabled. The output location can be modified directly on
-// the variable as they are normal log instances. To disable a log
-// simply use SetOutput.
+// levels are enabled. Output may be written as text or as JSON for log
+// shippers. Repeated error messages can be rate limited so a failing sink
+// does not flood the log.
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the logging level of a message.
+type Level int
+
+const (
+	// LevelError is the error logging level.
+	LevelError Level = iota
+
+	// LevelInfo is the info logging level.
+	LevelInfo
+
+	// LevelVerbose is the verbose logging level.
+	LevelVerbose
+
+	// LevelDebug is the debug logging level.
+	LevelDebug
+)
+
+// String returns the name of the level as used in configuration.
+func (l Level) String() string {
+	switch l {
+	case LevelError:
+		return "error"
+	case LevelInfo:
+		return "info"
+	case LevelVerbose:
+		return "verbose"
+	case LevelDebug:
+		return "debug"
+	}
+	return "unknown"
+}
+
+// textPrefixes are the prefixes used for each level in the text format.
+var textPrefixes = map[Level]string{
+	LevelError:   "  [ERROR] ",
+	LevelInfo:    "   [INFO] ",
+	LevelVerbose: "[VERBOSE] ",
+	LevelDebug:   "  [DEBUG] ",
+}
+
+const (
+	// FormatText writes each message as a single line of text.
+	FormatText = "text"
+
+	// FormatJSON writes each message as a single JSON object.
+	FormatJSON = "json"
+)
+
+var (
+	// lock protects all of the output state below.
+	lock = &sync.Mutex{}
+
+	// output is where all messages are written.
+	output io.Writer = os.Stdout
+
+	// format is the format all messages are written in.
+	format = FormatText
+
+	// levels are the enabled levels for any component without an override.
+	levels = map[Level]bool{LevelError: true, LevelInfo: true, LevelVerbose: true, LevelDebug: true}
+
+	// componentLevels are the enabled levels for components with an override.
+	componentLevels = map[string]map[Level]bool{}
+
+	// rateLimit is the minimum time between repeated error messages.
+	rateLimit time.Duration
+
+	// limits tracks repeated error messages for rate limiting.
+	limits = map[string]*limitState{}
+
+	// lastExpire is when limits was last cleared of expired messages.
+	lastExpire time.Time
 )
 
+// limitFields are the fields identifying what a message is about, such as
+// the file or sink. Other fields, such as offsets and counters, change from
+// one message to the next and are left out of the rate limit key.
+var limitFields = map[string]bool{
+	"derived": true,
+	"file":    true,
+	"input":   true,
+	"model":   true,
+	"path":    true,
+	"sensor":  true,
+	"set":     true,
+	"sink":    true,
+	"source":  true,
+}
+
+// limitState tracks a single repeated message.
+type limitState struct {
+	last       time.Time
+	suppressed int
+}
+
 var (
+	root = For("")
+
 	// Error is a logger that logs on error level.
-	Error = log.New(os.Stdout, "  [ERROR] ", log.Ldate|log.Ltime|log.LUTC)
+	Error = root.Error
 
 	// Info is the logger that logs on the info level.
-	Info = log.New(os.Stdout, "   [INFO] ", log.Ldate|log.Ltime|log.LUTC)
+	Info = root.Info
 
 	// Verbose is a logger that logs at the verbose level.
-	Verbose = log.New(os.Stdout, "[VERBOSE] ", log.Ldate|log.Ltime|log.LUTC)
+	Verbose = root.Verbose
 
 	// Debug is a logger that logs at the debug level.
-	Debug = log.New(os.Stdout, "  [DEBUG] ", log.Ldate|log.Ltime|log.LUTC)
+	Debug = root.Debug
 )
 
-// Update takes an io.Writer and depending on if enable is true will set the logger
-// to the io.Writer or in the case of false set it to Discard.
-// This can be used to setup the logging level at a global scale.
-func Update(handler io.Writer, enableError bool, enableInfo bool, enableVerbose, bool, enableDebug bool) {
-	if enableError {
-		Error.SetOutput(handler)
-	} else {
-		Error.SetOutput(ioutil.Discard)
+// A Component is a set of leveled loggers for a single package or part of
+// the application. Levels may be overridden per component name.
+type Component struct {
+	name   string
+	fields []interface{}
+
+	// Error is a logger that logs on error level.
+	Error *Leveled
+
+	// Info is the logger that logs on the info level.
+	Info *Leveled
+
+	// Verbose is a logger that logs at the verbose level.
+	Verbose *Leveled
+
+	// Debug is a logger that logs at the debug level.
+	Debug *Leveled
+}
+
+// For creates a new Component with the name provided.
+func For(name string) *Component {
+	return newComponent(name, nil)
+}
+
+// newComponent builds the component and all of its leveled loggers.
+func newComponent(name string, fields []interface{}) *Component {
+	c := &Component{
+		name:   name,
+		fields: fields,
 	}
+	c.Error = &Leveled{component: c, level: LevelError}
+	c.Info = &Leveled{component: c, level: LevelInfo}
+	c.Verbose = &Leveled{component: c, level: LevelVerbose}
+	c.Debug = &Leveled{component: c, level: LevelDebug}
 
-	if enableInfo {
-		Info.SetOutput(handler)
-	} else {
-		Info.SetOutput(ioutil.Discard)
+	return c
+}
+
+// With returns a copy of the component that adds the key/value pairs
+// provided to every message.
+func (c *Component) With(kv ...interface{}) *Component {
+	return newComponent(c.name, appendFields(c.fields, kv))
+}
+
+// Leveled is a logger for a single level of a Component.
+type Leveled struct {
+	component *Component
+	level     Level
+	fields    []interface{}
+}
+
+// With returns a copy of the logger that adds the key/value pairs provided
+// to every message.
+func (l *Leveled) With(kv ...interface{}) *Leveled {
+	return &Leveled{
+		component: l.component,
+		level:     l.level,
+		fields:    appendFields(l.fields, kv),
 	}
+}
 
-	if enableVerbose {
-		Verbose.SetOutput(handler)
-	} else {
-		Verbose.SetOutput(ioutil.Discard)
+// Enabled returns true if messages at this level are written for the
+// component.
+func (l *Leveled) Enabled() bool {
+	lock.Lock()
+	defer lock.Unlock()
+	return enabled(l.component.name, l.level)
+}
+
+// Printf writes a message formatted with fmt.Sprintf.
+func (l *Leveled) Printf(f string, v ...interface{}) {
+	l.write(f, fmt.Sprintf(f, v...))
+}
+
+// Println writes a message formatted with fmt.Sprintln.
+func (l *Leveled) Println(v ...interface{}) {
+	msg := strings.TrimSuffix(fmt.Sprintln(v...), "\n")
+	key := msg
+	if len(v) > 0 {
+		if s, ok := v[0].(string); ok {
+			key = s
+		}
+	}
+	l.write(key, msg)
+}
+
+// write writes the message if the level is enabled. key identifies the
+// message for rate limiting along with the component and its identifying
+// fields, so the same message about different files or sinks is limited
+// separately.
+func (l *Leveled) write(key string, msg string) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !enabled(l.component.name, l.level) {
+		return
 	}
 
-	if enableDebug {
-		Debug.SetOutput(handler)
+	fields := appendFields(l.component.fields, l.fields)
+
+	// Rate limiting repeated errors.
+	if l.level == LevelError && rateLimit > 0 {
+		expireLimits()
+		key = limitKey(l.component.name, fields, key)
+		state, ok := limits[key]
+		if ok && time.Since(state.last) < rateLimit {
+			state.suppressed++
+			return
+		}
+		if !ok {
+			state = &limitState{}
+			limits[key] = state
+		}
+		if state.suppressed > 0 {
+			fields = append(fields, "suppressed", state.suppressed)
+		}
+		state.last = time.Now()
+		state.suppressed = 0
+	}
+
+	var line string
+	if format == FormatJSON {
+		line = renderJSON(time.Now().UTC(), l.level, l.component.name, msg, fields)
 	} else {
-		Debug.SetOutput(ioutil.Discard)
+		line = renderText(time.Now().UTC(), l.level, l.component.name, msg, fields)
 	}
+	io.WriteString(output, line)
 }
 
-// UpdateWithLevelList updates the handler of each level to handler based on the levels provided.
-func UpdateWithLevelList(handler io.Writer, errorLevels []string) {
+// limitKey returns the key a message is rate limited by.
+func limitKey(component string, fields []interface{}, key string) string {
+	b := strings.Builder{}
+	b.WriteString(component)
+	for i := 0; i < len(fields); i += 2 {
+		if !limitFields[fmt.Sprint(fields[i])] {
+			continue
+		}
+		b.WriteString("\x00")
+		b.WriteString(fmt.Sprint(fields[i]))
+		b.WriteString("=")
+		b.WriteString(fmt.Sprint(fieldValue(fields, i)))
+	}
+	b.WriteString("\x00")
+	b.WriteString(key)
 
-	// Configure all but error to discard by default.
-	Info.SetOutput(ioutil.Discard)
-	Verbose.SetOutput(ioutil.Discard)
-	Debug.SetOutput(ioutil.Discard)
+	return b.String()
+}
 
-	for _, s := range errorLevels {
+// expireLimits forgets the messages last written more than the rate limit
+// ago, at most once per rate limit, so limits does not grow without bound.
+// Messages with suppressed repeats are kept so the count is added to the
+// next one written. lock must be held.
+func expireLimits() {
+	if time.Since(lastExpire) < rateLimit {
+		return
+	}
+	for key, state := range limits {
+		if state.suppressed == 0 && time.Since(state.last) >= rateLimit {
+			delete(limits, key)
+		}
+	}
+	lastExpire = time.Now()
+}
+
+// enabled returns true if the level is enabled for the component. lock must
+// be held.
+func enabled(component string, level Level) bool {
+	if set, ok := componentLevels[component]; ok {
+		return set[level]
+	}
+	return levels[level]
+}
+
+// appendFields returns a new slice containing a followed by b.
+func appendFields(a []interface{}, b []interface{}) []interface{} {
+	fields := make([]interface{}, 0, len(a)+len(b))
+	fields = append(fields, a...)
+	return append(fields, b...)
+}
+
+// renderText renders the message as a single line of text.
+func renderText(t time.Time, level Level, component string, msg string, fields []interface{}) string {
+	b := strings.Builder{}
+	b.WriteString(textPrefixes[level])
+	b.WriteString(t.Format("2006/01/02 15:04:05 "))
+	b.WriteString(msg)
+	if component != "" {
+		b.WriteString(" component=")
+		b.WriteString(component)
+	}
+	for i := 0; i < len(fields); i += 2 {
+		b.WriteString(" ")
+		b.WriteString(fmt.Sprint(fields[i]))
+		b.WriteString("=")
+		b.WriteString(textValue(fieldValue(fields, i)))
+	}
+	b.WriteString("\n")
+
+	return b.String()
+}
+
+// textValue formats a field value quoting it if needed.
+func textValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// renderJSON renders the message as a single JSON object.
+func renderJSON(t time.Time, level Level, component string, msg string, fields []interface{}) string {
+	m := map[string]interface{}{
+		"time":  t.Format(time.RFC3339),
+		"level": level.String(),
+		"msg":   msg,
+	}
+	if component != "" {
+		m["component"] = component
+	}
+	for i := 0; i < len(fields); i += 2 {
+		v := fieldValue(fields, i)
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		m[fmt.Sprint(fields[i])] = v
+	}
+
+	j, err := json.Marshal(m)
+	if err != nil {
+		j, _ = json.Marshal(map[string]string{
+			"time":  t.Format(time.RFC3339),
+			"level": level.String(),
+			"msg":   msg,
+			"error": fmt.Sprintf("failed to encode fields: %s", err),
+		})
+	}
+
+	return string(j) + "\n"
+}
+
+// fieldValue returns the value for the key at index i or a placeholder if
+// the value is missing.
+func fieldValue(fields []interface{}, i int) interface{} {
+	if i+1 < len(fields) {
+		return fields[i+1]
+	}
+	return "(missing)"
+}
+
+// parseLevels builds the set of enabled levels from a list of level names.
+func parseLevels(names []string) (map[Level]bool, error) {
+	set := map[Level]bool{}
+	for _, s := range names {
 		switch s {
 		case "error":
-			Error.SetOutput(handler)
+			set[LevelError] = true
 		case "info":
-			Info.SetOutput(handler)
+			set[LevelInfo] = true
 		case "verbose":
-			Verbose.SetOutput(handler)
+			set[LevelVerbose] = true
 		case "debug":
-			Debug.SetOutput(handler)
+			set[LevelDebug] = true
+		default:
+			return nil, fmt.Errorf("unknown log level %s", s)
 		}
 	}
+	return set, nil
+}
+
+// Update takes an io.Writer and depending on if enable is true will set the logger
+// to the io.Writer or in the case of false set it to Discard.
+// This can be used to setup the logging level at a global scale.
+func Update(handler io.Writer, enableError bool, enableInfo bool, enableVerbose bool, enableDebug bool) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	output = handler
+	levels = map[Level]bool{
+		LevelError:   enableError,
+		LevelInfo:    enableInfo,
+		LevelVerbose: enableVerbose,
+		LevelDebug:   enableDebug,
+	}
+}
+
+// UpdateWithLevelList updates the handler of each level to handler based on the levels provided.
+// Error is always enabled and unknown level names are ignored.
+func UpdateWithLevelList(handler io.Writer, errorLevels []string) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	output = handler
+	levels = map[Level]bool{LevelError: true}
+	for _, s := range errorLevels {
+		if set, err := parseLevels([]string{s}); err == nil {
+			for l := range set {
+				levels[l] = true
+			}
+		}
+	}
+}
+
+// SetFormat sets the output format to FormatText or FormatJSON.
+func SetFormat(f string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	switch f {
+	case "", FormatText:
+		format = FormatText
+	case FormatJSON:
+		format = FormatJSON
+	default:
+		return fmt.Errorf("unknown log format %s", f)
+	}
+	return nil
+}
+
+// SetComponentLevels overrides the enabled levels for each component named
+// in the map. Components not in the map use the global levels. Any previous
+// overrides are removed.
+func SetComponentLevels(overrides map[string][]string) error {
+	parsed := make(map[string]map[Level]bool, len(overrides))
+	for name, names := range overrides {
+		set, err := parseLevels(names)
+		if err != nil {
+			return fmt.Errorf("invalid log levels for %s: %s", name, err)
+		}
+		parsed[name] = set
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	componentLevels = parsed
+
+	return nil
+}
+
+// SetRateLimit sets the minimum time between repeated error messages. A
+// repeated message is one logged by the same component with the same format
+// about the same file, sink, model or the like.
+// The number of suppressed messages is added to the next message written. A
+// value of 0 disables rate limiting.
+func SetRateLimit(d time.Duration) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	rateLimit = d
+	limits = map[string]*limitState{}
 }
 
 // ConfigureWithFile configures the output to use the file at the path provided.
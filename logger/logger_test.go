@@ -0,0 +1,124 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reset restores the default output state after a test.
+func reset(t *testing.T, buff *bytes.Buffer) {
+	UpdateWithLevelList(buff, []string{"error", "info", "verbose", "debug"})
+	t.Cleanup(func() {
+		Update(os.Stdout, true, true, true, true)
+		SetFormat(FormatText)
+		SetComponentLevels(nil)
+		SetRateLimit(0)
+	})
+}
+
+func TestTextFields(t *testing.T) {
+	buff := &bytes.Buffer{}
+	reset(t, buff)
+
+	For("file").With("file", "/var/log/rtl_433.log").Error.With("inode", 42).Printf("failed to open %s", "file")
+
+	line := buff.String()
+	if !strings.HasPrefix(line, "  [ERROR] ") {
+		t.Fatalf("missing level prefix: %s", line)
+	}
+	if !strings.HasSuffix(line, "failed to open file component=file file=/var/log/rtl_433.log inode=42\n") {
+		t.Fatalf("unexpected line: %s", line)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	buff := &bytes.Buffer{}
+	reset(t, buff)
+	if err := SetFormat(FormatJSON); err != nil {
+		t.Fatalf("failed to set format: %s", err)
+	}
+
+	For("dump").Info.With("sink", "influxdb", "points", 3).Println("dumped datapoints")
+
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(buff.Bytes(), &m); err != nil {
+		t.Fatalf("failed to parse %s: %s", buff.String(), err)
+	}
+	if m["level"] != "info" || m["component"] != "dump" || m["msg"] != "dumped datapoints" || m["sink"] != "influxdb" || m["points"] != float64(3) {
+		t.Fatalf("unexpected object: %v", m)
+	}
+}
+
+func TestComponentLevels(t *testing.T) {
+	buff := &bytes.Buffer{}
+	reset(t, buff)
+	UpdateWithLevelList(buff, []string{"error", "info"})
+	if err := SetComponentLevels(map[string][]string{"dump": {"error", "debug"}}); err != nil {
+		t.Fatalf("failed to set component levels: %s", err)
+	}
+
+	For("file").Debug.Println("hidden")
+	For("dump").Info.Println("hidden")
+	For("dump").Debug.Println("shown")
+
+	if strings.Contains(buff.String(), "hidden") || !strings.Contains(buff.String(), "shown") {
+		t.Fatalf("unexpected output: %s", buff.String())
+	}
+
+	if err := SetComponentLevels(map[string][]string{"dump": {"loud"}}); err == nil {
+		t.Fatalf("unknown level accepted")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	buff := &bytes.Buffer{}
+	reset(t, buff)
+	SetRateLimit(time.Duration(50) * time.Millisecond)
+
+	log := For("dump")
+	for i := 0; i < 5; i++ {
+		log.Error.Printf("failed to send points: attempt %d", i)
+	}
+	if n := strings.Count(buff.String(), "\n"); n != 1 {
+		t.Fatalf("expected 1 line, got %d: %s", n, buff.String())
+	}
+
+	time.Sleep(time.Duration(60) * time.Millisecond)
+	log.Error.Printf("failed to send points: attempt %d", 5)
+	if !strings.Contains(buff.String(), "suppressed=4") {
+		t.Fatalf("missing suppressed count: %s", buff.String())
+	}
+
+	// Per event fields do not make a message new.
+	buff.Reset()
+	for i := 0; i < 10; i++ {
+		log.With("sink", "influxdb").Error.With("failures", i).Printf("failed to write points")
+	}
+	if n := strings.Count(buff.String(), "\n"); n != 1 {
+		t.Fatalf("expected 1 line with a changing counter, got %d: %s", n, buff.String())
+	}
+
+	// The same message about another sink or from another component is not
+	// limited.
+	buff.Reset()
+	log.With("sink", "backup").Error.Printf("failed to write points")
+	For("file").With("sink", "influxdb").Error.Printf("failed to write points")
+	if n := strings.Count(buff.String(), "\n"); n != 2 {
+		t.Fatalf("expected 2 lines, got %d: %s", n, buff.String())
+	}
+
+	// Messages are forgotten once their window has passed unless repeats
+	// were suppressed.
+	time.Sleep(time.Duration(60) * time.Millisecond)
+	log.Error.Printf("failed to flush")
+	lock.Lock()
+	n := len(limits)
+	lock.Unlock()
+	if n != 2 {
+		t.Errorf("expected the suppressed and new messages to be kept, got %d", n)
+	}
+}
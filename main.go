@@ -3,175 +3,315 @@ package main
 import (
 	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
+	"strings"
+	"time"
 
 	"github.com/jrmycanady/slurp-rtl_433/config"
-	"github.com/jrmycanady/slurp-rtl_433/device"
 	"github.com/jrmycanady/slurp-rtl_433/dump"
-	"github.com/jrmycanady/slurp-rtl_433/file"
 	"github.com/jrmycanady/slurp-rtl_433/logger"
+	"github.com/jrmycanady/slurp-rtl_433/units"
 	"github.com/ogier/pflag"
 )
 
-var (
-	// All string flags defaults are a blank unicode character. '⠀'
-	cPath             = pflag.StringP("config", "c", "⠀", "The path to he config file.")
-	cDataLocation     = pflag.StringP("data-location", "d", "⠀", "The path and search string for the data to monitor.")
-	cMetaDataLocation = pflag.StringP("meta-data-location", "m", "⠀", "The meta data folder location.")
-	cFQDN             = pflag.StringP("fqdn", "f", "⠀", "The FQDN to the InfluxDB server.")
-	cPort             = pflag.IntP("port", "P", -1, "The port to the InfluxDB server.")
-	cUsername         = pflag.StringP("username", "u", "⠀", "The username used to connect to InfluxDB with.")
-	cPassword         = pflag.StringP("password", "p", "⠀", "The password used to connect to InfluxDB with.")
-	cDatabase         = pflag.StringP("database", "b", "⠀", "The name of the InfluxDB database.")
-	cVerbose          = pflag.BoolP("verbose", "v", false, "Enable verbose logging.")
-	cDebug            = pflag.BoolP("debug", "D", false, "Enable debug logging.")
-	cVersion          = pflag.BoolP("version", "V", false, "Display version information.")
-)
+// A command is a subcommand of slurp-rtl_433.
+type command struct {
+	// name is the name used to run the command.
+	name string
 
-// Usage replaces the default usage function for the flag package.
-func Usage() {
-	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
-}
+	// args describes the positional arguments of the command.
+	args string
 
-func main() {
-	var err error
+	// short is a one line description of the command.
+	short string
 
-	pflag.Usage = Usage
-	pflag.Parse()
+	// run runs the command with the arguments following the command name
+	// and returns the exit code.
+	run func(cmd command, args []string) int
+}
 
-	if *cVersion {
-		fmt.Printf("slurp_rtL-433 version %s\n", version)
-		return
+// commands contains all commands. The first command is run if no command is
+// provided.
+var commands = []command{
+	{"run", "", "Monitor the data location and send all readings to the sinks.", runRun},
+	{"replay", "<file>...", "Send the readings in the files to the sinks once without touching offsets.", runReplay},
+	{"check-config", "", "Validate the configuration and exit non-zero on problems.", runCheckConfig},
+	{"devices", "", "List the supported device models and their fields.", runDevices},
+	{"parse", "", "Parse readings from stdin and print the resulting points.", runParse},
+	{"test-meta", "[file]...", "Show which Meta sets match each reading from stdin or the files.", runTestMeta},
+	{"dead-letters", "[file]...", "Summarize the lines that failed to parse by reason and model.", runDeadLetters},
+	{"discovered", "", "List every device heard along with how often and when.", runDiscovered},
+	{"status", "", "Query the status server of a running instance.", runStatus},
+}
+
+// Usage prints the usage of slurp-rtl_433 and all of its commands.
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags] [args]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s%s\n", cmd.name, cmd.short)
 	}
+	fmt.Fprintf(os.Stderr, "\nIf no command is provided run is used. Use \"%s <command> --help\" for the flags of a command.\n", os.Args[0])
+}
 
-	// Loading configuration from file and args.
-	globalConfig, err := loadConfig()
-	if err != nil {
-		fmt.Printf("failed to load configuration: %s\n", err)
-		if *cDebug {
-			fmt.Println(err)
+func main() {
+	args := os.Args[1:]
 
+	if len(args) > 0 {
+		switch args[0] {
+		case "-V", "--version", "version":
+			fmt.Printf("slurp_rtL-433 version %s\n", version)
+			return
+		case "-h", "--help", "help":
+			Usage()
+			return
 		}
-		return
 	}
 
-	// Configuring the logger to output file or stdout.
-	output, err := buildLogger(globalConfig)
-	if err != nil {
-		fmt.Printf("failed to start logging\n")
-		if *cDebug {
-			fmt.Println(err)
-			return
+	// Flags without a command are passed to run for compatibility.
+	cmd := commands[0]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		found := false
+		for _, c := range commands {
+			if c.name == args[0] {
+				cmd = c
+				found = true
+				break
+			}
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "unknown command %s\n\n", args[0])
+			Usage()
+			os.Exit(2)
 		}
+		args = args[1:]
 	}
-	defer output.Close()
 
-	// Build signal channel to catch term signal.
-	signals := make(chan os.Signal)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	os.Exit(cmd.run(cmd, args))
+}
 
-	logger.Info.Println("starting dumper")
-	dumpChan := make(chan device.DataPoint)
-	dumper := dump.NewDumper(globalConfig, dumpChan)
-	if err := dumper.StartDump(); err != nil {
-		logger.Error.Printf("failed to start dumper: %s", err)
-		return
+// newFlagSet creates a new flag set for the command with a usage function
+// describing the command.
+func newFlagSet(cmd command) *pflag.FlagSet {
+	fs := pflag.NewFlagSet(cmd.name, pflag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [flags] %s\n\n%s\n\nFlags:\n", os.Args[0], cmd.name, cmd.args, cmd.short)
+		fs.PrintDefaults()
 	}
+	return fs
+}
 
-	f := file.NewFiler(globalConfig, dumpChan)
-	if err := f.Start(); err != nil {
-		logger.Error.Printf("failed to start filer: %s", err)
-		return
-	}
+// configFlags are the flags used to build a configuration. Only the flag
+// groups added to a command are applied.
+type configFlags struct {
+	fs *pflag.FlagSet
+
+	path    *string
+	verbose *bool
+	debug   *bool
+
+	dataLocation     *string
+	metaDataLocation *string
 
-	// Waiting for term signal to gracefully shutdown.
-	<-signals
-	logger.Info.Println("received term signal, shutting down now")
+	fqdn         *string
+	port         *int
+	username     *string
+	password     *string
+	passwordFile *string
+	database     *string
 
-	// Stop filer.
-	logger.Info.Println("stopping filer")
-	f.Stop()
+	dryRun *bool
+	format *string
+}
 
-	// Stop dumper.
-	logger.Info.Println("stopping dumper")
-	dumper.StopDump()
+// newConfigFlags adds the config file and logging flags to fs.
+func newConfigFlags(fs *pflag.FlagSet) *configFlags {
+	return &configFlags{
+		fs:      fs,
+		path:    fs.StringP("config", "c", "", "The path to the config file."),
+		verbose: fs.BoolP("verbose", "v", false, "Enable verbose logging."),
+		debug:   fs.BoolP("debug", "D", false, "Enable debug logging."),
+	}
+}
 
-	logger.Info.Println("slurp-rtl_433 going to bed, good night")
+// addDataFlags adds the flags describing where rtl_433 data is found.
+func (c *configFlags) addDataFlags() *configFlags {
+	c.dataLocation = c.fs.StringP("data-location", "d", "", "The path and search string for the data to monitor.")
+	c.metaDataLocation = c.fs.StringP("meta-data-location", "m", "", "The meta data folder location.")
+	return c
+}
 
+// addInfluxDBFlags adds the flags describing the InfluxDB connection.
+func (c *configFlags) addInfluxDBFlags() *configFlags {
+	c.fqdn = c.fs.StringP("fqdn", "f", "", "The FQDN to the InfluxDB server.")
+	c.port = c.fs.IntP("port", "P", 0, "The port to the InfluxDB server.")
+	c.username = c.fs.StringP("username", "u", "", "The username used to connect to InfluxDB with.")
+	c.password = c.fs.StringP("password", "p", "", "The password used to connect to InfluxDB with.")
+	c.passwordFile = c.fs.String("password-file", "", "The path to a file containing the password used to connect to InfluxDB with.")
+	c.database = c.fs.StringP("database", "b", "", "The name of the InfluxDB database.")
+	return c
 }
 
-// buildLogger creates new loggers based on the parameters found in the current
-// configuration. If this never called the default is to log all levels out
-// to stdout.
-func buildLogger(cfg config.Config) (*os.File, error) {
-	var output *os.File
-	var err error
+// addDryRunFlags adds the flags that render points to stdout in place of
+// sending them to the sinks.
+func (c *configFlags) addDryRunFlags() *configFlags {
+	c.dryRun = c.fs.Bool("dry-run", false, "Print the points that would be written instead of sending them. Offsets are not saved.")
+	c.format = c.fs.String("format", "", "The format of the printed points, line or json.")
+	return c
+}
 
-	// Configuring to use file for logging if needed.
-	if cfg.LogFilePath != "" {
-		output, err = logger.ConfigureWithFile(cfg.LogFilePath, cfg.LogLevels)
-		if err != nil {
-			return nil, fmt.Errorf("failed to setup file %s for logging: %v", cfg.LogFilePath, err)
-		}
-		fmt.Printf("sending logs to %s", cfg.LogFilePath)
-		return output, nil
+// set returns the names of all flags set on the command line.
+func (c *configFlags) set() map[string]bool {
+	set := make(map[string]bool)
+	c.fs.Visit(func(f *pflag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// load loads the configuration file located the path provided on the commandline and
+// and augments based on any environment variables and other commandline arguments. The
+// precedence from lowest to highest is defaults, configuration file, environment variables
+// and then commandline arguments. An error is returned if the resulting configuration
+// is invalid.
+func (c *configFlags) load() (config.Config, error) {
+	cfg, err := c.build()
+	if err != nil {
+		return cfg, err
 	}
-	logger.UpdateWithLevelList(os.Stdout, cfg.LogLevels)
-	return output, nil
+	if errs := validateConfig(cfg); len(errs) > 0 {
+		return cfg, errs[0]
+	}
+
+	return cfg, nil
+}
+
+// validateConfig returns every problem with the settings of cfg that would
+// stop slurp-rtl_433 from running. It is shared by load and check-config so
+// the two agree.
+func validateConfig(cfg config.Config) []error {
+	errs := []error{}
+	if err := dump.ValidFormat(cfg.DryRunFormat); err != nil {
+		errs = append(errs, err)
+	}
+	if err := dump.ValidSchema(cfg.InfluxDB.Schema); err != nil {
+		errs = append(errs, err)
+	}
+	if err := units.ValidSystem(cfg.Units); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.LogFileCheckTimeSeconds < 1 {
+		errs = append(errs, fmt.Errorf("logFileCheckTimeSeconds must be at least 1, got %d", cfg.LogFileCheckTimeSeconds))
+	}
+	if cfg.StateSaveSeconds < 1 {
+		errs = append(errs, fmt.Errorf("stateSaveSeconds must be at least 1, got %d", cfg.StateSaveSeconds))
+	}
+	return errs
 }
 
-// loadConfig loads the configuration file located the path provided on the commandline and
-// and augments based on any other commandline arguments. All commandline arguments supersede
-// the value found in the configuration file.
-func loadConfig() (config.Config, error) {
+// build builds the configuration in the same way as load without validating
+// it, so every problem can be reported by check-config.
+func (c *configFlags) build() (config.Config, error) {
 	var err error
+	set := c.set()
 
 	// Creating an default config or loading the config from file.
 	cfg := config.NewConfig()
-	if *cPath != "⠀" {
-		logger.Verbose.Printf("loading config from %s", *cPath)
-		cfg, err = config.LoadConfigFromFile(*cPath)
+	if set["config"] {
+		logger.Verbose.Printf("loading config from %s", *c.path)
+		cfg, err = config.LoadConfigFromFile(*c.path)
 		if err != nil {
 			return cfg, err
 		}
 	}
 
+	// Superseding with any environment variables set.
+	if err = config.ApplyEnv(&cfg); err != nil {
+		return cfg, err
+	}
+
 	// Superseding any config options provided.
-	if *cFQDN != "⠀" {
-		cfg.InfluxDB.FQDN = *cFQDN
+	if set["fqdn"] {
+		cfg.InfluxDB.FQDN = *c.fqdn
+	}
+	if set["port"] {
+		cfg.InfluxDB.Port = *c.port
 	}
-	if *cPort != -1 {
-		cfg.InfluxDB.Port = *cPort
+	if set["username"] {
+		cfg.InfluxDB.Username = *c.username
 	}
-	if *cUsername != "⠀" {
-		cfg.InfluxDB.Username = *cUsername
+	if set["password-file"] {
+		cfg.InfluxDB.PasswordFile = *c.passwordFile
+		if err = config.ResolveSecrets(&cfg); err != nil {
+			return cfg, err
+		}
 	}
-	if *cPassword != "⠀" {
-		cfg.InfluxDB.Password = *cPassword
+	if set["password"] {
+		cfg.InfluxDB.Password = *c.password
 	}
-	if *cDatabase != "⠀" {
-		cfg.InfluxDB.Database = *cDatabase
+	if set["database"] {
+		cfg.InfluxDB.Database = *c.database
 	}
-	if *cDataLocation != "⠀" {
-		name, dir, err := config.SplitLogPath(*cDataLocation)
+	if set["data-location"] {
+		name, dir, err := config.SplitLogPath(*c.dataLocation)
 		if err != nil {
 			return cfg, fmt.Errorf("failed to parse data directory")
 
 		}
 		cfg.DataFileDir = dir
 		cfg.DataFileName = name
-		cfg.DataLocation = *cDataLocation
+		cfg.DataLocation = *c.dataLocation
+	}
+	if set["meta-data-location"] {
+		cfg.FileMetaDataPath = *c.metaDataLocation
 	}
-	if *cMetaDataLocation != "⠀" {
-		cfg.FileMetaDataPath = *cMetaDataLocation
+	if set["dry-run"] {
+		cfg.DryRun = *c.dryRun
 	}
-	if *cVerbose {
+	if set["format"] {
+		cfg.DryRunFormat = *c.format
+	}
+	cfg.Units = strings.ToLower(cfg.Units)
+	if *c.verbose {
 		cfg.LogLevels = append(cfg.LogLevels, "verbose")
 	}
-	if *cDebug {
+	if *c.debug {
 		cfg.LogLevels = append(cfg.LogLevels, "debug")
 	}
 
 	return cfg, nil
 }
+
+// buildLogger creates new loggers based on the parameters found in the current
+// configuration. If this never called the default is to log all levels out
+// to stdout.
+func buildLogger(cfg config.Config) (*os.File, error) {
+	var output *os.File
+	var err error
+
+	if err = logger.SetFormat(cfg.LogFormat); err != nil {
+		return nil, err
+	}
+	if err = logger.SetComponentLevels(cfg.ComponentLogLevels); err != nil {
+		return nil, err
+	}
+	logger.SetRateLimit(time.Duration(cfg.LogRateLimitSeconds * float64(time.Second)))
+
+	// Configuring to use file for logging if needed.
+	if cfg.LogFilePath != "" {
+		output, err = logger.ConfigureWithFile(cfg.LogFilePath, cfg.LogLevels)
+		if err != nil {
+			return nil, fmt.Errorf("failed to setup file %s for logging: %v", cfg.LogFilePath, err)
+		}
+		fmt.Fprintf(os.Stderr, "sending logs to %s\n", cfg.LogFilePath)
+		return output, nil
+	}
+
+	// Points are printed to stdout during a dry run so logs are kept out of
+	// the way.
+	if cfg.DryRun {
+		logger.UpdateWithLevelList(os.Stderr, cfg.LogLevels)
+		return output, nil
+	}
+	logger.UpdateWithLevelList(os.Stdout, cfg.LogLevels)
+	return output, nil
+}
@@ -0,0 +1,140 @@
+// Package match provides the value matching used by rules that select
+// readings by the value of a field.
+//
+// A pattern is one of the following:
+//
+//	/expr/     The value matches the regular expression expr.
+//	min..max   The value is a number from min to max inclusive. Either end
+//	           may be left off to leave it unbounded, such as 10.. or ..-5.
+//	value      The value equals value. Numbers are compared numerically so
+//	           20 matches 20.0.
+package match
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A Matcher reports if a field value matches a pattern.
+type Matcher interface {
+	// Match returns true if the value matches.
+	Match(value string) bool
+
+	// String returns the pattern the Matcher was parsed from.
+	String() string
+}
+
+// rangeRE matches a numeric range pattern.
+var rangeRE = regexp.MustCompile(`^\s*(-?[0-9.]+(?:[eE][-+]?[0-9]+)?)?\s*\.\.\s*(-?[0-9.]+(?:[eE][-+]?[0-9]+)?)?\s*$`)
+
+// Parse parses the pattern into a Matcher.
+func Parse(pattern string) (Matcher, error) {
+	if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %s: %s", pattern, err)
+		}
+		return regexpMatcher{pattern, re}, nil
+	}
+
+	if m := rangeRE.FindStringSubmatch(pattern); m != nil && (m[1] != "" || m[2] != "") {
+		r := rangeMatcher{pattern: pattern}
+		var err error
+		if m[1] != "" {
+			if r.min, err = strconv.ParseFloat(m[1], 64); err != nil {
+				return nil, fmt.Errorf("invalid range %s: %s", pattern, err)
+			}
+			r.hasMin = true
+		}
+		if m[2] != "" {
+			if r.max, err = strconv.ParseFloat(m[2], 64); err != nil {
+				return nil, fmt.Errorf("invalid range %s: %s", pattern, err)
+			}
+			r.hasMax = true
+		}
+		if r.hasMin && r.hasMax && r.min > r.max {
+			return nil, fmt.Errorf("invalid range %s: minimum is larger than maximum", pattern)
+		}
+		return r, nil
+	}
+
+	return exactMatcher(pattern), nil
+}
+
+// Value formats a value decoded from JSON for matching. Numbers should be
+// decoded as json.Number to keep them as written.
+func Value(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// exactMatcher matches a single value.
+type exactMatcher string
+
+// Match returns true if the value equals the pattern as a string or number.
+func (e exactMatcher) Match(value string) bool {
+	if value == string(e) {
+		return true
+	}
+	a, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return false
+	}
+	b, err := strconv.ParseFloat(string(e), 64)
+	return err == nil && a == b
+}
+
+// String returns the pattern.
+func (e exactMatcher) String() string {
+	return string(e)
+}
+
+// regexpMatcher matches a regular expression.
+type regexpMatcher struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// Match returns true if the regular expression matches the value.
+func (r regexpMatcher) Match(value string) bool {
+	return r.re.MatchString(value)
+}
+
+// String returns the pattern.
+func (r regexpMatcher) String() string {
+	return r.pattern
+}
+
+// rangeMatcher matches a numeric range.
+type rangeMatcher struct {
+	pattern string
+	min     float64
+	hasMin  bool
+	max     float64
+	hasMax  bool
+}
+
+// Match returns true if the value is a number within the range.
+func (r rangeMatcher) Match(value string) bool {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return false
+	}
+	return (!r.hasMin || v >= r.min) && (!r.hasMax || v <= r.max)
+}
+
+// String returns the pattern.
+func (r rangeMatcher) String() string {
+	return r.pattern
+}
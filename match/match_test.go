@@ -0,0 +1,61 @@
+package match
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		match   bool
+	}{
+		{"Acurite tower sensor", "Acurite tower sensor", true},
+		{"Acurite tower sensor", "Acurite 986 Sensor", false},
+		{"20", "20.0", true},
+		{"20", "21", false},
+		{"A", "a", false},
+		{"/^Acurite/", "Acurite 986 Sensor", true},
+		{"/^Acurite/", "LaCrosse TX", false},
+		{"/^[AB]$/", "B", true},
+		{"10..20", "10", true},
+		{"10..20", "20", true},
+		{"10..20", "20.5", false},
+		{"10..20", "abc", false},
+		{"..-5", "-10", true},
+		{"..-5", "0", false},
+		{"-5..", "-5", true},
+		{"1e3..", "1000", true},
+	}
+	for _, test := range tests {
+		m, err := Parse(test.pattern)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %s", test.pattern, err)
+		}
+		if m.Match(test.value) != test.match {
+			t.Errorf("%s matching %s: expected %v", test.pattern, test.value, test.match)
+		}
+		if m.String() != test.pattern {
+			t.Errorf("expected pattern %s, got %s", test.pattern, m.String())
+		}
+	}
+
+	for _, pattern := range []string{"/[/", "20..10"} {
+		if _, err := Parse(pattern); err == nil {
+			t.Errorf("expected %s to fail to parse", pattern)
+		}
+	}
+}
+
+func TestValue(t *testing.T) {
+	if v := Value(json.Number("1.50")); v != "1.50" {
+		t.Errorf("expected number as written, got %s", v)
+	}
+	if v := Value(true); v != "true" {
+		t.Errorf("expected true, got %s", v)
+	}
+	if v := Value(nil); v != "" {
+		t.Errorf("expected empty string for nil, got %s", v)
+	}
+}
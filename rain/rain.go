@@ -0,0 +1,356 @@
+// Package rain turns the cumulative rain counters reported by rain gauges
+// into the rain fallen since the previous reading, the rain rate and the
+// totals of the last hour and the day.
+//
+// The last counter of each sensor is kept along with the rain of the last
+// hour and the day and saved in the meta data directory so the values carry
+// on across restarts. A counter that goes down, as it does when the batteries
+// are changed, is taken as a reset and the new counter as the rain since. The
+// times of the readings are used rather than the time they are processed, so
+// the day ends at midnight in the time zone rtl_433 writes times in.
+package rain
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	influx "github.com/influxdata/influxdb/client/v2"
+	"github.com/jrmycanady/slurp-rtl_433/config"
+	"github.com/jrmycanady/slurp-rtl_433/device"
+	"github.com/jrmycanady/slurp-rtl_433/file"
+	"github.com/jrmycanady/slurp-rtl_433/logger"
+	"github.com/jrmycanady/slurp-rtl_433/units"
+)
+
+// log is the logger for the rain package.
+var log = logger.For("rain")
+
+const (
+	// FileName is the name of the file within the meta data directory
+	// holding the state of the rain counters.
+	FileName = "rain.json"
+
+	// stateVersion is the version of the rain file format.
+	stateVersion = 1
+
+	// DefaultRateWindowSeconds is the window the rain rate is computed over
+	// if not configured.
+	DefaultRateWindowSeconds = 900
+
+	// DefaultMaxGapSeconds is the longest gap in reception the rain is
+	// counted toward the rate and hourly total after if not configured.
+	DefaultMaxGapSeconds = 3600
+
+	// dayLayout formats the day of a reading.
+	dayLayout = "2006-01-02"
+)
+
+// The base names of the fields added. The unit of the counter is appended
+// to each, with _h for the rate, such as rain_rate_mm_h.
+const (
+	DeltaField = "rain_delta"
+	RateField  = "rain_rate"
+	HourField  = "rain_1h"
+	TodayField = "rain_today"
+)
+
+// counterFields are the base names of the counter fields looked for if not
+// configured, in order of preference.
+var counterFields = []string{"rainfall_accumulation", "rain"}
+
+// A Tracker keeps the last counter of every rain sensor. It is safe for
+// concurrent use.
+type Tracker struct {
+	file *file.JSONFile
+
+	// lock protects sensors.
+	lock    *sync.Mutex
+	sensors map[string]*Sensor
+}
+
+// Sensor is the state of the rain counter of a sensor.
+type Sensor struct {
+	Key   string `json:"key"`
+	Field string `json:"field"`
+	Unit  string `json:"unit"`
+
+	// Counter is the last counter read at Time.
+	Counter float64   `json:"counter"`
+	Time    time.Time `json:"time"`
+
+	// Today is the rain fallen on Day.
+	Day   string  `json:"day"`
+	Today float64 `json:"today"`
+
+	// Recent is the rain of each reading within the longest window needed.
+	Recent []Amount `json:"recent"`
+}
+
+// Amount is the rain reported by a reading.
+type Amount struct {
+	Time time.Time `json:"time"`
+	Rain float64   `json:"rain"`
+}
+
+// stateFile is the on disk format of the rain file.
+type stateFile struct {
+	Version int       `json:"version"`
+	Sensors []*Sensor `json:"sensors"`
+}
+
+// NewTracker creates an empty Tracker saved in dir. Nothing is written if
+// readOnly is true. An empty dir keeps the state in memory only.
+func NewTracker(dir string, readOnly bool) *Tracker {
+	return &Tracker{
+		file:    file.NewJSONFile(dir, FileName, readOnly),
+		lock:    &sync.Mutex{},
+		sensors: make(map[string]*Sensor),
+	}
+}
+
+// Validate returns an error if the configuration is invalid.
+func Validate(cfg config.RainConfig) error {
+	if cfg.RateWindowSeconds < 0 {
+		return fmt.Errorf("rateWindowSeconds must not be negative")
+	}
+	if cfg.MaxGapSeconds < 0 {
+		return fmt.Errorf("maxGapSeconds must not be negative")
+	}
+	return nil
+}
+
+// Path returns the path of the rain file.
+func (t *Tracker) Path() string {
+	return t.file.Path()
+}
+
+// Load reads the rain file replacing the state of any sensors already known.
+// A missing file is not an error.
+func (t *Tracker) Load() error {
+	sf := stateFile{}
+	if ok, err := t.file.Load(&sf); !ok {
+		return err
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.sensors = make(map[string]*Sensor)
+	for _, s := range sf.Sensors {
+		t.sensors[s.Key] = s
+	}
+
+	return nil
+}
+
+// Dirty returns true if a counter has changed since the last write.
+func (t *Tracker) Dirty() bool {
+	return t.file.Dirty()
+}
+
+// Save writes the state of all sensors to the rain file.
+func (t *Tracker) Save() error {
+	return t.file.Save(t.snapshot)
+}
+
+// snapshot returns a copy of the state of all sensors in the file format.
+func (t *Tracker) snapshot() interface{} {
+	t.lock.Lock()
+	sf := stateFile{Version: stateVersion, Sensors: make([]*Sensor, 0, len(t.sensors))}
+	for _, s := range t.sensors {
+		c := *s
+		c.Recent = append([]Amount{}, s.Recent...)
+		sf.Sensors = append(sf.Sensors, &c)
+	}
+	t.lock.Unlock()
+	sort.Slice(sf.Sensors, func(i, j int) bool { return sf.Sensors[i].Key < sf.Sensors[j].Key })
+
+	return sf
+}
+
+// Observe adds the rain fallen since the previous reading of the sensor, the
+// rain rate and the totals of the last hour and the day to the point if Rain
+// is configured for the model. The point is returned as is if the model is
+// not configured, the counter is missing or the reading is older than the
+// last one of the sensor. The first reading of a sensor has no previous
+// counter so only the totals are added. Observe may be called on a nil
+// Tracker, which returns p.
+func (t *Tracker) Observe(cfgs map[string]config.RainConfig, model string, p *influx.Point) (*influx.Point, error) {
+	if t == nil {
+		return p, nil
+	}
+	cfg, ok := cfgs[model]
+	if !ok {
+		return p, nil
+	}
+	fields, err := p.Fields()
+	if err != nil {
+		return p, fmt.Errorf("failed to read point fields: %s", err)
+	}
+	name, counter, ok := counterField(cfg.Field, fields)
+	if !ok {
+		return p, nil
+	}
+
+	if !t.update(cfg, model, p.Tags(), name, counter, p.Time(), fields) {
+		return p, nil
+	}
+	np, err := influx.NewPoint(p.Name(), p.Tags(), fields, p.Time())
+	if err != nil {
+		return p, fmt.Errorf("failed to create point: %s", err)
+	}
+	return np, nil
+}
+
+// update records the counter of the sensor read at tm and adds the rain
+// fields. False is returned if the reading is older than the last one.
+func (t *Tracker) update(cfg config.RainConfig, model string, tags map[string]string, name string, counter float64, tm time.Time, fields map[string]interface{}) bool {
+	window := time.Duration(cfg.RateWindowSeconds) * time.Second
+	if window <= 0 {
+		window = DefaultRateWindowSeconds * time.Second
+	}
+	maxGap := time.Duration(cfg.MaxGapSeconds) * time.Second
+	if maxGap <= 0 {
+		maxGap = DefaultMaxGapSeconds * time.Second
+	}
+	unit := ""
+	if f, ok := units.Parse(name); ok {
+		unit = f.Unit
+	}
+	key := device.DeviceKey(tags)
+	day := tm.Format(dayLayout)
+	l := log.With("model", model, "sensor", key)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s, known := t.sensors[key]
+	if known && s.Field != name && !s.convert(name, unit) {
+		l.Info.Printf("rain counter changed from %s to %s, starting over", s.Field, name)
+		known = false
+	}
+	if !known {
+		s = &Sensor{Key: key, Field: name, Unit: unit, Counter: counter, Time: tm, Day: day}
+		t.sensors[key] = s
+	} else if tm.Before(s.Time) {
+		l.Debug.Printf("ignoring rain counter read at %s before the last reading at %s", tm, s.Time)
+		return false
+	}
+
+	if s.Day != day {
+		s.Day = day
+		s.Today = 0
+	}
+	if known {
+		delta := counter - s.Counter
+		if delta < 0 {
+			l.Info.Printf("rain counter went from %v to %v, taking it as reset", s.Counter, counter)
+			delta = counter
+		}
+		delta = units.Round(delta)
+		gap := tm.Sub(s.Time)
+		if gap <= maxGap || s.Time.Format(dayLayout) == day {
+			s.Today = units.Round(s.Today + delta)
+		}
+		if gap <= maxGap && delta > 0 {
+			s.Recent = append(s.Recent, Amount{Time: tm, Rain: delta})
+		}
+		fields[fieldName(DeltaField, unit)] = delta
+	}
+	s.Counter = counter
+	s.Time = tm
+
+	keep := window
+	if keep < time.Hour {
+		keep = time.Hour
+	}
+	s.Recent = since(s.Recent, tm.Add(-keep))
+	fields[fieldName(RateField, rateUnit(unit))] = units.Round(total(since(s.Recent, tm.Add(-window))) * float64(time.Hour) / float64(window))
+	fields[fieldName(HourField, unit)] = units.Round(total(since(s.Recent, tm.Add(-time.Hour))))
+	fields[fieldName(TodayField, unit)] = s.Today
+	t.file.MarkDirty()
+
+	return true
+}
+
+// convert moves the state of the sensor to the counter field name in unit,
+// as when the unit system is changed. False is returned if the units can not
+// be converted.
+func (s *Sensor) convert(name string, unit string) bool {
+	to := func(v float64) (float64, bool) {
+		c, err := units.Convert(v, s.Unit, unit)
+		return c, err == nil
+	}
+	counter, ok := to(s.Counter)
+	if !ok {
+		return false
+	}
+	today, _ := to(s.Today)
+	recent := make([]Amount, 0, len(s.Recent))
+	for _, a := range s.Recent {
+		v, _ := to(a.Rain)
+		recent = append(recent, Amount{Time: a.Time, Rain: v})
+	}
+
+	s.Field, s.Unit, s.Counter, s.Today, s.Recent = name, unit, counter, today, recent
+	return true
+}
+
+// counterField returns the name and value of the numeric counter field named
+// name or, if name is empty, the first field with the base name of a counter
+// and a length unit.
+func counterField(name string, fields map[string]interface{}) (string, float64, bool) {
+	if name != "" {
+		v, ok := units.Number(fields[name])
+		return name, v, ok
+	}
+
+	names := make([]string, 0, len(fields))
+	for n := range fields {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, base := range counterFields {
+		for _, n := range names {
+			f, ok := units.Parse(n)
+			if !ok || f.Raw || f.Quantity != units.Length || f.Base != base {
+				continue
+			}
+			if v, ok := units.Number(fields[n]); ok {
+				return n, v, true
+			}
+		}
+	}
+	return "", 0, false
+}
+
+// rateUnit returns the suffix of the rate unit of the length unit.
+func rateUnit(unit string) string {
+	if unit == "" {
+		return ""
+	}
+	return unit + "_h"
+}
+
+// fieldName returns the base name with the unit suffix.
+func fieldName(base string, unit string) string {
+	if unit == "" {
+		return base
+	}
+	return base + "_" + unit
+}
+
+// since returns the amounts after t.
+func since(amounts []Amount, t time.Time) []Amount {
+	i := sort.Search(len(amounts), func(i int) bool { return amounts[i].Time.After(t) })
+	return amounts[i:]
+}
+
+// total returns the sum of the amounts.
+func total(amounts []Amount) float64 {
+	sum := 0.0
+	for _, a := range amounts {
+		sum += a.Rain
+	}
+	return sum
+}
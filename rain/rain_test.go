@@ -0,0 +1,137 @@
+package rain
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	influx "github.com/influxdata/influxdb/client/v2"
+	"github.com/jrmycanady/slurp-rtl_433/config"
+)
+
+const (
+	fiveInOne = "Acurite 5n1 sensor"
+	inches    = "rainfall_accumulation_inch"
+)
+
+// reading is a counter read at an offset from the start of a test.
+type reading struct {
+	after   time.Duration
+	field   string
+	counter float64
+}
+
+// start is an hour before midnight so the tests can cross it.
+var start = time.Date(2026, 5, 1, 23, 0, 0, 0, time.UTC)
+
+// observe observes each reading in order and returns the fields of the last.
+func observe(t *testing.T, tr *Tracker, readings []reading) map[string]interface{} {
+	var fields map[string]interface{}
+	for _, r := range readings {
+		field := r.field
+		if field == "" {
+			field = inches
+		}
+		p, err := influx.NewPoint("AcuRite5n1Sensor", map[string]string{"model": fiveInOne, "sensor_id": "1234"}, map[string]interface{}{field: r.counter}, start.Add(r.after))
+		if err != nil {
+			t.Fatalf("failed to create point: %s", err)
+		}
+		p, err = tr.Observe(map[string]config.RainConfig{fiveInOne: {RateWindowSeconds: 600}}, fiveInOne, p)
+		if err != nil {
+			t.Fatalf("failed to observe: %s", err)
+		}
+		fields, _ = p.Fields()
+	}
+	return fields
+}
+
+func TestObserve(t *testing.T) {
+	rained := []reading{{0, "", 10}, {5 * time.Minute, "", 10.1}}
+	tests := []struct {
+		name     string
+		readings []reading
+		want     map[string]interface{}
+	}{
+		{
+			name:     "first reading",
+			readings: rained[:1],
+			want:     map[string]interface{}{inches: 10.0, "rain_rate_inch_h": 0.0, "rain_1h_inch": 0.0, "rain_today_inch": 0.0},
+		},
+		{
+			name:     "rain",
+			readings: rained,
+			want:     map[string]interface{}{inches: 10.1, "rain_delta_inch": 0.1, "rain_rate_inch_h": 0.6, "rain_1h_inch": 0.1, "rain_today_inch": 0.1},
+		},
+		{
+			name:     "reset to a lower counter",
+			readings: append(rained, reading{20 * time.Minute, "", 0.05}),
+			want:     map[string]interface{}{inches: 0.05, "rain_delta_inch": 0.05, "rain_rate_inch_h": 0.3, "rain_1h_inch": 0.15, "rain_today_inch": 0.15},
+		},
+		{
+			name:     "reset to zero",
+			readings: append(rained, reading{10 * time.Minute, "", 0}),
+			want:     map[string]interface{}{inches: 0.0, "rain_delta_inch": 0.0, "rain_rate_inch_h": 0.6, "rain_1h_inch": 0.1, "rain_today_inch": 0.1},
+		},
+		{
+			name:     "duplicate packets",
+			readings: append(rained, rained[1], rained[1]),
+			want:     map[string]interface{}{inches: 10.1, "rain_delta_inch": 0.0, "rain_rate_inch_h": 0.6, "rain_1h_inch": 0.1, "rain_today_inch": 0.1},
+		},
+		{
+			name:     "older reading",
+			readings: append(rained, reading{0, "", 9}),
+			want:     map[string]interface{}{inches: 9.0},
+		},
+		{
+			name:     "midnight",
+			readings: append(rained, reading{65 * time.Minute, "", 10.2}),
+			want:     map[string]interface{}{inches: 10.2, "rain_delta_inch": 0.1, "rain_rate_inch_h": 0.6, "rain_1h_inch": 0.1, "rain_today_inch": 0.1},
+		},
+		{
+			name:     "gap within the day",
+			readings: []reading{{-3 * time.Hour, "", 10}, {-time.Hour, "", 10.4}},
+			want:     map[string]interface{}{inches: 10.4, "rain_delta_inch": 0.4, "rain_rate_inch_h": 0.0, "rain_1h_inch": 0.0, "rain_today_inch": 0.4},
+		},
+		{
+			name:     "gap across midnight",
+			readings: append(rained, reading{2 * time.Hour, "", 10.5}),
+			want:     map[string]interface{}{inches: 10.5, "rain_delta_inch": 0.4, "rain_rate_inch_h": 0.0, "rain_1h_inch": 0.0, "rain_today_inch": 0.0},
+		},
+		{
+			name:     "change of unit",
+			readings: append(rained, reading{6 * time.Minute, "rainfall_accumulation_mm", 259.08}),
+			want:     map[string]interface{}{"rainfall_accumulation_mm": 259.08, "rain_delta_mm": 2.54, "rain_rate_mm_h": 30.48, "rain_1h_mm": 5.08, "rain_today_mm": 5.08},
+		},
+	}
+	for _, tt := range tests {
+		if fields := observe(t, NewTracker("", true), tt.readings); !reflect.DeepEqual(fields, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, fields)
+		}
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	tr := NewTracker(dir, false)
+	observe(t, tr, []reading{{0, "", 10}, {5 * time.Minute, "", 10.1}})
+	if err := tr.Save(); err != nil {
+		t.Fatalf("failed to save: %s", err)
+	}
+
+	tr = NewTracker(dir, false)
+	if err := tr.Load(); err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+	fields := observe(t, tr, []reading{{10 * time.Minute, "", 10.2}})
+	if fields["rain_delta_inch"] != 0.1 || fields["rain_1h_inch"] != 0.2 || fields["rain_today_inch"] != 0.2 {
+		t.Errorf("expected the counter to carry on after loading, got %v", fields)
+	}
+}
+
+func TestObserveUnconfigured(t *testing.T) {
+	var tr *Tracker
+	p, _ := influx.NewPoint("AcuRite5n1Sensor", map[string]string{"model": fiveInOne}, map[string]interface{}{inches: 1.0}, time.Now())
+	if np, err := tr.Observe(nil, fiveInOne, p); np != p || err != nil {
+		t.Errorf("expected the point as is, got %s %v", np, err)
+	}
+}
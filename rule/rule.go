@@ -0,0 +1,436 @@
+// Package rule provides the condition language used by Meta rules to decide
+// if a reading gets extra tags.
+//
+// A condition compares the tags and fields of a reading:
+//
+//	id == 1234 && channel != "C"
+//	temperature_C < -5 or (battery_low == 1 and not has(humidity))
+//	model =~ /^Acurite/ && time_of_day >= 22:00
+//
+// The comparison operators are ==, !=, <, <=, >, >=, =~ (regex matches) and
+// !~ (regex does not match). Conditions are combined with && (and), || (or)
+// and ! (not) and grouped with parentheses. Values may be quoted with double
+// or single quotes, written as /regex/ or left bare. Numbers are compared
+// numerically and clock times such as 6:30 or 22:00 by time of day,
+// otherwise values are compared as strings.
+//
+// A comparison against a key the reading does not have is always false,
+// including !=. Use has(key) or missing(key) to test for a key.
+//
+// The time of the reading is available as time_of_day (15:04), hour (0-23)
+// and weekday (Mon-Sun).
+package rule
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// An Env provides the values a Rule is evaluated against.
+type Env interface {
+	// Lookup returns the value of the key and true if it exists.
+	Lookup(key string) (string, bool)
+}
+
+// A Rule is a parsed condition.
+type Rule struct {
+	source string
+	root   node
+}
+
+// Parse parses the condition into a Rule.
+func Parse(condition string) (*Rule, error) {
+	tokens, err := tokenize(condition)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty condition")
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected %s at position %d", p.peek().text, p.peek().pos)
+	}
+
+	return &Rule{source: condition, root: root}, nil
+}
+
+// Eval returns true if the condition holds for the values in env.
+func (r *Rule) Eval(env Env) bool {
+	return r.root.eval(env)
+}
+
+// String returns the condition the Rule was parsed from.
+func (r *Rule) String() string {
+	return r.source
+}
+
+// Keys returns the keys the condition refers to in the order they appear.
+func (r *Rule) Keys() []string {
+	keys := make([]string, 0)
+	seen := make(map[string]bool)
+	r.root.keys(func(k string) {
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	})
+	return keys
+}
+
+// node is a part of a parsed condition.
+type node interface {
+	eval(env Env) bool
+	keys(fn func(string))
+}
+
+// andNode holds if both sides hold.
+type andNode struct{ left, right node }
+
+func (n andNode) eval(env Env) bool    { return n.left.eval(env) && n.right.eval(env) }
+func (n andNode) keys(fn func(string)) { n.left.keys(fn); n.right.keys(fn) }
+
+// orNode holds if either side holds.
+type orNode struct{ left, right node }
+
+func (n orNode) eval(env Env) bool    { return n.left.eval(env) || n.right.eval(env) }
+func (n orNode) keys(fn func(string)) { n.left.keys(fn); n.right.keys(fn) }
+
+// notNode holds if the condition does not.
+type notNode struct{ n node }
+
+func (n notNode) eval(env Env) bool    { return !n.n.eval(env) }
+func (n notNode) keys(fn func(string)) { n.n.keys(fn) }
+
+// hasNode holds if the key exists. It's negated for missing(key).
+type hasNode struct {
+	key    string
+	negate bool
+}
+
+func (n hasNode) eval(env Env) bool {
+	_, ok := env.Lookup(n.key)
+	return ok != n.negate
+}
+func (n hasNode) keys(fn func(string)) { fn(n.key) }
+
+// compareNode compares the value of a key to a literal.
+type compareNode struct {
+	key   string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+func (n compareNode) keys(fn func(string)) { fn(n.key) }
+
+func (n compareNode) eval(env Env) bool {
+	v, ok := env.Lookup(n.key)
+	if !ok {
+		return false
+	}
+
+	switch n.op {
+	case "=~":
+		return n.re.MatchString(v)
+	case "!~":
+		return !n.re.MatchString(v)
+	}
+
+	c := compare(v, n.value)
+	switch n.op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// compare compares a and b as numbers if both are numbers, as clock times
+// if both are clock times and otherwise as strings. It returns -1, 0 or 1 as
+// a is less than, equal to or more than b.
+func compare(a string, b string) int {
+	if x, err := strconv.ParseFloat(a, 64); err == nil {
+		if y, err := strconv.ParseFloat(b, 64); err == nil {
+			return cmpFloat(x, y)
+		}
+	}
+	if x, ok := parseClock(a); ok {
+		if y, ok := parseClock(b); ok {
+			return cmpFloat(float64(x), float64(y))
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// cmpFloat returns -1, 0 or 1 as x is less than, equal to or more than y.
+func cmpFloat(x float64, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// clockRE matches a clock time such as 6:30 or 22:00:15.
+var clockRE = regexp.MustCompile(`^(\d{1,2}):(\d{2})(?::(\d{2}))?$`)
+
+// parseClock returns the seconds since midnight of a clock time.
+func parseClock(s string) (int, bool) {
+	m := clockRE.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+	h, _ := strconv.Atoi(m[1])
+	min, _ := strconv.Atoi(m[2])
+	sec := 0
+	if m[3] != "" {
+		sec, _ = strconv.Atoi(m[3])
+	}
+	if h > 23 || min > 59 || sec > 59 {
+		return 0, false
+	}
+	return h*3600 + min*60 + sec, true
+}
+
+// The kinds of token.
+const (
+	tokenWord = iota
+	tokenString
+	tokenRegexp
+	tokenOp
+)
+
+// token is a single token of a condition.
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+// operators are the operator tokens, longest first.
+var operators = []string{"==", "!=", "<=", ">=", "=~", "!~", "&&", "||", "<", ">", "!", "(", ")"}
+
+// tokenize splits the condition into tokens.
+func tokenize(s string) ([]token, error) {
+	tokens := make([]token, 0)
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, token{tokenString, s[i+1 : i+1+end], i})
+			i += end + 2
+		case c == '/':
+			end := strings.IndexByte(s[i+1:], '/')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated regular expression at position %d", i)
+			}
+			tokens = append(tokens, token{tokenRegexp, s[i+1 : i+1+end], i})
+			i += end + 2
+		case isWordByte(c):
+			start := i
+			for i < len(s) && isWordByte(s[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenWord, s[start:i], start})
+		default:
+			found := false
+			for _, op := range operators {
+				if strings.HasPrefix(s[i:], op) {
+					tokens = append(tokens, token{tokenOp, op, i})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected %q at position %d", c, i)
+			}
+		}
+	}
+
+	return tokens, nil
+}
+
+// isWordByte returns true if c may be part of a bare word.
+func isWordByte(c byte) bool {
+	return c < unicode.MaxASCII && (unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)) || strings.IndexByte("_.:-+", c) >= 0)
+}
+
+// parser builds the nodes of a condition from its tokens.
+type parser struct {
+	tokens []token
+	i      int
+}
+
+func (p *parser) done() bool  { return p.i >= len(p.tokens) }
+func (p *parser) peek() token { return p.tokens[p.i] }
+func (p *parser) next() token { p.i++; return p.tokens[p.i-1] }
+
+// accept consumes the next token if it's an operator or keyword in words.
+func (p *parser) accept(words ...string) bool {
+	if p.done() {
+		return false
+	}
+	t := p.peek()
+	if t.kind != tokenOp && t.kind != tokenWord {
+		return false
+	}
+	for _, w := range words {
+		if strings.EqualFold(t.text, w) && (t.kind == tokenOp) == !isKeyword(w) {
+			p.i++
+			return true
+		}
+	}
+	return false
+}
+
+// isKeyword returns true if w is a word rather than an operator.
+func isKeyword(w string) bool {
+	return unicode.IsLetter(rune(w[0]))
+}
+
+// expect returns an error if the next token is not the operator op.
+func (p *parser) expect(op string) error {
+	if p.done() {
+		return fmt.Errorf("expected %s at end of condition", op)
+	}
+	if t := p.next(); t.kind != tokenOp || t.text != op {
+		return fmt.Errorf("expected %s at position %d, found %s", op, t.pos, t.text)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||", "or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&", "and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.accept("!", "not") {
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	if p.done() {
+		return nil, fmt.Errorf("unexpected end of condition")
+	}
+	if p.accept("(") {
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	}
+
+	t := p.next()
+	if t.kind != tokenWord || isReserved(t.text) {
+		return nil, fmt.Errorf("expected a key at position %d, found %s", t.pos, t.text)
+	}
+
+	// has(key) and missing(key) test for the key.
+	if fn := strings.ToLower(t.text); (fn == "has" || fn == "missing") && !p.done() && p.peek().text == "(" {
+		p.next()
+		if p.done() || p.peek().kind != tokenWord {
+			return nil, fmt.Errorf("expected a key in %s() at position %d", fn, t.pos)
+		}
+		key := p.next().text
+		return hasNode{key: key, negate: fn == "missing"}, p.expect(")")
+	}
+
+	if p.done() || p.peek().kind != tokenOp {
+		return nil, fmt.Errorf("expected a comparison after %s at position %d", t.text, t.pos)
+	}
+	op := p.next()
+	switch op.text {
+	case "==", "!=", "<", "<=", ">", ">=", "=~", "!~":
+	default:
+		return nil, fmt.Errorf("expected a comparison after %s at position %d, found %s", t.text, op.pos, op.text)
+	}
+
+	if p.done() {
+		return nil, fmt.Errorf("expected a value after %s at position %d", op.text, op.pos)
+	}
+	v := p.next()
+	if v.kind == tokenOp || (v.kind == tokenWord && isReserved(v.text)) {
+		return nil, fmt.Errorf("expected a value after %s at position %d, found %s", op.text, v.pos, v.text)
+	}
+	n := compareNode{key: t.text, op: op.text, value: v.text}
+	if op.text == "=~" || op.text == "!~" {
+		re, err := regexp.Compile(v.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression at position %d: %s", v.pos, err)
+		}
+		n.re = re
+	} else if v.kind == tokenRegexp {
+		return nil, fmt.Errorf("regular expression at position %d requires =~ or !~", v.pos)
+	}
+
+	return n, nil
+}
+
+// isReserved returns true if w is a keyword that can not be a key or bare
+// value.
+func isReserved(w string) bool {
+	switch strings.ToLower(w) {
+	case "and", "or", "not":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package rule
+
+import "testing"
+
+// env is a map backed Env.
+type env map[string]string
+
+func (e env) Lookup(key string) (string, bool) {
+	v, ok := e[key]
+	return v, ok
+}
+
+func TestEval(t *testing.T) {
+	e := env{
+		"model":         "Acurite tower sensor",
+		"id":            "1234",
+		"channel":       "A",
+		"temperature_C": "-7.5",
+		"time_of_day":   "23:15",
+	}
+	tests := []struct {
+		condition string
+		want      bool
+	}{
+		{`id == 1234`, true},
+		{`id == 1234.0`, true},
+		{`id != 1234`, false},
+		{`channel == "A" && id == 1234`, true},
+		{`channel == A and id == 99`, false},
+		{`channel == B || id == 1234`, true},
+		{`!(channel == B)`, true},
+		{`not channel == A`, false},
+		{`temperature_C < -5`, true},
+		{`temperature_C>=-5`, false},
+		{`model =~ /^Acurite/`, true},
+		{`model !~ "tower"`, false},
+		{`model > "A"`, true},
+		{`time_of_day >= 22:00 || time_of_day < 6:00`, true},
+		{`time_of_day < 9:30`, false},
+
+		// Comparisons against missing keys never hold.
+		{`humidity == 40`, false},
+		{`humidity != 40`, false},
+		{`!(humidity == 40)`, true},
+		{`has(humidity)`, false},
+		{`missing(humidity) && has(channel)`, true},
+
+		// && binds tighter than ||.
+		{`id == 1 && channel == A || channel == A`, true},
+		{`id == 1 && (channel == A || channel == A)`, false},
+	}
+	for _, test := range tests {
+		r, err := Parse(test.condition)
+		if err != nil {
+			t.Errorf("failed to parse %s: %s", test.condition, err)
+			continue
+		}
+		if got := r.Eval(e); got != test.want {
+			t.Errorf("%s: expected %v, got %v", test.condition, test.want, got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, condition := range []string{
+		``,
+		`id ==`,
+		`id 1234`,
+		`(id == 1`,
+		`id == 1 &&`,
+		`model =~ /[/`,
+		`model == /x/`,
+		`channel == "A`,
+		`id == 1 id == 2`,
+		`and == 1`,
+		`id = 1`,
+	} {
+		if _, err := Parse(condition); err == nil {
+			t.Errorf("expected %q to fail to parse", condition)
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	r, err := Parse(`id == 1 && (channel == A || missing(humidity)) && id != 2`)
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	keys := r.Keys()
+	if len(keys) != 3 || keys[0] != "id" || keys[1] != "channel" || keys[2] != "humidity" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
@@ -0,0 +1,184 @@
+// Package status provides an HTTP server that reports the state of the
+// slurp-rtl_433 pipeline.
+//
+// /healthz reports if the process is alive. It fails if the Filer or Dumper
+// is no longer running.
+//
+// /readyz reports if the pipeline is healthy. In addition to the /healthz
+// checks it fails while the dumper is retrying a failed flush, when points
+// are waiting on an InfluxDB that has not been reached within
+// InfluxDBWindowSeconds, or when no reading has arrived within
+// ReadingWindowSeconds.
+//
+// Both endpoints respond with a JSON body describing the current state and
+// return 200 on success or 503 on failure.
+//
+// /discovered responds with every device heard as JSON. Adding
+// ?unsupported=true limits it to models that are not supported.
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/jrmycanady/slurp-rtl_433/config"
+	"github.com/jrmycanady/slurp-rtl_433/dump"
+	"github.com/jrmycanady/slurp-rtl_433/file"
+	"github.com/jrmycanady/slurp-rtl_433/logger"
+)
+
+// log is the logger for the status package.
+var log = logger.For("status")
+
+// Server is the HTTP server providing the status endpoints.
+type Server struct {
+	cfg    config.StatusConfig
+	filer  *file.Filer
+	dumper *dump.Dumper
+	srv    *http.Server
+	mux    *http.ServeMux
+}
+
+// Report is the body returned by the status endpoints.
+type Report struct {
+	// OK is true if all checks for the endpoint passed.
+	OK bool `json:"ok"`
+
+	// Checks contains the result of each individual check.
+	Checks map[string]bool `json:"checks"`
+
+	// Filer is the current state of the filer.
+	Filer file.FilerStatus `json:"filer"`
+
+	// Dumper is the current state of the dumper.
+	Dumper dump.DumperStatus `json:"dumper"`
+}
+
+// NewServer creates a new status server reporting on the filer and dumper
+// provided.
+func NewServer(cfg config.StatusConfig, f *file.Filer, d *dump.Dumper) *Server {
+	s := &Server{
+		cfg:    cfg,
+		filer:  f,
+		dumper: d,
+		mux:    http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/healthz", s.handleHealthz)
+	s.mux.HandleFunc("/readyz", s.handleReadyz)
+	s.mux.HandleFunc("/discovered", s.handleDiscovered)
+	s.srv = &http.Server{Handler: s.mux}
+
+	return s
+}
+
+// Start begins listening on the configured address. An error is returned if
+// the address could not be bound.
+func (s *Server) Start() error {
+	l, err := net.Listen("tcp", s.cfg.ListenAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %s", s.cfg.ListenAddress, err)
+	}
+
+	go func() {
+		if err := s.srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Error.Printf("status server stopped: %s", err)
+		}
+	}()
+	log.Info.Printf("status server listening on %s", l.Addr())
+
+	return nil
+}
+
+// Stop shuts down the server waiting up to 5 seconds for open requests.
+func (s *Server) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+	defer cancel()
+	if err := s.srv.Shutdown(ctx); err != nil {
+		log.Error.Printf("failed to stop status server: %s", err)
+	}
+}
+
+// Health builds the liveness report.
+func (s *Server) Health() Report {
+	r := Report{
+		Checks: make(map[string]bool),
+		Filer:  s.filer.Status(),
+		Dumper: s.dumper.Status(),
+	}
+	r.Checks["filerRunning"] = r.Filer.Running
+	r.Checks["dumperRunning"] = r.Dumper.Running
+	r.OK = allTrue(r.Checks)
+
+	return r
+}
+
+// Ready builds the readiness report.
+func (s *Server) Ready() Report {
+	r := s.Health()
+	now := time.Now()
+
+	r.Checks["dumperNotRetrying"] = !r.Dumper.Retrying
+
+	// InfluxDB only needs to have been reached recently if there is
+	// something waiting to be sent.
+	r.Checks["influxDBReachable"] = r.Dumper.PendingPoints == 0 ||
+		now.Sub(r.Dumper.LastInfluxDBContact).Seconds() <= s.cfg.InfluxDBWindowSeconds
+
+	// Before the first reading arrives the window starts at dumper start.
+	lastReading := r.Dumper.LastDataPointTime
+	if lastReading.IsZero() {
+		lastReading = r.Dumper.StartTime
+	}
+	r.Checks["readingReceived"] = now.Sub(lastReading).Seconds() <= s.cfg.ReadingWindowSeconds
+
+	r.OK = allTrue(r.Checks)
+
+	return r
+}
+
+// handleHealthz responds with the liveness report.
+func (s *Server) handleHealthz(w http.ResponseWriter, req *http.Request) {
+	writeReport(w, s.Health())
+}
+
+// handleReadyz responds with the readiness report.
+func (s *Server) handleReadyz(w http.ResponseWriter, req *http.Request) {
+	writeReport(w, s.Ready())
+}
+
+// handleDiscovered responds with the devices heard.
+func (s *Server) handleDiscovered(w http.ResponseWriter, req *http.Request) {
+	unsupported := req.URL.Query().Get("unsupported") == "true"
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.filer.Discovered().Devices(unsupported)); err != nil {
+		log.Error.Printf("failed to write discovered devices: %s", err)
+	}
+}
+
+// writeReport writes the report as JSON with a status code based on the
+// result.
+func writeReport(w http.ResponseWriter, r Report) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.OK {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(r); err != nil {
+		log.Error.Printf("failed to write status report: %s", err)
+	}
+}
+
+// allTrue returns true if every check passed.
+func allTrue(checks map[string]bool) bool {
+	for _, ok := range checks {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
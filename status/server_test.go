@@ -0,0 +1,82 @@
+package status
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jrmycanady/slurp-rtl_433/config"
+	"github.com/jrmycanady/slurp-rtl_433/device"
+	"github.com/jrmycanady/slurp-rtl_433/dump"
+	"github.com/jrmycanady/slurp-rtl_433/file"
+)
+
+func TestHealthzNotRunning(t *testing.T) {
+	cfg := config.NewConfig()
+	dpChan := make(chan device.DataPoint)
+	s := NewServer(cfg.Status, file.NewFiler(cfg, dpChan), dump.NewDumper(cfg, dpChan))
+
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	r := Report{}
+	if err := json.NewDecoder(rec.Body).Decode(&r); err != nil {
+		t.Fatalf("failed to decode report: %s", err)
+	}
+	if r.OK || r.Checks["filerRunning"] || r.Checks["dumperRunning"] {
+		t.Fatalf("expected failing checks, got %v", r.Checks)
+	}
+}
+
+func TestReadyzChecks(t *testing.T) {
+	cfg := config.NewConfig()
+	dpChan := make(chan device.DataPoint)
+	d := dump.NewDumper(cfg, dpChan)
+	d.SetRunning(true)
+	s := NewServer(cfg.Status, file.NewFiler(cfg, dpChan), d)
+
+	r := s.Ready()
+	if !r.Checks["dumperRunning"] {
+		t.Fatalf("dumperRunning check failed")
+	}
+	if !r.Checks["dumperNotRetrying"] || !r.Checks["influxDBReachable"] {
+		t.Fatalf("expected dumper checks to pass, got %v", r.Checks)
+	}
+
+	// The dumper was never started so no reading window has begun.
+	if r.Checks["readingReceived"] {
+		t.Fatalf("readingReceived passed without any reading")
+	}
+	if r.OK {
+		t.Fatalf("readiness passed with a stopped filer")
+	}
+}
+
+func TestDiscovered(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.FileMetaDataPath = t.TempDir()
+	dpChan := make(chan device.DataPoint)
+	f := file.NewFiler(cfg, dpChan)
+	f.Discovered().Observe([]byte(`{"model" : "Doorbell", "id" : 1}`), time.Now())
+	f.Discovered().Observe([]byte(`{"model" : "Acurite tower sensor", "id" : 2}`), time.Now())
+	s := NewServer(cfg.Status, f, dump.NewDumper(cfg, dpChan))
+
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, httptest.NewRequest("GET", "/discovered?unsupported=true", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	devices := []file.DiscoveredDevice{}
+	if err := json.NewDecoder(rec.Body).Decode(&devices); err != nil {
+		t.Fatalf("failed to decode devices: %s", err)
+	}
+	if len(devices) != 1 || devices[0].Model != "Doorbell" {
+		t.Fatalf("unexpected devices %+v", devices)
+	}
+}
@@ -0,0 +1,144 @@
+// Package systemd implements the sd_notify protocol used to report readiness,
+// status and watchdog pings to systemd.
+//
+// The protocol is a set of newline separated VAR=VALUE assignments sent as a
+// single datagram to the unix socket found in the NOTIFY_SOCKET environment
+// variable. If NOTIFY_SOCKET is not set all notifications are silently
+// ignored so the same binary may run outside of systemd.
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jrmycanady/slurp-rtl_433/logger"
+)
+
+// log is the logger for the systemd package.
+var log = logger.For("systemd")
+
+const (
+	// Ready tells systemd that startup is complete.
+	Ready = "READY=1"
+
+	// Reloading tells systemd that the service is reloading its
+	// configuration. Ready must be sent once the reload is complete.
+	Reloading = "RELOADING=1"
+
+	// Stopping tells systemd that the service is beginning to shutdown.
+	Stopping = "STOPPING=1"
+
+	// Watchdog tells systemd to update the watchdog timestamp.
+	Watchdog = "WATCHDOG=1"
+)
+
+// A Notifier sends notifications to systemd.
+type Notifier struct {
+	// socketPath is the path of the systemd notification socket. It is empty
+	// if not running under systemd.
+	socketPath string
+
+	// watchdogInterval is the watchdog timeout requested by systemd. It is 0
+	// if the watchdog is not enabled.
+	watchdogInterval time.Duration
+}
+
+// NewNotifier creates a new Notifier configured from the NOTIFY_SOCKET,
+// WATCHDOG_USEC and WATCHDOG_PID environment variables.
+func NewNotifier() *Notifier {
+	n := &Notifier{
+		socketPath: os.Getenv("NOTIFY_SOCKET"),
+	}
+
+	// The watchdog is only for us if WATCHDOG_PID is not set or matches.
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return n
+	}
+	if usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil && usec > 0 {
+		n.watchdogInterval = time.Duration(usec) * time.Microsecond
+	}
+
+	return n
+}
+
+// Enabled returns true if a notification socket was provided by systemd.
+func (n *Notifier) Enabled() bool {
+	return n.socketPath != ""
+}
+
+// WatchdogInterval returns the watchdog timeout requested by systemd or 0 if
+// the watchdog is not enabled.
+func (n *Notifier) WatchdogInterval() time.Duration {
+	return n.watchdogInterval
+}
+
+// Notify sends the state to systemd. It does nothing if the notifier is not
+// enabled.
+func (n *Notifier) Notify(state string) error {
+	if !n.Enabled() {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: n.socketPath, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to notify socket %s: %s", n.socketPath, err)
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to send notification: %s", err)
+	}
+
+	return nil
+}
+
+// Status sends a free form status line to systemd that is shown by
+// systemctl status.
+func (n *Notifier) Status(status string) error {
+	return n.Notify("STATUS=" + status)
+}
+
+// Run sends periodic status updates and watchdog pings until cancelChan is
+// closed. statusFunc builds the STATUS= line and progressFunc reports if the
+// service is making progress. The watchdog is only pinged while progressFunc
+// returns true so a wedged process is restarted by systemd.
+//
+// Pings are sent at half the watchdog interval. If the watchdog is not
+// enabled only status updates are sent every statusInterval.
+func (n *Notifier) Run(cancelChan <-chan struct{}, statusInterval time.Duration, statusFunc func() string, progressFunc func() bool) {
+	if !n.Enabled() {
+		return
+	}
+
+	interval := statusInterval
+	if n.watchdogInterval > 0 {
+		interval = n.watchdogInterval / 2
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := n.Status(statusFunc()); err != nil {
+				log.Error.Printf("failed to send status to systemd: %s", err)
+			}
+
+			if n.watchdogInterval == 0 {
+				continue
+			}
+			if !progressFunc() {
+				log.Error.Println("no progress detected, withholding systemd watchdog ping")
+				continue
+			}
+			if err := n.Notify(Watchdog); err != nil {
+				log.Error.Printf("failed to send watchdog ping to systemd: %s", err)
+			}
+		case <-cancelChan:
+			return
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// listen creates a stand in for the systemd notification socket and points
+// NOTIFY_SOCKET at it.
+func listen(t *testing.T) *net.UnixConn {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("failed to listen on %s: %s", path, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	return conn
+}
+
+// read reads a single notification from conn.
+func read(t *testing.T, conn *net.UnixConn) string {
+	buff := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Duration(2) * time.Second))
+	n, err := conn.Read(buff)
+	if err != nil {
+		t.Fatalf("failed to read notification: %s", err)
+	}
+	return string(buff[:n])
+}
+
+func TestNotify(t *testing.T) {
+	conn := listen(t)
+
+	n := NewNotifier()
+	if !n.Enabled() {
+		t.Fatalf("notifier not enabled with NOTIFY_SOCKET set")
+	}
+	if err := n.Notify(Ready); err != nil {
+		t.Fatalf("failed to notify: %s", err)
+	}
+	if got := read(t, conn); got != Ready {
+		t.Fatalf("expected %s, got %s", Ready, got)
+	}
+}
+
+func TestNotifyDisabled(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := NewNotifier().Notify(Ready); err != nil {
+		t.Fatalf("disabled notifier returned error: %s", err)
+	}
+}
+
+func TestRunWatchdog(t *testing.T) {
+	conn := listen(t)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	n := NewNotifier()
+	if n.WatchdogInterval() != time.Duration(100)*time.Millisecond {
+		t.Fatalf("unexpected watchdog interval %s", n.WatchdogInterval())
+	}
+
+	progress := make(chan bool, 1)
+	progress <- false
+	cancel := make(chan struct{})
+	defer close(cancel)
+	go n.Run(cancel, time.Minute, func() string { return "ok" }, func() bool {
+		select {
+		case p := <-progress:
+			return p
+		default:
+			return true
+		}
+	})
+
+	// The first tick has no progress so only the status is sent.
+	if got := read(t, conn); got != "STATUS=ok" {
+		t.Fatalf("expected status, got %s", got)
+	}
+	if got := read(t, conn); got != "STATUS=ok" {
+		t.Fatalf("expected watchdog to be withheld, got %s", got)
+	}
+	if got := read(t, conn); got != Watchdog {
+		t.Fatalf("expected %s, got %s", Watchdog, got)
+	}
+}
+
+func TestWatchdogOtherPID(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", "1")
+	if os.Getpid() == 1 {
+		t.Skip("running as pid 1")
+	}
+	if NewNotifier().WatchdogInterval() != 0 {
+		t.Fatalf("watchdog enabled for another pid")
+	}
+}
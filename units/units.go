@@ -0,0 +1,246 @@
+// Package units knows the physical quantity and unit of fields from the unit
+// suffix of their names, such as temperature_F or wind_avg_km_h, and converts
+// them between the SI and imperial systems.
+//
+// Fields are renamed by replacing the suffix with the unit of the system so
+// temperature_F becomes temperature_C in SI. Fields without a known suffix
+// are left alone.
+package units
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// The unit systems.
+const (
+	SI       = "si"
+	Imperial = "imperial"
+)
+
+// The physical quantities.
+const (
+	Temperature = "temperature"
+	Speed       = "speed"
+	Length      = "length"
+	Pressure    = "pressure"
+	RainRate    = "rain_rate"
+)
+
+// unit describes a unit by the suffix of the fields using it. A value in the
+// unit is converted to the base unit of its quantity by multiplying it by
+// scale and adding offset.
+type unit struct {
+	suffix   string
+	quantity string
+	scale    float64
+	offset   float64
+}
+
+// known are the known units. The first unit of each quantity is the base
+// unit.
+var known = []unit{
+	{"C", Temperature, 1, 0},
+	{"c", Temperature, 1, 0},
+	{"F", Temperature, 5.0 / 9, -32 * 5.0 / 9},
+	{"f", Temperature, 5.0 / 9, -32 * 5.0 / 9},
+	{"km_h", Speed, 1, 0},
+	{"kph", Speed, 1, 0},
+	{"mph", Speed, 1.609344, 0},
+	{"mi_h", Speed, 1.609344, 0},
+	{"m_s", Speed, 3.6, 0},
+	{"mm", Length, 1, 0},
+	{"in", Length, 25.4, 0},
+	{"inch", Length, 25.4, 0},
+	{"hPa", Pressure, 1, 0},
+	{"kPa", Pressure, 10, 0},
+	{"inHg", Pressure, 33.8638866667, 0},
+	{"mm_h", RainRate, 1, 0},
+	{"in_h", RainRate, 25.4, 0},
+	{"inch_h", RainRate, 25.4, 0},
+}
+
+// systems are the suffixes of the unit of each quantity in each system.
+var systems = map[string]map[string]string{
+	SI:       {Temperature: "C", Speed: "km_h", Length: "mm", Pressure: "hPa", RainRate: "mm_h"},
+	Imperial: {Temperature: "F", Speed: "mph", Length: "in", Pressure: "inHg", RainRate: "in_h"},
+}
+
+// A Field is a field name split into its base name and unit.
+type Field struct {
+	// Base is the name without the unit suffix, such as wind_avg.
+	Base string
+
+	// Unit is the suffix of the unit, such as km_h.
+	Unit string
+
+	// Quantity is the physical quantity of the unit.
+	Quantity string
+
+	// Raw is true if the name ends in _raw after the unit, as the fields
+	// keeping the uncalibrated value do.
+	Raw bool
+}
+
+// Name returns the name of the field in the unit.
+func (f Field) Name(unit string) string {
+	name := f.Base + "_" + unit
+	if f.Raw {
+		name += "_raw"
+	}
+	return name
+}
+
+// Parse returns the base name and unit of the field. False is returned if
+// the name does not end in a known unit.
+func Parse(name string) (Field, bool) {
+	raw := strings.HasSuffix(name, "_raw")
+	trimmed := strings.TrimSuffix(name, "_raw")
+
+	// The longest suffix wins so _inHg is not taken for _in.
+	var best *unit
+	for i, u := range known {
+		if strings.HasSuffix(trimmed, "_"+u.suffix) && len(trimmed) > len(u.suffix)+1 && (best == nil || len(u.suffix) > len(best.suffix)) {
+			best = &known[i]
+		}
+	}
+	if best == nil {
+		return Field{}, false
+	}
+
+	return Field{
+		Base:     strings.TrimSuffix(trimmed, "_"+best.suffix),
+		Unit:     best.suffix,
+		Quantity: best.quantity,
+		Raw:      raw,
+	}, true
+}
+
+// lookup returns the unit with the suffix.
+func lookup(suffix string) (unit, bool) {
+	for _, u := range known {
+		if u.suffix == suffix {
+			return u, true
+		}
+	}
+	return unit{}, false
+}
+
+// Convert converts v from the unit with the suffix from to the unit with the
+// suffix to. An error is returned if the units are unknown or measure
+// different quantities.
+func Convert(v float64, from string, to string) (float64, error) {
+	f, ok := lookup(from)
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %s", from)
+	}
+	t, ok := lookup(to)
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %s", to)
+	}
+	if f.quantity != t.quantity {
+		return 0, fmt.Errorf("can not convert %s %s to %s %s", f.quantity, from, t.quantity, to)
+	}
+	if f.scale == t.scale && f.offset == t.offset {
+		return v, nil
+	}
+
+	base := v*f.scale + f.offset
+	return Round((base - t.offset) / t.scale), nil
+}
+
+// ValidSystem returns an error if the system is not known. An empty system
+// leaves fields as they are.
+func ValidSystem(system string) error {
+	if _, ok := systems[system]; !ok && system != "" {
+		return fmt.Errorf("invalid unit system %q, must be %s or %s", system, SI, Imperial)
+	}
+	return nil
+}
+
+// Unit returns the suffix of the unit of the quantity in the system. False is
+// returned if the system or quantity is unknown.
+func Unit(system string, quantity string) (string, bool) {
+	u, ok := systems[system][quantity]
+	return u, ok
+}
+
+// Rename returns the name a numeric field named name is given by Normalize in
+// the system. False is returned if the field keeps its name.
+func Rename(system string, name string) (string, bool) {
+	f, ok := Parse(name)
+	if !ok {
+		return name, false
+	}
+	to, ok := Unit(system, f.Quantity)
+	if !ok || to == f.Unit {
+		return name, false
+	}
+	return f.Name(to), true
+}
+
+// Normalize converts every numeric field with a known unit to the unit of
+// its quantity in the system, renaming it to match. Integer fields become
+// floats. A field is not renamed if a field with the new name already
+// exists. Nothing is done if the system is empty or unknown.
+func Normalize(system string, fields map[string]interface{}) {
+	if _, ok := systems[system]; !ok {
+		return
+	}
+
+	// The names are sorted so the outcome of a clash does not depend on the
+	// order of the map.
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f, ok := Parse(name)
+		if !ok {
+			continue
+		}
+		v, ok := Number(fields[name])
+		if !ok {
+			continue
+		}
+		to, _ := Unit(system, f.Quantity)
+		if to == f.Unit {
+			continue
+		}
+		newName := f.Name(to)
+		if _, exists := fields[newName]; exists {
+			continue
+		}
+
+		converted, err := Convert(v, f.Unit, to)
+		if err != nil {
+			continue
+		}
+		delete(fields, name)
+		fields[newName] = converted
+	}
+}
+
+// Number returns a numeric field value as a float64. False is returned if
+// the field is missing or not a number.
+func Number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// Round rounds v to six decimal places to hide the error of conversions and
+// sums of field values.
+func Round(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
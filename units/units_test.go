@@ -0,0 +1,68 @@
+package units
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := map[string]Field{
+		"temperature_F":              {Base: "temperature", Unit: "F", Quantity: Temperature},
+		"wind_avg_km_h":              {Base: "wind_avg", Unit: "km_h", Quantity: Speed},
+		"rainfall_accumulation_inch": {Base: "rainfall_accumulation", Unit: "inch", Quantity: Length},
+		"pressure_inHg":              {Base: "pressure", Unit: "inHg", Quantity: Pressure},
+		"rain_rate_in_h":             {Base: "rain_rate", Unit: "in_h", Quantity: RainRate},
+		"humidity_raw":               {},
+		"temperature_C_raw":          {Base: "temperature", Unit: "C", Quantity: Temperature, Raw: true},
+	}
+	for name, want := range tests {
+		got, ok := Parse(name)
+		if ok != (want != Field{}) || got != want {
+			t.Errorf("%s: expected %+v, got %+v %v", name, want, got, ok)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	fields := map[string]interface{}{
+		"temperature_F":              int64(212),
+		"temperature_F_raw":          32.0,
+		"wind_speed_mph":             10.0,
+		"rainfall_accumulation_inch": 1.0,
+		"humidity":                   int64(40),
+		"dew_point_C":                5.0,
+	}
+	Normalize(SI, fields)
+	want := map[string]interface{}{
+		"temperature_C":            100.0,
+		"temperature_C_raw":        0.0,
+		"wind_speed_km_h":          16.09344,
+		"rainfall_accumulation_mm": 25.4,
+		"humidity":                 int64(40),
+		"dew_point_C":              5.0,
+	}
+	if len(fields) != len(want) {
+		t.Errorf("unexpected fields %v", fields)
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("expected %s to be %v, got %v", k, v, fields[k])
+		}
+	}
+
+	Normalize(Imperial, fields)
+	if fields["temperature_F"] != 212.0 || fields["rainfall_accumulation_in"] != 1.0 || fields["wind_speed_mph"] != 10.0 {
+		t.Errorf("unexpected imperial fields %v", fields)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	if v, err := Convert(1013.25, "hPa", "inHg"); err != nil || v < 29.91 || v > 29.93 {
+		t.Errorf("expected 29.92 inHg, got %v %v", v, err)
+	}
+	if _, err := Convert(1, "C", "mph"); err == nil {
+		t.Errorf("expected converting between quantities to fail")
+	}
+	if err := ValidSystem("metric"); err == nil {
+		t.Errorf("expected unknown system to fail")
+	}
+}